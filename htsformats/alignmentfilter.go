@@ -0,0 +1,85 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module alignmentfilter defines SamRecordFilters selecting alignments by
+// mapping quality, aligned length and number of mismatches
+package htsformats
+
+// MapqFilter matches alignments with at least a minimum MAPQ. An unavailable
+// MAPQ (255) is not a high score, so such alignments only match if explicitly
+// kept, or if the minimum is 0 and the filter matches every alignment
+type MapqFilter struct {
+	min             int
+	keepUnavailable bool
+}
+
+// NewMapqFilter constructs a MapqFilter for a minimum MAPQ, and whether
+// alignments with unavailable MAPQ should match
+func NewMapqFilter(min int, keepUnavailable bool) *MapqFilter {
+	mapqFilter := new(MapqFilter)
+	mapqFilter.min = min
+	mapqFilter.keepUnavailable = keepUnavailable
+	return mapqFilter
+}
+
+// Matches indicates whether the alignment's MAPQ satisfies the filter
+func (mapqFilter *MapqFilter) Matches(samRecord *SamRecord) (bool, error) {
+	mapq, err := samRecord.Mapq()
+	if err != nil {
+		return false, err
+	}
+	if mapq == MapqUnavailable {
+		return mapqFilter.keepUnavailable || mapqFilter.min == 0, nil
+	}
+	return mapq >= mapqFilter.min, nil
+}
+
+// AlignedLengthFilter matches alignments with at least a minimum number of
+// query bases aligned to the reference, according to the CIGAR
+type AlignedLengthFilter struct {
+	min int
+}
+
+// NewAlignedLengthFilter constructs an AlignedLengthFilter for a minimum
+// aligned length
+func NewAlignedLengthFilter(min int) *AlignedLengthFilter {
+	alignedLengthFilter := new(AlignedLengthFilter)
+	alignedLengthFilter.min = min
+	return alignedLengthFilter
+}
+
+// Matches indicates whether the alignment's aligned length satisfies the
+// filter. Alignments without a CIGAR have no aligned bases
+func (alignedLengthFilter *AlignedLengthFilter) Matches(samRecord *SamRecord) (bool, error) {
+	length, err := samRecord.AlignedLength()
+	if err != nil {
+		return false, err
+	}
+	return length >= alignedLengthFilter.min, nil
+}
+
+// MismatchFilter matches alignments with at most a maximum edit distance to
+// the reference, according to the NM tag
+type MismatchFilter struct {
+	max int64
+}
+
+// NewMismatchFilter constructs a MismatchFilter for a maximum NM value
+func NewMismatchFilter(max int64) *MismatchFilter {
+	mismatchFilter := new(MismatchFilter)
+	mismatchFilter.max = max
+	return mismatchFilter
+}
+
+// Matches indicates whether the alignment's NM satisfies the filter.
+// Alignments without NM do not match, as their mismatches are unknown
+func (mismatchFilter *MismatchFilter) Matches(samRecord *SamRecord) (bool, error) {
+	if !samRecord.HasTag("NM") {
+		return false, nil
+	}
+	nm, err := samRecord.IntTag("NM")
+	if err != nil {
+		return false, err
+	}
+	return nm <= mismatchFilter.max, nil
+}
@@ -0,0 +1,55 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module alignmentfilter_test tests alignmentfilter
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// alignmentFilterTC test cases for MapqFilter, AlignedLengthFilter, and
+// MismatchFilter, against a record with the given MAPQ, CIGAR and tags
+var alignmentFilterTC = []struct {
+	filter     SamRecordFilter
+	mapq       string
+	cigar      string
+	tags       string
+	expError   bool
+	expMatches bool
+}{
+	{NewMapqFilter(30, false), "30", "1M", "", false, true},
+	{NewMapqFilter(30, false), "29", "1M", "", false, false},
+	{NewMapqFilter(0, false), "255", "1M", "", false, true},
+	{NewMapqFilter(1, false), "255", "1M", "", false, false},
+	{NewMapqFilter(30, true), "255", "1M", "", false, true},
+	{NewMapqFilter(30, true), "254", "1M", "", false, true},
+	{NewMapqFilter(30, false), "x", "1M", "", true, false},
+	{NewMapqFilter(30, false), "256", "1M", "", true, false},
+	{NewAlignedLengthFilter(50), "60", "10S50M40S", "", false, true},
+	{NewAlignedLengthFilter(50), "60", "10S25M500N24M", "", false, false},
+	{NewAlignedLengthFilter(50), "60", "20=10X20=", "", false, true},
+	{NewAlignedLengthFilter(1), "60", "*", "", false, false},
+	{NewAlignedLengthFilter(1), "60", "1Q", "", true, false},
+	{NewMismatchFilter(2), "60", "1M", "\tNM:i:2", false, true},
+	{NewMismatchFilter(2), "60", "1M", "\tNM:i:3", false, false},
+	{NewMismatchFilter(2), "60", "1M", "\tNH:i:1", false, false},
+	{NewMismatchFilter(2), "60", "1M", "\tNM:Z:1", true, false},
+}
+
+// TestAlignmentFilterMatches tests the Matches function of alignment quality
+// filters
+func TestAlignmentFilterMatches(t *testing.T) {
+	for _, tc := range alignmentFilterTC {
+		samRecord := newTestSamRecord("r\t0\tchr1\t1\t" + tc.mapq + "\t" + tc.cigar + "\t*\t0\t0\t*\t*" + tc.tags)
+		matches, err := tc.filter.Matches(samRecord)
+		if tc.expError {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expMatches, matches, tc)
+		}
+	}
+}
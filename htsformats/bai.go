@@ -0,0 +1,155 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bai parses BAM indices (BAI), mapping genomic intervals to the chunks
+// of a BAM file holding overlapping alignments
+package htsformats
+
+import (
+	"errors"
+	"io"
+	"strconv"
+)
+
+// baiMagic magic bytes at the start of a BAI file
+var baiMagic = []byte("BAI\x01")
+
+// baiPseudoBin bin number holding the offsets and read counts of a reference,
+// rather than alignment chunks
+const baiPseudoBin = 37450
+
+// baiLinearShift log2 of the size of linear index windows (16 kbp)
+const baiLinearShift = 14
+
+// baiDepth number of levels of BAI bins below the bin spanning all positions
+const baiDepth = 5
+
+// baiMaxPosition the end of the largest interval indexed by BAI bins (2^29)
+const baiMaxPosition = 1 << 29
+
+// BaiReference index of the alignments on a single reference. Bins map each
+// bin number to its chunks, and Intervals hold the offset of the first
+// alignment overlapping each 16 kbp window. If present in the pseudo-bin,
+// Begin and End span the reference's alignments, with counts of mapped and
+// unmapped alignments placed on it
+type BaiReference struct {
+	Bins      map[uint32][]IndexChunk
+	Intervals []VirtualOffset
+	HasStats  bool
+	Begin     VirtualOffset
+	End       VirtualOffset
+	Mapped    uint64
+	Unmapped  uint64
+}
+
+// BaiIndex a parsed BAI file, indexing the references of a coordinate-sorted
+// BAM file in the order of its reference dictionary. NoCoordinate is the
+// number of unplaced unmapped alignments, if recorded
+type BaiIndex struct {
+	References      []*BaiReference
+	HasNoCoordinate bool
+	NoCoordinate    uint64
+}
+
+// ReadBai parses a BAI file
+func ReadBai(reader io.Reader) (*BaiIndex, error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != string(baiMagic) {
+		return nil, errors.New("Invalid BAI magic bytes")
+	}
+
+	indexReader := newIndexReader(reader, "BAI")
+	baiIndex := new(BaiIndex)
+	nRef := indexReader.count()
+	baiIndex.References = []*BaiReference{}
+	for i := 0; i < nRef && indexReader.err == nil; i++ {
+		baiReference := new(BaiReference)
+		baiReference.Bins = make(map[uint32][]IndexChunk)
+		nBin := indexReader.count()
+		for j := 0; j < nBin && indexReader.err == nil; j++ {
+			bin := indexReader.uint32()
+			chunks := indexReader.chunks()
+
+			// the pseudo-bin holds the reference's offsets and read counts
+			if bin == baiPseudoBin && len(chunks) == 2 {
+				baiReference.HasStats = true
+				baiReference.Begin, baiReference.End = chunks[0].Begin, chunks[0].End
+				baiReference.Mapped, baiReference.Unmapped = uint64(chunks[1].Begin), uint64(chunks[1].End)
+				continue
+			}
+			baiReference.Bins[bin] = append(baiReference.Bins[bin], chunks...)
+		}
+		nIntv := indexReader.count()
+		baiReference.Intervals = []VirtualOffset{}
+		for j := 0; j < nIntv && indexReader.err == nil; j++ {
+			baiReference.Intervals = append(baiReference.Intervals, VirtualOffset(indexReader.uint64()))
+		}
+		baiIndex.References = append(baiIndex.References, baiReference)
+	}
+	if indexReader.err != nil {
+		return nil, indexReader.err
+	}
+
+	// the count of unplaced unmapped alignments is optional
+	baiIndex.HasNoCoordinate, baiIndex.NoCoordinate = indexReader.noCoordinate()
+	return baiIndex, nil
+}
+
+// Query gets the chunks holding alignments that may overlap an interval of a
+// reference, given by its id in the reference dictionary and 0-based start
+// (inclusive) and end (exclusive) positions, either of which may be
+// RegionUnbounded. Chunks are sorted, with overlapping and adjacent chunks
+// merged. Returns an error if the start is beyond the positions BAI indexes
+func (baiIndex *BaiIndex) Query(refID int, start int, end int) ([]IndexChunk, error) {
+	if refID < 0 || refID >= len(baiIndex.References) {
+		return nil, errors.New("BAI reference id out of range: " + strconv.Itoa(refID))
+	}
+	if start != RegionUnbounded && start >= baiMaxPosition {
+		return nil, errors.New("Start position " + strconv.Itoa(start) + " is beyond the " + strconv.Itoa(baiMaxPosition) + " (2^29) positions indexed by BAI, use a CSI index")
+	}
+	start, end = indexInterval(start, end, baiMaxPosition)
+	baiReference := baiIndex.References[refID]
+
+	// chunks ending before the first alignment overlapping the start window
+	// cannot overlap the interval
+	minOffset := VirtualOffset(0)
+	if len(baiReference.Intervals) > 0 {
+		window := start >> baiLinearShift
+		if window >= len(baiReference.Intervals) {
+			window = len(baiReference.Intervals) - 1
+		}
+		minOffset = baiReference.Intervals[window]
+	}
+
+	chunks := []IndexChunk{}
+	for _, bin := range reg2bins(start, end) {
+		for _, chunk := range baiReference.Bins[uint32(bin)] {
+			if chunk.End > minOffset {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+	return mergeIndexChunks(chunks), nil
+}
+
+// UnplacedOffset gets the offset following the alignments of every reference,
+// where unplaced unmapped alignments begin in a coordinate-sorted BAM file.
+// Returns false if no reference has alignments, so the offset is unknown
+func (baiIndex *BaiIndex) UnplacedOffset() (VirtualOffset, bool) {
+	offset, ok := VirtualOffset(0), false
+	for _, baiReference := range baiIndex.References {
+		if baiReference.HasStats && baiReference.End >= offset {
+			offset, ok = baiReference.End, true
+		}
+		if end, found := maxChunkEnd(baiReference.Bins); found && end >= offset {
+			offset, ok = end, true
+		}
+	}
+	return offset, ok
+}
+
+// reg2bins gets the bins that may hold alignments overlapping a 0-based,
+// half-open interval, as specified in the SAM specification
+func reg2bins(beg int, end int) []int {
+	return indexReg2bins(beg, end, baiLinearShift, baiDepth)
+}
@@ -0,0 +1,171 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bai_test tests bai
+package htsformats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// encodeTestBai serializes a BaiIndex in the BAI format, with bins in
+// ascending order
+func encodeTestBai(baiIndex *BaiIndex) []byte {
+	buf := append([]byte{}, baiMagic...)
+	buf = appendInt32(buf, int32(len(baiIndex.References)))
+	for _, baiReference := range baiIndex.References {
+		bins := []int{}
+		for bin := range baiReference.Bins {
+			bins = append(bins, int(bin))
+		}
+		sort.Ints(bins)
+		nBin := len(bins)
+		if baiReference.HasStats {
+			nBin++
+		}
+		buf = appendInt32(buf, int32(nBin))
+		for _, bin := range bins {
+			buf = appendUint32(buf, uint32(bin))
+			buf = appendInt32(buf, int32(len(baiReference.Bins[uint32(bin)])))
+			for _, chunk := range baiReference.Bins[uint32(bin)] {
+				buf = appendUint64(buf, uint64(chunk.Begin))
+				buf = appendUint64(buf, uint64(chunk.End))
+			}
+		}
+		if baiReference.HasStats {
+			buf = appendUint32(buf, baiPseudoBin)
+			buf = appendInt32(buf, 2)
+			buf = appendUint64(buf, uint64(baiReference.Begin))
+			buf = appendUint64(buf, uint64(baiReference.End))
+			buf = appendUint64(buf, baiReference.Mapped)
+			buf = appendUint64(buf, baiReference.Unmapped)
+		}
+		buf = appendInt32(buf, int32(len(baiReference.Intervals)))
+		for _, interval := range baiReference.Intervals {
+			buf = appendUint64(buf, uint64(interval))
+		}
+	}
+	if baiIndex.HasNoCoordinate {
+		buf = appendUint64(buf, baiIndex.NoCoordinate)
+	}
+	return buf
+}
+
+// openTestBai reads the index of the BAM test file, covering a single
+// reference whose alignments span three BGZF blocks
+func openTestBai() *BaiIndex {
+	file, _ := os.Open("../data/test/input/modify-sam.bam.bai")
+	defer file.Close()
+	baiIndex, err := ReadBai(file)
+	if err != nil {
+		panic(err)
+	}
+	return baiIndex
+}
+
+// baiIndexQueryTC test cases for BaiIndex Query on the BAM test file index
+var baiIndexQueryTC = []struct {
+	start     int
+	end       int
+	expChunks []IndexChunk
+}{
+	// within a single bin
+	{4861000, 4862000, []IndexChunk{{NewVirtualOffset(0, 1754), NewVirtualOffset(0, 2259)}}},
+	{24613600, 24613700, []IndexChunk{{NewVirtualOffset(0, 2259), NewVirtualOffset(1423, 3157)}}},
+	{36691567, 36692000, []IndexChunk{{NewVirtualOffset(1423, 3157), NewVirtualOffset(1423, 3674)}}},
+	// no alignments
+	{100, 200, []IndexChunk{}},
+	// the whole reference, with adjacent chunks merged
+	{RegionUnbounded, RegionUnbounded, []IndexChunk{{NewVirtualOffset(0, 1754), NewVirtualOffset(3285, 0)}}},
+	{24613000, RegionUnbounded, []IndexChunk{{NewVirtualOffset(0, 2259), NewVirtualOffset(3285, 0)}}},
+}
+
+// TestReadBai tests that ReadBai parses each part of the index, including the
+// pseudo-bin and the count of unplaced unmapped alignments
+func TestReadBai(t *testing.T) {
+	expected := &BaiIndex{
+		References: []*BaiReference{
+			{
+				Bins: map[uint32][]IndexChunk{
+					0:    {{NewVirtualOffset(0, 100), NewVirtualOffset(0, 200)}},
+					4681: {{NewVirtualOffset(0, 200), NewVirtualOffset(500, 10)}, {NewVirtualOffset(900, 0), NewVirtualOffset(900, 50)}},
+				},
+				Intervals: []VirtualOffset{NewVirtualOffset(0, 100)},
+				HasStats:  true,
+				Begin:     NewVirtualOffset(0, 100),
+				End:       NewVirtualOffset(900, 50),
+				Mapped:    7,
+				Unmapped:  1,
+			},
+			{Bins: map[uint32][]IndexChunk{}, Intervals: []VirtualOffset{}},
+		},
+		HasNoCoordinate: true,
+		NoCoordinate:    3,
+	}
+	actual, err := ReadBai(bytes.NewReader(encodeTestBai(expected)))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+
+	baiIndex := openTestBai()
+	assert.Equal(t, 1, len(baiIndex.References))
+	assert.Equal(t, uint64(30), baiIndex.References[0].Mapped)
+	unplacedOffset, ok := baiIndex.UnplacedOffset()
+	assert.True(t, ok)
+	assert.Equal(t, NewVirtualOffset(3285, 0), unplacedOffset)
+
+	// without alignments on any reference, the offset is unknown
+	_, ok = (&BaiIndex{References: []*BaiReference{{Bins: map[uint32][]IndexChunk{}, Intervals: []VirtualOffset{}}}}).UnplacedOffset()
+	assert.False(t, ok)
+}
+
+// TestReadBaiError tests ReadBai on invalid input
+func TestReadBaiError(t *testing.T) {
+	_, err := ReadBai(bytes.NewReader([]byte("BAM\x01")))
+	assert.Equal(t, "Invalid BAI magic bytes", err.Error())
+
+	data, _ := ioutil.ReadFile("../data/test/input/modify-sam.bam.bai")
+	_, err = ReadBai(bytes.NewReader(data[:100]))
+	assert.Equal(t, "Truncated BAI index", err.Error())
+
+	_, err = ReadBai(bytes.NewReader(append(append([]byte{}, baiMagic...), 0xff, 0xff, 0xff, 0xff)))
+	assert.Equal(t, "Invalid BAI count: -1", err.Error())
+}
+
+// TestBaiIndexQuery tests BaiIndex Query function
+func TestBaiIndexQuery(t *testing.T) {
+	baiIndex := openTestBai()
+	for _, tc := range baiIndexQueryTC {
+		chunks, err := baiIndex.Query(0, tc.start, tc.end)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expChunks, chunks, tc.start, tc.end)
+	}
+	_, err := baiIndex.Query(1, 0, 100)
+	assert.Equal(t, "BAI reference id out of range: 1", err.Error())
+	_, err = baiIndex.Query(0, 1<<29, RegionUnbounded)
+	assert.Equal(t, "Start position 536870912 is beyond the 536870912 (2^29) positions indexed by BAI, use a CSI index", err.Error())
+}
+
+// TestReg2bins tests reg2bins function, which is expected to include the bin
+// computed by reg2bin for any alignment within the interval
+func TestReg2bins(t *testing.T) {
+	assert.Equal(t, []int{0, 1, 9, 73, 585, 4681}, reg2bins(0, 1))
+	assert.Equal(t, []int{0, 1, 9, 73, 585, 4681, 4682}, reg2bins(16000, 17000))
+	for _, interval := range [][2]int{{0, 100}, {16383, 16385}, {1 << 20, 1<<20 + 5000}, {100000, 9000000}} {
+		assert.Contains(t, reg2bins(interval[0], interval[1]), reg2bin(interval[0], interval[1]))
+	}
+}
+
+// TestVirtualOffset tests VirtualOffset functions
+func TestVirtualOffset(t *testing.T) {
+	virtualOffset := NewVirtualOffset(1423, 3157)
+	assert.Equal(t, VirtualOffset(1423<<16|3157), virtualOffset)
+	assert.Equal(t, int64(1423), virtualOffset.Compressed())
+	assert.Equal(t, 3157, virtualOffset.Uncompressed())
+	assert.Equal(t, "1423:3157", virtualOffset.String())
+}
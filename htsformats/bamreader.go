@@ -0,0 +1,390 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bamreader decodes the binary BAM format, producing the header text,
+// reference dictionary, and SamRecords for each alignment
+package htsformats
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// bamMagic magic bytes at the start of an uncompressed BAM stream
+var bamMagic = []byte{'B', 'A', 'M', 1}
+
+// bamCigarOps CIGAR operation characters, indexed by their BAM operation code
+var bamCigarOps = "MIDNSHP=X"
+
+// bamSeqBases sequence characters, indexed by their 4-bit BAM encoding
+var bamSeqBases = "=ACMGRSVTWYHKDBN"
+
+// bamFixedLength length of the fixed-size portion of a BAM alignment record,
+// excluding the block_size prefix
+const bamFixedLength = 32
+
+// BamReference a single entry of the BAM reference dictionary
+type BamReference struct {
+	Name   string
+	Length int
+}
+
+// BamReader reads alignments from an uncompressed BAM stream. The header and
+// reference dictionary are read on construction
+type BamReader struct {
+	reader     io.Reader
+	header     string
+	references []BamReference
+}
+
+// NewBamReader constructs a BamReader over an uncompressed BAM stream (ie. the
+// output of a BgzfReader), reading the magic bytes, header text and reference
+// dictionary
+func NewBamReader(reader io.Reader) (*BamReader, error) {
+	bamReader := new(BamReader)
+	bamReader.reader = reader
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != string(bamMagic) {
+		return nil, errors.New("Invalid BAM magic bytes")
+	}
+
+	// header text, possibly NUL-padded
+	lText, err := bamReader.readInt32()
+	if err != nil || lText < 0 {
+		return nil, errors.New("Invalid BAM header length")
+	}
+	text := make([]byte, lText)
+	if _, err := io.ReadFull(reader, text); err != nil {
+		return nil, errors.New("Truncated BAM header")
+	}
+	bamReader.header = strings.TrimRight(string(text), "\x00")
+
+	// reference dictionary
+	nRef, err := bamReader.readInt32()
+	if err != nil || nRef < 0 {
+		return nil, errors.New("Invalid BAM reference count")
+	}
+	bamReader.references = make([]BamReference, nRef)
+	for i := 0; i < int(nRef); i++ {
+		lName, err := bamReader.readInt32()
+		if err != nil || lName < 1 {
+			return nil, errors.New("Invalid BAM reference name length")
+		}
+		name := make([]byte, lName)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return nil, errors.New("Truncated BAM reference dictionary")
+		}
+		lRef, err := bamReader.readInt32()
+		if err != nil {
+			return nil, errors.New("Truncated BAM reference dictionary")
+		}
+		bamReader.references[i] = BamReference{string(name[:lName-1]), int(lRef)}
+	}
+	return bamReader, nil
+}
+
+// readInt32 reads a single little-endian int32 from the stream
+func (bamReader *BamReader) readInt32() (int32, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(bamReader.reader, buf); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(buf)), nil
+}
+
+// Header gets the plain text SAM header stored in the BAM
+func (bamReader *BamReader) Header() string {
+	return bamReader.header
+}
+
+// HeaderLines gets the SAM header as a list of lines. If the BAM has no header
+// text, @SQ lines are derived from the reference dictionary
+func (bamReader *BamReader) HeaderLines() []string {
+	lines := []string{}
+	for _, line := range strings.Split(bamReader.header, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		for _, reference := range bamReader.references {
+			lines = append(lines, "@SQ\tSN:"+reference.Name+"\tLN:"+strconv.Itoa(reference.Length))
+		}
+	}
+	return lines
+}
+
+// References gets the reference dictionary
+func (bamReader *BamReader) References() []BamReference {
+	return bamReader.references
+}
+
+// Next reads and decodes the next alignment. Returns io.EOF once all
+// alignments have been read
+func (bamReader *BamReader) Next() (*SamRecord, error) {
+	data, err := bamReader.NextData()
+	if err != nil {
+		return nil, err
+	}
+	return bamReader.decodeRecord(data)
+}
+
+// NextData reads the binary representation of the next alignment (excluding
+// block_size) without decoding it, so that it can be decoded concurrently by
+// DecodeRecord. Returns io.EOF once all alignments have been read
+func (bamReader *BamReader) NextData() ([]byte, error) {
+	blockSize, err := bamReader.readInt32()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, errors.New("Truncated BAM record")
+	}
+	if blockSize < bamFixedLength {
+		return nil, errors.New("Invalid BAM record size")
+	}
+	data := make([]byte, blockSize)
+	if _, err := io.ReadFull(bamReader.reader, data); err != nil {
+		return nil, errors.New("Truncated BAM record")
+	}
+	return data, nil
+}
+
+// DecodeRecord decodes the binary representation of an alignment read by
+// NextData. Safe for concurrent use, as only the reference dictionary is read
+func (bamReader *BamReader) DecodeRecord(data []byte) (*SamRecord, error) {
+	return bamReader.decodeRecord(data)
+}
+
+// referenceName gets the reference name for a reference id, "*" if unset
+func (bamReader *BamReader) referenceName(refID int32) (string, error) {
+	if refID == -1 {
+		return "*", nil
+	}
+	if refID < 0 || int(refID) >= len(bamReader.references) {
+		return "", errors.New("BAM record reference id out of range: " + strconv.Itoa(int(refID)))
+	}
+	return bamReader.references[refID].Name, nil
+}
+
+// decodeRecord converts the binary representation of a single alignment
+// (excluding block_size) into a SamRecord
+func (bamReader *BamReader) decodeRecord(data []byte) (*SamRecord, error) {
+	refID := int32(binary.LittleEndian.Uint32(data[0:4]))
+	pos := int32(binary.LittleEndian.Uint32(data[4:8]))
+	lReadName := int(data[8])
+	mapq := int(data[9])
+	nCigarOp := int(binary.LittleEndian.Uint16(data[12:14]))
+	flag := int(binary.LittleEndian.Uint16(data[14:16]))
+	lSeq := int(binary.LittleEndian.Uint32(data[16:20]))
+	nextRefID := int32(binary.LittleEndian.Uint32(data[20:24]))
+	nextPos := int32(binary.LittleEndian.Uint32(data[24:28]))
+	tlen := int32(binary.LittleEndian.Uint32(data[28:32]))
+
+	// check the variable-length portion fits within the record
+	offset := bamFixedLength
+	varLength := lReadName + nCigarOp*4 + (lSeq+1)/2 + lSeq
+	if lReadName < 1 || offset+varLength > len(data) {
+		return nil, errors.New("Invalid BAM record lengths")
+	}
+
+	fields := make([]string, 11)
+	fields[0] = string(data[offset : offset+lReadName-1])
+	offset += lReadName
+
+	rname, err := bamReader.referenceName(refID)
+	if err != nil {
+		return nil, err
+	}
+	fields[1] = strconv.Itoa(flag)
+	fields[2] = rname
+	fields[3] = strconv.Itoa(int(pos) + 1)
+	fields[4] = strconv.Itoa(mapq)
+
+	// CIGAR
+	cigar := decodeBamCigar(data[offset : offset+nCigarOp*4])
+	offset += nCigarOp * 4
+
+	// mate reference, "=" if identical to this record's reference
+	rnext := "*"
+	if nextRefID != -1 {
+		if nextRefID == refID {
+			rnext = "="
+		} else if rnext, err = bamReader.referenceName(nextRefID); err != nil {
+			return nil, err
+		}
+	}
+	fields[6] = rnext
+	fields[7] = strconv.Itoa(int(nextPos) + 1)
+	fields[8] = strconv.Itoa(int(tlen))
+
+	// SEQ, 4 bits per base
+	seq := "*"
+	if lSeq > 0 {
+		bases := make([]byte, lSeq)
+		for i := 0; i < lSeq; i++ {
+			packed := data[offset+i/2]
+			if i%2 == 0 {
+				bases[i] = bamSeqBases[packed>>4]
+			} else {
+				bases[i] = bamSeqBases[packed&0xf]
+			}
+		}
+		seq = string(bases)
+	}
+	fields[9] = seq
+	offset += (lSeq + 1) / 2
+
+	// QUAL, phred scores without the +33 offset, 0xff if absent
+	qual := "*"
+	if lSeq > 0 && data[offset] != 0xff {
+		scores := make([]byte, lSeq)
+		for i := 0; i < lSeq; i++ {
+			scores[i] = data[offset+i] + 33
+		}
+		qual = string(scores)
+	}
+	fields[10] = qual
+	offset += lSeq
+
+	// auxiliary tags
+	tags, err := decodeBamTags(data[offset:])
+	if err != nil {
+		return nil, err
+	}
+
+	// a CIGAR too long for the binary field is stored in the CG tag, with a
+	// placeholder of <lSeq>S<refLen>N in the CIGAR field
+	for i, tag := range tags {
+		if strings.HasPrefix(tag, "CG:B:I,") && nCigarOp == 2 && strings.HasPrefix(cigar, strconv.Itoa(lSeq)+"S") {
+			values := strings.Split(tag[len("CG:B:I,"):], ",")
+			ops := make([]byte, 0, len(values)*4)
+			for _, value := range values {
+				op, _ := strconv.ParseUint(value, 10, 32)
+				buf := make([]byte, 4)
+				binary.LittleEndian.PutUint32(buf, uint32(op))
+				ops = append(ops, buf...)
+			}
+			cigar = decodeBamCigar(ops)
+			tags = append(tags[:i], tags[i+1:]...)
+			break
+		}
+	}
+	fields[5] = cigar
+
+	return NewSamRecord(strings.Join(append(fields, tags...), "\t"))
+}
+
+// decodeBamCigar converts binary CIGAR operations to their text form
+func decodeBamCigar(data []byte) string {
+	if len(data) == 0 {
+		return "*"
+	}
+	var builder strings.Builder
+	for i := 0; i+4 <= len(data); i += 4 {
+		op := binary.LittleEndian.Uint32(data[i : i+4])
+		builder.WriteString(strconv.FormatUint(uint64(op>>4), 10))
+		if int(op&0xf) < len(bamCigarOps) {
+			builder.WriteByte(bamCigarOps[op&0xf])
+		} else {
+			builder.WriteByte('?')
+		}
+	}
+	return builder.String()
+}
+
+// bamTagValueSize size in bytes of a single fixed-width BAM tag value, by type
+var bamTagValueSize = map[byte]int{
+	'A': 1, 'c': 1, 'C': 1, 's': 2, 'S': 2, 'i': 4, 'I': 4, 'f': 4,
+}
+
+// decodeBamTagValue converts a single fixed-width tag value to text
+func decodeBamTagValue(valueType byte, data []byte) string {
+	switch valueType {
+	case 'A':
+		return string(data[0:1])
+	case 'c':
+		return strconv.Itoa(int(int8(data[0])))
+	case 'C':
+		return strconv.Itoa(int(data[0]))
+	case 's':
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(data))))
+	case 'S':
+		return strconv.Itoa(int(binary.LittleEndian.Uint16(data)))
+	case 'i':
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(data))))
+	case 'I':
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data)), 10)
+	default:
+		value := math.Float32frombits(binary.LittleEndian.Uint32(data))
+		return strconv.FormatFloat(float64(value), 'g', -1, 32)
+	}
+}
+
+// decodeBamTags converts the binary auxiliary data of a record into a list of
+// TAG:TYPE:VALUE strings. All integer types are represented as SAM type 'i'
+func decodeBamTags(data []byte) ([]string, error) {
+	tags := []string{}
+	offset := 0
+	for offset < len(data) {
+		if offset+3 > len(data) {
+			return nil, errors.New("Truncated BAM auxiliary data")
+		}
+		key := string(data[offset : offset+2])
+		valueType := data[offset+2]
+		offset += 3
+
+		switch valueType {
+		case 'A', 'c', 'C', 's', 'S', 'i', 'I', 'f':
+			size := bamTagValueSize[valueType]
+			if offset+size > len(data) {
+				return nil, errors.New("Truncated BAM auxiliary data")
+			}
+			samType := "i"
+			if valueType == 'A' || valueType == 'f' {
+				samType = string(valueType)
+			}
+			tags = append(tags, key+":"+samType+":"+decodeBamTagValue(valueType, data[offset:offset+size]))
+			offset += size
+		case 'Z', 'H':
+			end := offset
+			for end < len(data) && data[end] != 0 {
+				end++
+			}
+			if end >= len(data) {
+				return nil, errors.New("Unterminated BAM auxiliary string")
+			}
+			tags = append(tags, key+":"+string(valueType)+":"+string(data[offset:end]))
+			offset = end + 1
+		case 'B':
+			if offset+5 > len(data) {
+				return nil, errors.New("Truncated BAM auxiliary data")
+			}
+			subtype := data[offset]
+			count := int(binary.LittleEndian.Uint32(data[offset+1 : offset+5]))
+			offset += 5
+			size, ok := bamTagValueSize[subtype]
+			if !ok || subtype == 'A' {
+				return nil, errors.New("Invalid BAM auxiliary array type: " + string(subtype))
+			}
+			if count < 0 || offset+count*size > len(data) {
+				return nil, errors.New("Truncated BAM auxiliary data")
+			}
+			values := make([]string, count+1)
+			values[0] = string(subtype)
+			for i := 0; i < count; i++ {
+				values[i+1] = decodeBamTagValue(subtype, data[offset:offset+size])
+				offset += size
+			}
+			tags = append(tags, key+":B:"+strings.Join(values, ","))
+		default:
+			return nil, errors.New("Invalid BAM auxiliary type: " + string(valueType))
+		}
+	}
+	return tags, nil
+}
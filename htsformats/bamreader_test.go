@@ -0,0 +1,121 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bamreader_test tests bamreader
+package htsformats
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeBamTagsTC test cases for decodeBamTags
+var decodeBamTagsTC = []struct {
+	data     []byte
+	expError bool
+	exp      []string
+}{
+	{[]byte{}, false, []string{}},
+	{[]byte("NHC\x01"), false, []string{"NH:i:1"}},
+	{[]byte("XSA+MDZ80T19\x00"), false, []string{"XS:A:+", "MD:Z:80T19"}},
+	{[]byte("XCc\xfeXss\x00\xffXiI\x00\x00\x00\x80"), false, []string{"XC:i:-2", "Xs:i:-256", "Xi:i:2147483648"}},
+	{[]byte("XFf\x00\x00\xc0\x3f"), false, []string{"XF:f:1.5"}},
+	{[]byte("XHH1AE301\x00"), false, []string{"XH:H:1AE301"}},
+	{[]byte("XBBs\x02\x00\x00\x00\x01\x00\xff\xff"), false, []string{"XB:B:s,1,-1"}},
+	{[]byte("XBBc\x00\x00\x00\x00"), false, []string{"XB:B:c"}},
+	// truncated values
+	{[]byte("NHi\x01"), true, nil},
+	{[]byte("MDZ100"), true, nil},
+	{[]byte("XBBs\x02\x00\x00\x00\x01\x00"), true, nil},
+	// invalid types
+	{[]byte("NHq\x01"), true, nil},
+	{[]byte("XBBZ\x01\x00\x00\x00\x00"), true, nil},
+}
+
+// decodeBamCigarTC test cases for decodeBamCigar
+var decodeBamCigarTC = []struct {
+	data []byte
+	exp  string
+}{
+	{[]byte{}, "*"},
+	{[]byte{0x40, 0x06, 0x00, 0x00}, "100M"},
+	{[]byte{0x14, 0x00, 0x00, 0x00, 0x80, 0x05, 0x00, 0x00, 0xb3, 0x20, 0x00, 0x00}, "1S88M523N"},
+}
+
+// TestNewBamReader tests NewBamReader header and reference parsing
+func TestNewBamReader(t *testing.T) {
+	file, _ := os.Open("../data/test/input/modify-sam.bam")
+	bamReader, err := NewBamReader(NewBgzfReader(file))
+	assert.Nil(t, err)
+	assert.Equal(t, []BamReference{{"chr1", 195471971}}, bamReader.References())
+	assert.True(t, strings.HasPrefix(bamReader.Header(), "@HD\tVN:1.4\tSO:coordinate\n"))
+	assert.Equal(t, 4, len(bamReader.HeaderLines()))
+}
+
+// TestNewBamReaderError tests NewBamReader on non-BAM input
+func TestNewBamReaderError(t *testing.T) {
+	_, err := NewBamReader(strings.NewReader("@HD\tVN:1.4\n"))
+	assert.NotNil(t, err)
+	_, err = NewBamReader(strings.NewReader("BAM\x01\x10\x00"))
+	assert.NotNil(t, err)
+}
+
+// TestBamReaderHeaderLines tests that @SQ lines are derived from the reference
+// dictionary when the BAM has no header text
+func TestBamReaderHeaderLines(t *testing.T) {
+	bamReader := new(BamReader)
+	bamReader.references = []BamReference{{"chr1", 100}, {"chr2", 200}}
+	assert.Equal(t, []string{"@SQ\tSN:chr1\tLN:100", "@SQ\tSN:chr2\tLN:200"}, bamReader.HeaderLines())
+}
+
+// TestBamReaderNext tests that each decoded BAM record matches the
+// corresponding line of the equivalent SAM
+func TestBamReaderNext(t *testing.T) {
+	samFile, _ := os.Open("../data/test/input/modify-sam.sam")
+	expected := []string{}
+	scanner := bufio.NewScanner(samFile)
+	for scanner.Scan() {
+		if !strings.HasPrefix(scanner.Text(), "@") {
+			expected = append(expected, scanner.Text())
+		}
+	}
+
+	bamFile, _ := os.Open("../data/test/input/modify-sam.bam")
+	bamReader, _ := NewBamReader(NewBgzfReader(bamFile))
+	samRecordEmitter, _ := NewSamRecordEmitter("", "", "")
+	actual := []string{}
+	for {
+		samRecord, err := bamReader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		actual = append(actual, samRecordEmitter.CustomEmit(samRecord))
+	}
+	assert.Equal(t, expected, actual)
+}
+
+// TestDecodeBamTags tests decodeBamTags function
+func TestDecodeBamTags(t *testing.T) {
+	for _, tc := range decodeBamTagsTC {
+		actual, err := decodeBamTags(tc.data)
+		if tc.expError {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, tc.exp, actual)
+		}
+	}
+}
+
+// TestDecodeBamCigar tests decodeBamCigar function
+func TestDecodeBamCigar(t *testing.T) {
+	for _, tc := range decodeBamCigarTC {
+		assert.Equal(t, tc.exp, decodeBamCigar(tc.data))
+	}
+}
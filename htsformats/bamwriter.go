@@ -0,0 +1,424 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bamwriter encodes SamRecords into the binary BAM format, written as
+// BGZF-compressed blocks
+package htsformats
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// bamMaxCigarOps maximum number of CIGAR operations that fit in the binary
+// CIGAR field. Longer CIGARs are stored in the CG tag
+const bamMaxCigarOps = 65535
+
+// BamWriter writes a BAM header and alignments to a BgzfWriter, or to any
+// writer as uncompressed BAM
+type BamWriter struct {
+	writer     io.Writer
+	references map[string]int
+	buf        []byte
+}
+
+// NewBamWriter constructs a BamWriter over a BgzfWriter, or over any writer to
+// write uncompressed BAM
+func NewBamWriter(writer io.Writer) *BamWriter {
+	bamWriter := new(BamWriter)
+	bamWriter.writer = writer
+	bamWriter.references = make(map[string]int)
+	return bamWriter
+}
+
+// WriteHeader writes the magic bytes, header text and reference dictionary.
+// The reference dictionary is derived from the SN and LN values of @SQ lines
+func (bamWriter *BamWriter) WriteHeader(headerLines []string) error {
+	references, err := bamWriter.setReferences(headerLines)
+	if err != nil {
+		return err
+	}
+
+	text := ""
+	if len(headerLines) > 0 {
+		text = strings.Join(headerLines, "\n") + "\n"
+	}
+	buf := append([]byte{}, bamMagic...)
+	buf = appendInt32(buf, int32(len(text)))
+	buf = append(buf, text...)
+	buf = appendInt32(buf, int32(len(references)))
+	for _, reference := range references {
+		buf = appendInt32(buf, int32(len(reference.Name)+1))
+		buf = append(buf, reference.Name...)
+		buf = append(buf, 0)
+		buf = appendInt32(buf, int32(reference.Length))
+	}
+	_, err = bamWriter.writer.Write(buf)
+	return err
+}
+
+// SetReferences registers the reference dictionary from the @SQ lines of a
+// header without writing the header, so that alignments may be written as a
+// body-only block to be concatenated after a separately written header
+func (bamWriter *BamWriter) SetReferences(headerLines []string) error {
+	_, err := bamWriter.setReferences(headerLines)
+	return err
+}
+
+// setReferences derives the reference dictionary from the SN and LN values of
+// @SQ lines, registering each reference name's id
+func (bamWriter *BamWriter) setReferences(headerLines []string) ([]BamReference, error) {
+	samHeader, err := NewSamHeader(headerLines)
+	if err != nil {
+		return nil, err
+	}
+	references, err := samHeader.References()
+	if err != nil {
+		return nil, err
+	}
+	bamWriter.references = make(map[string]int)
+	for i, reference := range references {
+		bamWriter.references[reference.Name] = i
+	}
+	return references, nil
+}
+
+// Write encodes and writes a single alignment
+func (bamWriter *BamWriter) Write(samRecord *SamRecord) error {
+	data, err := bamWriter.encodeRecord(samRecord)
+	if err != nil {
+		return err
+	}
+	_, err = bamWriter.writer.Write(data)
+	return err
+}
+
+// Close flushes all buffered alignments and writes the BGZF EOF marker. Other
+// writers are closed if they implement io.Closer
+func (bamWriter *BamWriter) Close() error {
+	if closer, ok := bamWriter.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// referenceID gets the reference id for a reference name, -1 if unset
+func (bamWriter *BamWriter) referenceID(name string) (int32, error) {
+	if name == "*" {
+		return -1, nil
+	}
+	if id, ok := bamWriter.references[name]; ok {
+		return int32(id), nil
+	}
+	return 0, errors.New("Reference '" + name + "' not found in header")
+}
+
+// encodeRecord converts a SamRecord into its binary representation, including
+// the block_size prefix. If SEQ is unavailable, QUAL cannot be represented and
+// is omitted
+func (bamWriter *BamWriter) encodeRecord(samRecord *SamRecord) ([]byte, error) {
+	fields := samRecord.emitFields()
+	intFields := make(map[int]int)
+	for _, col := range []int{1, 3, 4, 7, 8} {
+		value, err := strconv.Atoi(fields[col])
+		if err != nil {
+			return nil, errors.New("Invalid integer field: '" + fields[col] + "'")
+		}
+		intFields[col] = value
+	}
+
+	if intFields[1] < 0 || intFields[1] > math.MaxUint16 {
+		return nil, errors.New("FLAG out of range for record " + fields[0] + ": " + fields[1])
+	}
+	if intFields[4] < 0 || intFields[4] > math.MaxUint8 {
+		return nil, errors.New("MAPQ out of range for record " + fields[0] + ": " + fields[4])
+	}
+	if intFields[3] < 0 || intFields[3] > math.MaxInt32 {
+		return nil, errors.New("POS out of range for record " + fields[0] + ": " + fields[3])
+	}
+	if intFields[7] < 0 || intFields[7] > math.MaxInt32 {
+		return nil, errors.New("PNEXT out of range for record " + fields[0] + ": " + fields[7])
+	}
+	if intFields[8] < math.MinInt32 || intFields[8] > math.MaxInt32 {
+		return nil, errors.New("TLEN out of range for record " + fields[0] + ": " + fields[8])
+	}
+
+	refID, err := bamWriter.referenceID(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	nextRefID := refID
+	if fields[6] != "=" {
+		if nextRefID, err = bamWriter.referenceID(fields[6]); err != nil {
+			return nil, err
+		}
+	}
+
+	cigarOps, err := ParseCigar(fields[5])
+	if err != nil {
+		return nil, err
+	}
+	pos := intFields[3] - 1
+	end := pos + cigarReferenceLength(cigarOps)
+	if end <= pos {
+		end = pos + 1
+	}
+
+	seq := fields[9]
+	if seq == "*" {
+		seq = ""
+	}
+	for i := 0; i < len(seq); i++ {
+		if _, ok := encodeBamBase(seq[i]); !ok {
+			return nil, errors.New("Invalid SEQ character '" + seq[i:i+1] + "' for record " + fields[0])
+		}
+	}
+	qual := fields[10]
+	if len(qual) != len(seq) && qual != "*" && seq != "" {
+		return nil, errors.New("SEQ and QUAL lengths differ for record " + fields[0])
+	}
+	if qual != "*" {
+		for i := 0; i < len(qual); i++ {
+			if qual[i] < '!' || qual[i] > '~' {
+				return nil, errors.New("Invalid QUAL character '" + qual[i:i+1] + "' for record " + fields[0])
+			}
+		}
+	}
+
+	tags := samRecord.emitTags()
+
+	// CIGARs with too many operations are moved to the CG tag, leaving a
+	// placeholder of <lSeq>S<refLen>N
+	if len(cigarOps) > bamMaxCigarOps {
+		values := make([]string, len(cigarOps)+1)
+		values[0] = "I"
+		for i, cigarOp := range cigarOps {
+			values[i+1] = strconv.Itoa(cigarOp.Length<<4 | strings.IndexByte(bamCigarOps, cigarOp.Op))
+		}
+		tags = append(tags, "CG:B:"+strings.Join(values, ","))
+		cigarOps = []CigarOp{{len(seq), 'S'}, {cigarReferenceLength(cigarOps), 'N'}}
+	}
+
+	if len(fields[0]) > 254 {
+		return nil, errors.New("QNAME too long for record " + fields[0])
+	}
+
+	buf := bamWriter.buf[:0]
+	buf = appendInt32(buf, 0) // block_size, filled in below
+	buf = appendInt32(buf, refID)
+	buf = appendInt32(buf, int32(pos))
+	buf = append(buf, byte(len(fields[0])+1), byte(intFields[4]))
+	buf = appendUint16(buf, uint16(reg2bin(pos, end)))
+	buf = appendUint16(buf, uint16(len(cigarOps)))
+	buf = appendUint16(buf, uint16(intFields[1]))
+	buf = appendInt32(buf, int32(len(seq)))
+	buf = appendInt32(buf, nextRefID)
+	buf = appendInt32(buf, int32(intFields[7]-1))
+	buf = appendInt32(buf, int32(intFields[8]))
+	buf = append(buf, fields[0]...)
+	buf = append(buf, 0)
+	for _, cigarOp := range cigarOps {
+		buf = appendUint32(buf, uint32(cigarOp.Length)<<4|uint32(strings.IndexByte(bamCigarOps, cigarOp.Op)))
+	}
+	for i := 0; i < len(seq); i += 2 {
+		high, _ := encodeBamBase(seq[i])
+		low := byte(0)
+		if i+1 < len(seq) {
+			low, _ = encodeBamBase(seq[i+1])
+		}
+		buf = append(buf, high<<4|low)
+	}
+	for i := 0; i < len(seq); i++ {
+		if qual == "*" {
+			buf = append(buf, 0xff)
+		} else {
+			buf = append(buf, qual[i]-33)
+		}
+	}
+	for _, tag := range tags {
+		if buf, err = appendBamTag(buf, tag); err != nil {
+			return nil, err
+		}
+	}
+
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)-4))
+	bamWriter.buf = buf
+	return buf, nil
+}
+
+// encodeBamBase converts a sequence character to its 4-bit BAM encoding,
+// case-insensitively. Returns false if the character cannot be encoded
+func encodeBamBase(base byte) (byte, bool) {
+	if base >= 'a' && base <= 'z' {
+		base -= 'a' - 'A'
+	}
+	index := strings.IndexByte(bamSeqBases, base)
+	if index < 0 {
+		return 0, false
+	}
+	return byte(index), true
+}
+
+// bamIntegerType selects the smallest BAM integer type able to hold a value
+func bamIntegerType(value int64) (byte, error) {
+	switch {
+	case value >= 0 && value <= math.MaxUint8:
+		return 'C', nil
+	case value >= math.MinInt8 && value < 0:
+		return 'c', nil
+	case value >= 0 && value <= math.MaxUint16:
+		return 'S', nil
+	case value >= math.MinInt16 && value < 0:
+		return 's', nil
+	case value >= 0 && value <= math.MaxUint32:
+		return 'I', nil
+	case value >= math.MinInt32 && value < 0:
+		return 'i', nil
+	}
+	return 0, errors.New("Integer tag value out of range: " + strconv.FormatInt(value, 10))
+}
+
+// bamIntegerRange gets the smallest and largest values of a BAM integer type
+func bamIntegerRange(valueType byte) (int64, int64) {
+	switch valueType {
+	case 'c':
+		return math.MinInt8, math.MaxInt8
+	case 'C':
+		return 0, math.MaxUint8
+	case 's':
+		return math.MinInt16, math.MaxInt16
+	case 'S':
+		return 0, math.MaxUint16
+	case 'i':
+		return math.MinInt32, math.MaxInt32
+	}
+	return 0, math.MaxUint32
+}
+
+// appendBamTagValue appends a single fixed-width tag value of the given type
+func appendBamTagValue(buf []byte, valueType byte, value string) ([]byte, error) {
+	if valueType == 'f' {
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, errors.New("Invalid float tag value: '" + value + "'")
+		}
+		return appendUint32(buf, math.Float32bits(float32(f))), nil
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid integer tag value: '" + value + "'")
+	}
+	if min, max := bamIntegerRange(valueType); i < min || i > max {
+		return nil, errors.New("Tag value out of range for type '" + string(valueType) + "': " + value)
+	}
+	switch valueType {
+	case 'c', 'C':
+		return append(buf, byte(i)), nil
+	case 's', 'S':
+		return appendUint16(buf, uint16(i)), nil
+	default:
+		return appendUint32(buf, uint32(i)), nil
+	}
+}
+
+// appendBamTag encodes a TAG:TYPE:VALUE string into binary auxiliary data.
+// Integer values are stored using the smallest type able to hold them
+func appendBamTag(buf []byte, tag string) ([]byte, error) {
+	split := strings.SplitN(tag, ":", 3)
+	if len(split) != 3 || len(split[0]) != 2 || len(split[1]) != 1 {
+		return nil, errors.New("Invalid tag: '" + tag + "'")
+	}
+	key, valueType, value := split[0], split[1][0], split[2]
+	buf = append(buf, key...)
+
+	switch valueType {
+	case 'A':
+		if len(value) != 1 {
+			return nil, errors.New("Invalid character tag value: '" + tag + "'")
+		}
+		return append(buf, 'A', value[0]), nil
+	case 'i':
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("Invalid integer tag value: '" + tag + "'")
+		}
+		intType, err := bamIntegerType(i)
+		if err != nil {
+			return nil, err
+		}
+		return appendBamTagValue(append(buf, intType), intType, value)
+	case 'f':
+		return appendBamTagValue(append(buf, 'f'), 'f', value)
+	case 'H':
+		if _, err := hex.DecodeString(value); err != nil {
+			return nil, errors.New("Invalid hex tag value: '" + tag + "'")
+		}
+		buf = append(buf, valueType)
+		buf = append(buf, value...)
+		return append(buf, 0), nil
+	case 'Z':
+		buf = append(buf, valueType)
+		buf = append(buf, value...)
+		return append(buf, 0), nil
+	case 'B':
+		values := strings.Split(value, ",")
+		subtype := values[0]
+		if len(subtype) != 1 || strings.IndexByte("cCsSiIf", subtype[0]) < 0 {
+			return nil, errors.New("Invalid array tag type: '" + tag + "'")
+		}
+		buf = append(buf, 'B', subtype[0])
+		buf = appendUint32(buf, uint32(len(values)-1))
+		var err error
+		for _, v := range values[1:] {
+			if buf, err = appendBamTagValue(buf, subtype[0], v); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, errors.New("Invalid tag type: '" + tag + "'")
+}
+
+// reg2bin computes the BAI bin for a 0-based, half-open alignment span, as
+// described in the SAM specification
+func reg2bin(beg int, end int) int {
+	end--
+	switch {
+	case beg>>14 == end>>14:
+		return ((1<<15)-1)/7 + (beg >> 14)
+	case beg>>17 == end>>17:
+		return ((1<<12)-1)/7 + (beg >> 17)
+	case beg>>20 == end>>20:
+		return ((1<<9)-1)/7 + (beg >> 20)
+	case beg>>23 == end>>23:
+		return ((1<<6)-1)/7 + (beg >> 23)
+	case beg>>26 == end>>26:
+		return ((1<<3)-1)/7 + (beg >> 26)
+	}
+	return 0
+}
+
+// appendInt32 appends a little-endian int32
+func appendInt32(buf []byte, value int32) []byte {
+	return appendUint32(buf, uint32(value))
+}
+
+// appendUint64 appends a little-endian uint64
+func appendUint64(buf []byte, value uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(value)), uint32(value>>32))
+}
+
+// appendUint32 appends a little-endian uint32
+func appendUint32(buf []byte, value uint32) []byte {
+	return append(buf, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+// appendUint16 appends a little-endian uint16
+func appendUint16(buf []byte, value uint16) []byte {
+	return append(buf, byte(value), byte(value>>8))
+}
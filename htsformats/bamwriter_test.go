@@ -0,0 +1,148 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bamwriter_test tests bamwriter
+package htsformats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bamWriterHeader header lines used by BamWriter test cases
+var bamWriterHeader = []string{
+	"@HD\tVN:1.6\tSO:unsorted",
+	"@SQ\tSN:chr1\tLN:195471971",
+	"@SQ\tSN:chr2\tLN:182113224",
+}
+
+// bamWriterWriteTC test cases for BamWriter Write, where each record is
+// expected to round trip through BamReader unchanged
+var bamWriterWriteTC = []struct {
+	raw string
+}{
+	{"A00111:67:H3M5YDMXX:2:1182:16125:23813\t147\tchr1\t280\t255\t100M\t=\t1\t-379\tAACCAAACATCCGTGCGATTCGTGCCACTCGTAGACGGCATCTCACAGTCACTGAAGGCTATTAAAGAGTTAGCACCCACCATTGGATGAAGCCCAGGAT\tFFFFFFFFFF-FFFFFFFF-FFFF-F-F-FFFFFFFFFFFFFFFFFFFFFFFFFFFFFF8FFFFFFFFFFFFFF8FFFFFFFFFFFFFFFFFFFFFFFFF\tNH:i:1\tHI:i:1\tNM:i:0\tMD:Z:100"},
+	{"r001\t99\tchr1\t7\t30\t8M2I4M1D3M\tchr2\t37\t39\tTTAGATAAAGGATACTG\t*\tXA:A:x\tXN:i:-3\tXL:i:70000\tXM:i:-70000\tXF:f:3.25\tXH:H:1AE3\tXB:B:S,1,65535\tXC:B:f,1.5,-2"},
+	{"r002\t4\t*\t0\t0\t*\t*\t0\t0\tACGTN\t#####"},
+	{"r003\t0\tchr2\t1\t60\t5M\t*\t0\t0\t*\t*"},
+}
+
+// TestBamWriterWrite tests that records written by BamWriter are decoded by
+// BamReader into the same SAM text
+func TestBamWriterWrite(t *testing.T) {
+	var compressed bytes.Buffer
+	bamWriter := NewBamWriter(NewBgzfWriter(&compressed))
+	assert.Nil(t, bamWriter.WriteHeader(bamWriterHeader))
+	for _, tc := range bamWriterWriteTC {
+		assert.Nil(t, bamWriter.Write(newTestSamRecord(tc.raw)))
+	}
+	assert.Nil(t, bamWriter.Close())
+
+	bamReader, err := NewBamReader(NewBgzfReader(&compressed))
+	assert.Nil(t, err)
+	assert.Equal(t, bamWriterHeader, bamReader.HeaderLines())
+	assert.Equal(t, []BamReference{{"chr1", 195471971}, {"chr2", 182113224}}, bamReader.References())
+
+	samRecordEmitter, _ := NewSamRecordEmitter("", "", "")
+	for _, tc := range bamWriterWriteTC {
+		samRecord, err := bamReader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, tc.raw, samRecordEmitter.CustomEmit(samRecord))
+	}
+}
+
+// TestBamWriterWriteLongCigar tests that CIGARs with more operations than fit
+// in the binary field round trip via the CG tag
+func TestBamWriterWriteLongCigar(t *testing.T) {
+	cigar := strings.Repeat("1M1I", 40000)
+	seq := strings.Repeat("AC", 40000)
+	raw := "r004\t0\tchr1\t1\t60\t" + cigar + "\t*\t0\t0\t" + seq + "\t*\tNM:i:40000"
+
+	var compressed bytes.Buffer
+	bamWriter := NewBamWriter(NewBgzfWriter(&compressed))
+	bamWriter.WriteHeader(bamWriterHeader)
+	assert.Nil(t, bamWriter.Write(newTestSamRecord(raw)))
+	bamWriter.Close()
+
+	bamReader, _ := NewBamReader(NewBgzfReader(&compressed))
+	samRecord, err := bamReader.Next()
+	assert.Nil(t, err)
+	samRecordEmitter, _ := NewSamRecordEmitter("", "", "")
+	assert.Equal(t, raw, samRecordEmitter.CustomEmit(samRecord))
+}
+
+// bamWriterRangeErrorTC test cases for BamWriter Write given values that do
+// not fit their binary representation
+var bamWriterRangeErrorTC = []struct {
+	raw      string
+	expError string
+}{
+	{"r\t70000\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF", "FLAG out of range for record r: 70000"},
+	{"r\t-1\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF", "FLAG out of range for record r: -1"},
+	{"r\t0\tchr1\t1\t256\t1M\t*\t0\t0\tA\tF", "MAPQ out of range for record r: 256"},
+	{"r\t0\tchr1\t1\t60\t2M\t*\t0\t0\tAC\tF ", "Invalid QUAL character ' ' for record r"},
+	{"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXB:B:c,1,200", "Tag value out of range for type 'c': 200"},
+	{"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXB:B:C,-1", "Tag value out of range for type 'C': -1"},
+	{"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXB:B:S,70000", "Tag value out of range for type 'S': 70000"},
+	{"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXB:B:i,3000000000", "Tag value out of range for type 'i': 3000000000"},
+	{"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXB:B:I,-5", "Tag value out of range for type 'I': -5"},
+	{"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tNM:i:-3000000000", "Integer tag value out of range: -3000000000"},
+	{"r\t0\tchr1\t2147483648\t60\t1M\t*\t0\t0\tA\tF", "POS out of range for record r: 2147483648"},
+	{"r\t0\tchr1\t-1\t60\t1M\t*\t0\t0\tA\tF", "POS out of range for record r: -1"},
+	{"r\t0\tchr1\t1\t60\t1M\t=\t4294967297\t0\tA\tF", "PNEXT out of range for record r: 4294967297"},
+	{"r\t0\tchr1\t1\t60\t1M\t=\t1\t-2147483649\tA\tF", "TLEN out of range for record r: -2147483649"},
+	{"r\t0\tchr1\t1\t60\t2M\t*\t0\t0\tA.\tFF", "Invalid SEQ character '.' for record r"},
+	{"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXH:H:1G", "Invalid hex tag value: 'XH:H:1G'"},
+	{"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXH:H:1A2", "Invalid hex tag value: 'XH:H:1A2'"},
+}
+
+// TestBamWriterRangeError tests that BamWriter reports values that would be
+// truncated or wrapped in their binary representation
+func TestBamWriterRangeError(t *testing.T) {
+	bamWriter := NewBamWriter(NewBgzfWriter(new(bytes.Buffer)))
+	bamWriter.WriteHeader(bamWriterHeader)
+	for _, tc := range bamWriterRangeErrorTC {
+		err := bamWriter.Write(newTestSamRecord(tc.raw))
+		if assert.NotNil(t, err, tc.raw) {
+			assert.Equal(t, tc.expError, err.Error())
+		}
+	}
+
+	// boundary values are encoded
+	assert.Nil(t, bamWriter.Write(newTestSamRecord("r\t65535\tchr1\t2147483647\t255\t2M\t=\t2147483647\t-2147483648\tac\t!~\tXB:B:c,-128,127\tXC:B:S,65535\tXH:H:1a2B")))
+}
+
+// TestBamWriterError tests BamWriter on invalid headers and records
+func TestBamWriterError(t *testing.T) {
+	bamWriter := NewBamWriter(NewBgzfWriter(new(bytes.Buffer)))
+	assert.NotNil(t, bamWriter.WriteHeader([]string{"@SQ\tSN:chr1"}))
+	assert.NotNil(t, bamWriter.WriteHeader([]string{"@SQ\tSN:chr1\tLN:abc"}))
+
+	bamWriter = NewBamWriter(NewBgzfWriter(new(bytes.Buffer)))
+	bamWriter.WriteHeader(bamWriterHeader)
+	invalid := []string{
+		"r\t0\tchr3\t1\t60\t1M\t*\t0\t0\tA\tF",
+		"r\tX\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF",
+		"r\t0\tchr1\t1\t60\t1Q\t*\t0\t0\tA\tF",
+		"r\t0\tchr1\t1\t60\t2M\t*\t0\t0\tAC\tF",
+		"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tNM:i:x",
+		"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tNM:i:5000000000",
+		"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXB:B:Z,1",
+		"r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tXQ:Q:1",
+	}
+	for _, raw := range invalid {
+		assert.NotNil(t, bamWriter.Write(newTestSamRecord(raw)))
+	}
+}
+
+// TestReg2bin tests reg2bin function
+func TestReg2bin(t *testing.T) {
+	assert.Equal(t, 4680, reg2bin(-1, 0))
+	assert.Equal(t, 4681, reg2bin(0, 1))
+	assert.Equal(t, 4681+1, reg2bin(1<<14, 1<<14+100))
+	assert.Equal(t, 585, reg2bin(0, 1<<14+1))
+	assert.Equal(t, 0, reg2bin(0, 1<<29))
+}
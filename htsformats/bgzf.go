@@ -0,0 +1,254 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bgzf decompresses BGZF (blocked gzip) streams, the container format
+// used by BAM files
+package htsformats
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// bgzfHeaderLength length of the fixed gzip header preceding the extra
+// subfields of a BGZF block
+const bgzfHeaderLength = 12
+
+// bgzfFooterLength length of the CRC32 and ISIZE trailer of a BGZF block
+const bgzfFooterLength = 8
+
+// bgzfMaxBlockSize maximum size of a BGZF block, compressed or uncompressed
+const bgzfMaxBlockSize = 65536
+
+// bgzfBlock a single BGZF block, holding its compressed payload along with the
+// expected checksum and uncompressed size from the block trailer
+type bgzfBlock struct {
+	cdata   []byte
+	crc32   uint32
+	isize   uint32
+	address int64
+	size    int
+}
+
+// BgzfReader reads a BGZF stream, decompressing one block at a time. Blocks
+// may be read ahead and decompressed concurrently on multiple goroutines.
+// Implements io.Reader over the uncompressed data
+type BgzfReader struct {
+	reader   io.Reader
+	data     []byte
+	offset   int
+	address  int64
+	next     int64
+	parallel *bgzfParallelReader
+}
+
+// bgzfParallelReader reads blocks ahead on a goroutine, decompressing them on
+// worker goroutines. Blocks are queued in order as pending, the last holding
+// the error that ended reading (io.EOF at the end of the stream)
+type bgzfParallelReader struct {
+	pending chan *bgzfJob
+	stop    chan struct{}
+	err     error
+}
+
+// NewBgzfReader constructs a BgzfReader over a compressed stream
+func NewBgzfReader(reader io.Reader) *BgzfReader {
+	bgzfReader := new(BgzfReader)
+	bgzfReader.reader = reader
+	return bgzfReader
+}
+
+// NewBgzfReaderThreads constructs a BgzfReader over a compressed stream, which
+// decompresses blocks on the given number of goroutines. Up to twice that many
+// blocks are read ahead of the uncompressed data consumed. Close stops reading
+// ahead. With fewer than 2 threads, blocks are read and decompressed as
+// needed, as by NewBgzfReader
+func NewBgzfReaderThreads(reader io.Reader, threads int) *BgzfReader {
+	bgzfReader := NewBgzfReader(reader)
+	if threads < 2 {
+		return bgzfReader
+	}
+
+	parallel := new(bgzfParallelReader)
+	parallel.pending = make(chan *bgzfJob, 2*threads)
+	parallel.stop = make(chan struct{})
+	jobs := make(chan *bgzfJob, threads)
+	for i := 0; i < threads; i++ {
+		go func() {
+			for job := range jobs {
+				job.data, job.err = job.block.inflate()
+				close(job.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		defer close(parallel.pending)
+		address := int64(0)
+		for {
+			job := &bgzfJob{done: make(chan struct{})}
+			job.block, job.err = readBgzfBlock(reader)
+			if job.err != nil {
+				close(job.done)
+			} else {
+				job.block.address = address
+				address += int64(job.block.size)
+			}
+			select {
+			case parallel.pending <- job:
+			case <-parallel.stop:
+				return
+			}
+			if job.err != nil {
+				return
+			}
+			jobs <- job
+		}
+	}()
+	bgzfReader.parallel = parallel
+	return bgzfReader
+}
+
+// Read reads uncompressed data into p, decompressing subsequent blocks as
+// needed. Empty blocks (including the EOF marker block) are skipped
+func (bgzfReader *BgzfReader) Read(p []byte) (int, error) {
+	for bgzfReader.offset >= len(bgzfReader.data) {
+		block, data, err := bgzfReader.nextBlock()
+		if err != nil {
+			return 0, err
+		}
+		bgzfReader.data = data
+		bgzfReader.offset = 0
+		bgzfReader.address = block.address
+		bgzfReader.next = block.address + int64(block.size)
+	}
+	n := copy(p, bgzfReader.data[bgzfReader.offset:])
+	bgzfReader.offset += n
+	return n, nil
+}
+
+// VirtualOffset gets the virtual offset of the next uncompressed byte to be
+// read. Once a block has been read entirely, this is the start of the next
+// block
+func (bgzfReader *BgzfReader) VirtualOffset() VirtualOffset {
+	if bgzfReader.offset < len(bgzfReader.data) {
+		return NewVirtualOffset(bgzfReader.address, bgzfReader.offset)
+	}
+	return NewVirtualOffset(bgzfReader.next, 0)
+}
+
+// Close stops reading ahead, if blocks are decompressed concurrently. Does not
+// close the underlying stream
+func (bgzfReader *BgzfReader) Close() error {
+	if parallel := bgzfReader.parallel; parallel != nil && parallel.err == nil {
+		parallel.err = errors.New("BGZF reader is closed")
+		close(parallel.stop)
+	}
+	return nil
+}
+
+// nextBlock gets the next block and its uncompressed data, either reading and
+// decompressing it, or from the blocks decompressed concurrently
+func (bgzfReader *BgzfReader) nextBlock() (*bgzfBlock, []byte, error) {
+	parallel := bgzfReader.parallel
+	if parallel == nil {
+		block, err := readBgzfBlock(bgzfReader.reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		block.address = bgzfReader.next
+		data, err := block.inflate()
+		return block, data, err
+	}
+
+	if parallel.err != nil {
+		return nil, nil, parallel.err
+	}
+	job := <-parallel.pending
+	<-job.done
+	if job.err != nil {
+		parallel.err = job.err
+		if job.err != io.EOF {
+			close(parallel.stop)
+		}
+		return nil, nil, job.err
+	}
+	return job.block, job.data, nil
+}
+
+// readBgzfBlock reads the next compressed block from the stream, validating the
+// gzip header and BC extra subfield. Returns io.EOF if the stream ended cleanly
+// on a block boundary
+func readBgzfBlock(reader io.Reader) (*bgzfBlock, error) {
+	header := make([]byte, bgzfHeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, errors.New("Truncated BGZF block header")
+	}
+	if header[0] != 31 || header[1] != 139 || header[2] != 8 || header[3]&4 == 0 {
+		return nil, errors.New("Invalid BGZF block header")
+	}
+
+	// locate the BSIZE value within the extra subfields
+	xlen := int(binary.LittleEndian.Uint16(header[10:12]))
+	extra := make([]byte, xlen)
+	if _, err := io.ReadFull(reader, extra); err != nil {
+		return nil, errors.New("Truncated BGZF block header")
+	}
+	bsize := -1
+	for i := 0; i+4 <= len(extra); {
+		slen := int(binary.LittleEndian.Uint16(extra[i+2 : i+4]))
+		if extra[i] == 66 && extra[i+1] == 67 && slen == 2 && i+6 <= len(extra) {
+			bsize = int(binary.LittleEndian.Uint16(extra[i+4 : i+6]))
+		}
+		i += 4 + slen
+	}
+	if bsize < 0 {
+		return nil, errors.New("BGZF block is missing BSIZE subfield")
+	}
+
+	// the remainder of the block is the deflated payload and the trailer
+	remaining := bsize + 1 - bgzfHeaderLength - xlen
+	if remaining < bgzfFooterLength {
+		return nil, errors.New("Invalid BGZF block size")
+	}
+	rest := make([]byte, remaining)
+	if _, err := io.ReadFull(reader, rest); err != nil {
+		return nil, errors.New("Truncated BGZF block")
+	}
+	footer := rest[remaining-bgzfFooterLength:]
+	block := new(bgzfBlock)
+	block.size = bsize + 1
+	block.cdata = rest[:remaining-bgzfFooterLength]
+	block.crc32 = binary.LittleEndian.Uint32(footer[0:4])
+	block.isize = binary.LittleEndian.Uint32(footer[4:8])
+	return block, nil
+}
+
+// inflate decompresses the block payload, checking the result against the
+// uncompressed size and CRC32 recorded in the block trailer
+func (block *bgzfBlock) inflate() ([]byte, error) {
+	if block.isize > bgzfMaxBlockSize {
+		return nil, errors.New("Invalid BGZF uncompressed block size")
+	}
+	inflater := flate.NewReader(bytes.NewReader(block.cdata))
+	defer inflater.Close()
+	data, err := ioutil.ReadAll(inflater)
+	if err != nil {
+		return nil, errors.New("Could not inflate BGZF block: " + err.Error())
+	}
+	if uint32(len(data)) != block.isize {
+		return nil, errors.New("BGZF block size does not match ISIZE")
+	}
+	if crc32.ChecksumIEEE(data) != block.crc32 {
+		return nil, errors.New("BGZF block failed CRC32 check")
+	}
+	return data, nil
+}
@@ -0,0 +1,133 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bgzf_test tests bgzf
+package htsformats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bgzfReaderReadErrorTC test cases for BgzfReader Read on invalid input
+var bgzfReaderReadErrorTC = []struct {
+	input []byte
+}{
+	// not gzip
+	{[]byte("@HD\tVN:1.4\n")},
+	// truncated header
+	{bgzfEOF[:10]},
+	// truncated block
+	{bgzfEOF[:24]},
+	// gzip member without BC subfield
+	{[]byte{0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x04, 0x00, 0x41, 0x41, 0x00, 0x00}},
+}
+
+// TestBgzfReaderRead tests that BgzfReader decompresses a multi-block BGZF
+// file to the same data as a standard gzip reader
+func TestBgzfReaderRead(t *testing.T) {
+	compressed, _ := ioutil.ReadFile("../data/test/input/modify-sam.bam")
+
+	gzipReader, _ := gzip.NewReader(bytes.NewReader(compressed))
+	expected, _ := ioutil.ReadAll(gzipReader)
+
+	actual, err := ioutil.ReadAll(NewBgzfReader(bytes.NewReader(compressed)))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+// TestBgzfReaderReadEOFMarker tests that the empty EOF block yields no data
+func TestBgzfReaderReadEOFMarker(t *testing.T) {
+	actual, err := ioutil.ReadAll(NewBgzfReader(bytes.NewReader(bgzfEOF)))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(actual))
+}
+
+// TestBgzfReaderReadError tests BgzfReader Read on invalid input
+func TestBgzfReaderReadError(t *testing.T) {
+	for _, tc := range bgzfReaderReadErrorTC {
+		_, err := ioutil.ReadAll(NewBgzfReader(bytes.NewReader(tc.input)))
+		assert.NotNil(t, err)
+	}
+}
+
+// TestBgzfReaderReadCorrupt tests that a corrupted checksum is detected
+func TestBgzfReaderReadCorrupt(t *testing.T) {
+	file, _ := os.Open("../data/test/input/modify-sam.bam")
+	compressed, _ := ioutil.ReadAll(file)
+
+	// the first block's CRC32 immediately precedes its ISIZE
+	bsize := int(compressed[16]) | int(compressed[17])<<8
+	compressed[bsize+1-8] ^= 0xff
+
+	_, err := ioutil.ReadAll(NewBgzfReader(bytes.NewReader(compressed)))
+	assert.NotNil(t, err)
+}
+
+// TestBgzfReaderThreads tests that BgzfReader decompressing blocks on multiple
+// goroutines reads the same data as sequential decompression, and surfaces
+// invalid input
+func TestBgzfReaderThreads(t *testing.T) {
+	data := make([]byte, 10*bgzfBlockDataSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	var compressed bytes.Buffer
+	bgzfWriter := NewBgzfWriter(&compressed)
+	bgzfWriter.Write(data)
+	bgzfWriter.Close()
+
+	actual, err := ioutil.ReadAll(NewBgzfReaderThreads(bytes.NewReader(compressed.Bytes()), 4))
+	assert.Nil(t, err)
+	assert.Equal(t, data, actual)
+
+	for _, tc := range bgzfReaderReadErrorTC {
+		_, err := ioutil.ReadAll(NewBgzfReaderThreads(bytes.NewReader(tc.input), 4))
+		assert.NotNil(t, err)
+	}
+}
+
+// TestBgzfReaderThreadsClose tests that closing a BgzfReader part way through
+// the stream stops further reads
+func TestBgzfReaderThreadsClose(t *testing.T) {
+	data := make([]byte, 10*bgzfBlockDataSize)
+	var compressed bytes.Buffer
+	bgzfWriter := NewBgzfWriter(&compressed)
+	bgzfWriter.Write(data)
+	bgzfWriter.Close()
+
+	bgzfReader := NewBgzfReaderThreads(bytes.NewReader(compressed.Bytes()), 2)
+	_, err := io.ReadFull(bgzfReader, make([]byte, 100))
+	assert.Nil(t, err)
+	assert.Nil(t, bgzfReader.Close())
+	_, err = ioutil.ReadAll(bgzfReader)
+	assert.NotNil(t, err)
+}
+
+// TestBgzfReaderVirtualOffset tests that BgzfReader VirtualOffset locates the
+// next uncompressed byte, sequentially and decompressing concurrently
+func TestBgzfReaderVirtualOffset(t *testing.T) {
+	data := make([]byte, 2*bgzfBlockDataSize)
+	var compressed bytes.Buffer
+	bgzfWriter := NewBgzfWriter(&compressed)
+	bgzfWriter.Write(data)
+	bgzfWriter.Close()
+	blockSize := int64(compressed.Bytes()[16]) | int64(compressed.Bytes()[17])<<8 + 1
+
+	for _, threads := range []int{1, 2} {
+		bgzfReader := NewBgzfReaderThreads(bytes.NewReader(compressed.Bytes()), threads)
+		assert.Equal(t, NewVirtualOffset(0, 0), bgzfReader.VirtualOffset())
+		io.ReadFull(bgzfReader, make([]byte, 100))
+		assert.Equal(t, NewVirtualOffset(0, 100), bgzfReader.VirtualOffset())
+		io.ReadFull(bgzfReader, make([]byte, bgzfBlockDataSize-100))
+		assert.Equal(t, NewVirtualOffset(blockSize, 0), bgzfReader.VirtualOffset())
+		io.ReadFull(bgzfReader, make([]byte, 5))
+		assert.Equal(t, NewVirtualOffset(blockSize, 5), bgzfReader.VirtualOffset())
+		bgzfReader.Close()
+	}
+}
@@ -0,0 +1,222 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bgzfwriter compresses data into BGZF (blocked gzip) blocks, the
+// container format used by BAM files
+package htsformats
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// bgzfBlockDataSize maximum uncompressed data written to a single block,
+// leaving room for deflate overhead on incompressible data
+const bgzfBlockDataSize = 0xff00
+
+// bgzfEOF the standard empty block marking the end of a BGZF stream
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00,
+	0x42, 0x43, 0x02, 0x00, 0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+// BgzfWriter buffers uncompressed data and writes it to the underlying stream
+// as BGZF blocks. Blocks may be compressed concurrently on multiple goroutines,
+// in which case they are still written in order. Implements io.WriteCloser
+type BgzfWriter struct {
+	writer   io.Writer
+	data     []byte
+	cdata    bytes.Buffer
+	deflate  *flate.Writer
+	threads  int
+	parallel *bgzfParallelWriter
+}
+
+// bgzfParallelWriter compresses blocks on worker goroutines. Blocks are queued
+// in order as pending, and written in that order once compressed. The first
+// write error is retained, and returned by subsequent writes
+type bgzfParallelWriter struct {
+	jobs    chan *bgzfJob
+	pending chan *bgzfJob
+	done    chan struct{}
+	mutex   sync.Mutex
+	err     error
+}
+
+// bgzfJob a single block to be compressed (or decompressed) by a worker
+// goroutine. done is closed once the worker has finished with the block
+type bgzfJob struct {
+	block *bgzfBlock
+	data  []byte
+	err   error
+	done  chan struct{}
+}
+
+// NewBgzfWriter constructs a BgzfWriter over an output stream
+func NewBgzfWriter(writer io.Writer) *BgzfWriter {
+	bgzfWriter := new(BgzfWriter)
+	bgzfWriter.writer = writer
+	bgzfWriter.data = make([]byte, 0, bgzfBlockDataSize)
+	bgzfWriter.deflate, _ = flate.NewWriter(&bgzfWriter.cdata, flate.DefaultCompression)
+	return bgzfWriter
+}
+
+// NewBgzfWriterThreads constructs a BgzfWriter over an output stream, which
+// compresses blocks on the given number of goroutines, started once the first
+// block is flushed. With fewer than 2 threads, blocks are compressed as they
+// are flushed, as by NewBgzfWriter
+func NewBgzfWriterThreads(writer io.Writer, threads int) *BgzfWriter {
+	bgzfWriter := NewBgzfWriter(writer)
+	bgzfWriter.threads = threads
+	return bgzfWriter
+}
+
+// startParallel starts the goroutines compressing and writing blocks
+func (bgzfWriter *BgzfWriter) startParallel() {
+	threads := bgzfWriter.threads
+	writer := bgzfWriter.writer
+	parallel := new(bgzfParallelWriter)
+	parallel.jobs = make(chan *bgzfJob, threads)
+	parallel.pending = make(chan *bgzfJob, 2*threads)
+	parallel.done = make(chan struct{})
+	for i := 0; i < threads; i++ {
+		go func() {
+			var cdata bytes.Buffer
+			deflate, _ := flate.NewWriter(&cdata, flate.DefaultCompression)
+			for job := range parallel.jobs {
+				job.data, job.err = compressBgzfBlock(deflate, &cdata, job.data)
+				close(job.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(parallel.done)
+		for job := range parallel.pending {
+			<-job.done
+			err := job.err
+			if err == nil && parallel.error() == nil {
+				_, err = writer.Write(job.data)
+			}
+			if err != nil {
+				parallel.setError(err)
+			}
+		}
+	}()
+	bgzfWriter.parallel = parallel
+}
+
+// Write buffers p, writing a compressed block each time the buffer fills
+func (bgzfWriter *BgzfWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(bgzfWriter.data[len(bgzfWriter.data):cap(bgzfWriter.data)], p)
+		bgzfWriter.data = bgzfWriter.data[:len(bgzfWriter.data)+n]
+		p = p[n:]
+		written += n
+		if len(bgzfWriter.data) == cap(bgzfWriter.data) {
+			if err := bgzfWriter.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush compresses and writes any buffered data as a single block. If blocks
+// are compressed concurrently, the block is queued, and any error from
+// previously queued blocks is returned
+func (bgzfWriter *BgzfWriter) Flush() error {
+	if len(bgzfWriter.data) == 0 {
+		return nil
+	}
+	if bgzfWriter.threads > 1 && bgzfWriter.parallel == nil {
+		bgzfWriter.startParallel()
+	}
+	if parallel := bgzfWriter.parallel; parallel != nil {
+		if err := parallel.error(); err != nil {
+			return err
+		}
+		job := &bgzfJob{data: append([]byte{}, bgzfWriter.data...), done: make(chan struct{})}
+		bgzfWriter.data = bgzfWriter.data[:0]
+		parallel.pending <- job
+		parallel.jobs <- job
+		return nil
+	}
+	block, err := bgzfWriter.compressBlock(bgzfWriter.data)
+	if err != nil {
+		return err
+	}
+	bgzfWriter.data = bgzfWriter.data[:0]
+	_, err = bgzfWriter.writer.Write(block)
+	return err
+}
+
+// Close flushes any buffered data and writes the EOF marker block, waiting for
+// all queued blocks to be written. Does not close the underlying stream
+func (bgzfWriter *BgzfWriter) Close() error {
+	err := bgzfWriter.Flush()
+	if parallel := bgzfWriter.parallel; parallel != nil {
+		close(parallel.jobs)
+		close(parallel.pending)
+		<-parallel.done
+		bgzfWriter.parallel = nil
+		if err == nil {
+			err = parallel.error()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = bgzfWriter.writer.Write(bgzfEOF)
+	return err
+}
+
+// error gets the first error encountered compressing or writing blocks
+func (parallel *bgzfParallelWriter) error() error {
+	parallel.mutex.Lock()
+	defer parallel.mutex.Unlock()
+	return parallel.err
+}
+
+// setError retains an error, unless one was already encountered
+func (parallel *bgzfParallelWriter) setError(err error) {
+	parallel.mutex.Lock()
+	defer parallel.mutex.Unlock()
+	if parallel.err == nil {
+		parallel.err = err
+	}
+}
+
+// compressBlock deflates data into a complete BGZF block, including the gzip
+// header with BC subfield and the CRC32/ISIZE trailer
+func (bgzfWriter *BgzfWriter) compressBlock(data []byte) ([]byte, error) {
+	return compressBgzfBlock(bgzfWriter.deflate, &bgzfWriter.cdata, data)
+}
+
+// compressBgzfBlock deflates data into a complete BGZF block, using a deflate
+// writer over a reusable buffer
+func compressBgzfBlock(deflate *flate.Writer, cdataBuffer *bytes.Buffer, data []byte) ([]byte, error) {
+	cdataBuffer.Reset()
+	deflate.Reset(cdataBuffer)
+	if _, err := deflate.Write(data); err != nil {
+		return nil, err
+	}
+	if err := deflate.Close(); err != nil {
+		return nil, err
+	}
+	cdata := cdataBuffer.Bytes()
+
+	blockSize := bgzfHeaderLength + 6 + len(cdata) + bgzfFooterLength
+	block := make([]byte, blockSize)
+	copy(block, []byte{31, 139, 8, 4, 0, 0, 0, 0, 0, 255, 6, 0, 'B', 'C', 2, 0})
+	binary.LittleEndian.PutUint16(block[16:18], uint16(blockSize-1))
+	copy(block[18:], cdata)
+	binary.LittleEndian.PutUint32(block[blockSize-8:], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(block[blockSize-4:], uint32(len(data)))
+	return block, nil
+}
@@ -0,0 +1,88 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module bgzfwriter_test tests bgzfwriter
+package htsformats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bgzfWriterTC test cases for BgzfWriter, by uncompressed data size
+var bgzfWriterTC = []struct {
+	size      int
+	expBlocks int
+}{
+	{0, 0},
+	{100, 1},
+	{bgzfBlockDataSize, 1},
+	{bgzfBlockDataSize + 1, 2},
+	{3 * bgzfBlockDataSize, 3},
+}
+
+// TestBgzfWriter tests that written data round trips through BgzfReader and a
+// standard gzip reader, split into the expected number of blocks
+func TestBgzfWriter(t *testing.T) {
+	for _, tc := range bgzfWriterTC {
+		// random data is incompressible, the worst case for block sizing
+		data := make([]byte, tc.size)
+		rand.New(rand.NewSource(int64(tc.size))).Read(data)
+
+		var compressed bytes.Buffer
+		bgzfWriter := NewBgzfWriter(&compressed)
+		bgzfWriter.Write(data[:tc.size/2])
+		bgzfWriter.Write(data[tc.size/2:])
+		assert.Nil(t, bgzfWriter.Close())
+
+		// stream ends with the EOF marker
+		assert.True(t, bytes.HasSuffix(compressed.Bytes(), bgzfEOF))
+
+		// count blocks, excluding the EOF marker
+		blocks := -1
+		reader := bytes.NewReader(compressed.Bytes())
+		for {
+			block, err := readBgzfBlock(reader)
+			if err != nil {
+				break
+			}
+			assert.True(t, len(block.cdata) < bgzfMaxBlockSize)
+			blocks++
+		}
+		assert.Equal(t, tc.expBlocks, blocks)
+
+		actual, err := ioutil.ReadAll(NewBgzfReader(bytes.NewReader(compressed.Bytes())))
+		assert.Nil(t, err)
+		assert.Equal(t, data, actual)
+
+		gzipReader, _ := gzip.NewReader(bytes.NewReader(compressed.Bytes()))
+		actual, err = ioutil.ReadAll(gzipReader)
+		assert.Nil(t, err)
+		assert.Equal(t, data, actual)
+	}
+}
+
+// TestBgzfWriterThreads tests that blocks compressed on multiple goroutines are
+// written in order, identical to those compressed sequentially
+func TestBgzfWriterThreads(t *testing.T) {
+	for _, tc := range bgzfWriterTC {
+		data := make([]byte, tc.size)
+		rand.New(rand.NewSource(int64(tc.size))).Read(data)
+
+		var expected bytes.Buffer
+		bgzfWriter := NewBgzfWriter(&expected)
+		bgzfWriter.Write(data)
+		assert.Nil(t, bgzfWriter.Close())
+
+		var actual bytes.Buffer
+		bgzfWriter = NewBgzfWriterThreads(&actual, 4)
+		bgzfWriter.Write(data)
+		assert.Nil(t, bgzfWriter.Close())
+		assert.Equal(t, expected.Bytes(), actual.Bytes())
+	}
+}
@@ -0,0 +1,96 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module cigar parses CIGAR strings into their individual operations
+package htsformats
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// CigarOp a single CIGAR operation, ie. an operation length and character
+type CigarOp struct {
+	Length int
+	Op     byte
+}
+
+// ConsumesReference indicates whether the operation advances along the
+// reference (M, D, N, =, X)
+func (cigarOp CigarOp) ConsumesReference() bool {
+	return strings.IndexByte("MDN=X", cigarOp.Op) >= 0
+}
+
+// ConsumesQuery indicates whether the operation advances along the read
+// sequence (M, I, S, =, X)
+func (cigarOp CigarOp) ConsumesQuery() bool {
+	return strings.IndexByte("MIS=X", cigarOp.Op) >= 0
+}
+
+// ParseCigar parses a CIGAR string into a list of operations. The unavailable
+// CIGAR ("*") yields an empty list
+func ParseCigar(cigar string) ([]CigarOp, error) {
+	cigarOps := []CigarOp{}
+	if cigar == "*" {
+		return cigarOps, nil
+	}
+	if cigar == "" {
+		return nil, errors.New("Empty CIGAR")
+	}
+
+	start := 0
+	for i := 0; i < len(cigar); i++ {
+		c := cigar[i]
+		if c >= '0' && c <= '9' {
+			continue
+		}
+		if strings.IndexByte(bamCigarOps, c) < 0 {
+			return nil, errors.New("Invalid CIGAR operation '" + string(c) + "' in '" + cigar + "'")
+		}
+		length, err := strconv.Atoi(cigar[start:i])
+		if err != nil || length < 0 {
+			return nil, errors.New("Invalid CIGAR operation length in '" + cigar + "'")
+		}
+		cigarOps = append(cigarOps, CigarOp{length, c})
+		start = i + 1
+	}
+	if start != len(cigar) {
+		return nil, errors.New("CIGAR '" + cigar + "' does not end with an operation")
+	}
+	return cigarOps, nil
+}
+
+// cigarReferenceLength sums the lengths of all reference-consuming operations
+func cigarReferenceLength(cigarOps []CigarOp) int {
+	length := 0
+	for _, cigarOp := range cigarOps {
+		if cigarOp.ConsumesReference() {
+			length += cigarOp.Length
+		}
+	}
+	return length
+}
+
+// cigarQueryLength sums the lengths of all query-consuming operations
+func cigarQueryLength(cigarOps []CigarOp) int {
+	length := 0
+	for _, cigarOp := range cigarOps {
+		if cigarOp.ConsumesQuery() {
+			length += cigarOp.Length
+		}
+	}
+	return length
+}
+
+// cigarAlignedLength sums the lengths of all operations aligning query bases
+// to reference bases (M, =, X)
+func cigarAlignedLength(cigarOps []CigarOp) int {
+	length := 0
+	for _, cigarOp := range cigarOps {
+		if cigarOp.ConsumesQuery() && cigarOp.ConsumesReference() {
+			length += cigarOp.Length
+		}
+	}
+	return length
+}
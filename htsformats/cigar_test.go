@@ -0,0 +1,47 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module cigar_test tests cigar
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parseCigarTC test cases for ParseCigar
+var parseCigarTC = []struct {
+	cigar           string
+	expError        bool
+	expOps          []CigarOp
+	expReferenceLen int
+	expQueryLen     int
+	expAlignedLen   int
+}{
+	{"*", false, []CigarOp{}, 0, 0, 0},
+	{"100M", false, []CigarOp{{100, 'M'}}, 100, 100, 100},
+	{"87M521N13M", false, []CigarOp{{87, 'M'}, {521, 'N'}, {13, 'M'}}, 621, 100, 100},
+	{"5H1S10=2X3I4D2P", false, []CigarOp{{5, 'H'}, {1, 'S'}, {10, '='}, {2, 'X'}, {3, 'I'}, {4, 'D'}, {2, 'P'}}, 16, 16, 12},
+	{"", true, nil, 0, 0, 0},
+	{"M", true, nil, 0, 0, 0},
+	{"10", true, nil, 0, 0, 0},
+	{"10Q", true, nil, 0, 0, 0},
+}
+
+// TestParseCigar tests ParseCigar, cigarReferenceLength, cigarQueryLength, and
+// cigarAlignedLength
+func TestParseCigar(t *testing.T) {
+	for _, tc := range parseCigarTC {
+		cigarOps, err := ParseCigar(tc.cigar)
+		if tc.expError {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expOps, cigarOps)
+			assert.Equal(t, tc.expReferenceLen, cigarReferenceLength(cigarOps))
+			assert.Equal(t, tc.expQueryLen, cigarQueryLength(cigarOps))
+			assert.Equal(t, tc.expAlignedLen, cigarAlignedLength(cigarOps))
+		}
+	}
+}
@@ -0,0 +1,463 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module csi reads, writes, and builds coordinate-sorted indices (CSI), which
+// generalize BAI bins to a configurable minimum bin size and depth, so that
+// references longer than 2^29 bp can be indexed
+package htsformats
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// csiMagic magic bytes at the start of an uncompressed CSI file
+var csiMagic = []byte("CSI\x01")
+
+// CsiDefaultMinShift default log2 of the size of the smallest bins (16 kbp),
+// as used by BAI
+const CsiDefaultMinShift = 14
+
+// CsiDefaultDepth default number of levels of bins below the bin spanning all
+// positions, as used by BAI
+const CsiDefaultDepth = 5
+
+// csiMaxDepth the largest depth for which every bin number, including the
+// pseudo-bin, fits in 32 bits
+const csiMaxDepth = 10
+
+// CsiReference index of the alignments on a single reference. Bins map each
+// bin number to its chunks, and Offsets map it to the offset of the first
+// alignment overlapping the bin's start. If present in the pseudo-bin, Begin
+// and End span the reference's alignments, with counts of mapped and unmapped
+// alignments placed on it
+type CsiReference struct {
+	Bins     map[uint32][]IndexChunk
+	Offsets  map[uint32]VirtualOffset
+	HasStats bool
+	Begin    VirtualOffset
+	End      VirtualOffset
+	Mapped   uint64
+	Unmapped uint64
+}
+
+// CsiIndex a parsed CSI file, indexing the references of a coordinate-sorted
+// BAM file in the order of its reference dictionary. The smallest bins span
+// 2^MinShift positions, and each of the Depth levels above has bins 8 times
+// larger. Aux holds format-specific metadata, empty for BAM. NoCoordinate is
+// the number of unplaced unmapped alignments, if recorded
+type CsiIndex struct {
+	MinShift        int
+	Depth           int
+	Aux             []byte
+	References      []*CsiReference
+	HasNoCoordinate bool
+	NoCoordinate    uint64
+}
+
+// NewCsiIndex constructs an empty CsiIndex with the given binning scheme
+func NewCsiIndex(minShift int, depth int) (*CsiIndex, error) {
+	if minShift < 1 || depth < 1 || depth > csiMaxDepth || minShift+3*depth > 62 {
+		return nil, errors.New("Invalid CSI min_shift and depth: " + strconv.Itoa(minShift) + ", " + strconv.Itoa(depth))
+	}
+	csiIndex := new(CsiIndex)
+	csiIndex.MinShift = minShift
+	csiIndex.Depth = depth
+	csiIndex.Aux = []byte{}
+	csiIndex.References = []*CsiReference{}
+	return csiIndex, nil
+}
+
+// newCsiReference constructs an empty CsiReference
+func newCsiReference() *CsiReference {
+	csiReference := new(CsiReference)
+	csiReference.Bins = make(map[uint32][]IndexChunk)
+	csiReference.Offsets = make(map[uint32]VirtualOffset)
+	return csiReference
+}
+
+// pseudoBin gets the bin number holding the offsets and read counts of a
+// reference, following the last bin of the deepest level
+func (csiIndex *CsiIndex) pseudoBin() uint32 {
+	return uint32(binFirst(csiIndex.Depth+1) + 1)
+}
+
+// MaxPosition gets the end of the largest interval indexed by the bins
+func (csiIndex *CsiIndex) MaxPosition() int {
+	return 1 << uint(csiIndex.MinShift+3*csiIndex.Depth)
+}
+
+// ReadCsi parses a CSI file, which may be BGZF-compressed
+func ReadCsi(reader io.Reader) (*CsiIndex, error) {
+	reader = decompressIndex(reader)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != string(csiMagic) {
+		return nil, errors.New("Invalid CSI magic bytes")
+	}
+
+	indexReader := newIndexReader(reader, "CSI")
+	minShift := int(int32(indexReader.uint32()))
+	depth := int(int32(indexReader.uint32()))
+	if indexReader.err != nil {
+		return nil, indexReader.err
+	}
+	csiIndex, err := NewCsiIndex(minShift, depth)
+	if err != nil {
+		return nil, err
+	}
+	csiIndex.Aux = append(csiIndex.Aux, indexReader.read(indexReader.count())...)
+
+	pseudoBin := csiIndex.pseudoBin()
+	nRef := indexReader.count()
+	for i := 0; i < nRef && indexReader.err == nil; i++ {
+		csiReference := newCsiReference()
+		nBin := indexReader.count()
+		for j := 0; j < nBin && indexReader.err == nil; j++ {
+			bin := indexReader.uint32()
+			offset := VirtualOffset(indexReader.uint64())
+			chunks := indexReader.chunks()
+
+			// the pseudo-bin holds the reference's offsets and read counts
+			if bin == pseudoBin && len(chunks) == 2 {
+				csiReference.HasStats = true
+				csiReference.Begin, csiReference.End = chunks[0].Begin, chunks[0].End
+				csiReference.Mapped, csiReference.Unmapped = uint64(chunks[1].Begin), uint64(chunks[1].End)
+				continue
+			}
+			csiReference.Bins[bin] = append(csiReference.Bins[bin], chunks...)
+			csiReference.Offsets[bin] = offset
+		}
+		csiIndex.References = append(csiIndex.References, csiReference)
+	}
+	if indexReader.err != nil {
+		return nil, indexReader.err
+	}
+
+	// the count of unplaced unmapped alignments is optional
+	csiIndex.HasNoCoordinate, csiIndex.NoCoordinate = indexReader.noCoordinate()
+	return csiIndex, nil
+}
+
+// WriteCsi writes a CSI file, BGZF-compressed as produced by htslib. Bins are
+// written in ascending order
+func WriteCsi(writer io.Writer, csiIndex *CsiIndex) error {
+	bgzfWriter := NewBgzfWriter(writer)
+	if _, err := bgzfWriter.Write(csiIndex.encode()); err != nil {
+		return err
+	}
+	return bgzfWriter.Close()
+}
+
+// encode serializes the index in the uncompressed CSI format
+func (csiIndex *CsiIndex) encode() []byte {
+	buf := append([]byte{}, csiMagic...)
+	buf = appendInt32(buf, int32(csiIndex.MinShift))
+	buf = appendInt32(buf, int32(csiIndex.Depth))
+	buf = appendInt32(buf, int32(len(csiIndex.Aux)))
+	buf = append(buf, csiIndex.Aux...)
+	buf = appendInt32(buf, int32(len(csiIndex.References)))
+	for _, csiReference := range csiIndex.References {
+		bins := []int{}
+		for bin := range csiReference.Bins {
+			bins = append(bins, int(bin))
+		}
+		sort.Ints(bins)
+		nBin := len(bins)
+		if csiReference.HasStats {
+			nBin++
+		}
+		buf = appendInt32(buf, int32(nBin))
+		for _, bin := range bins {
+			chunks := csiReference.Bins[uint32(bin)]
+			buf = appendUint32(buf, uint32(bin))
+			buf = appendUint64(buf, uint64(csiReference.Offsets[uint32(bin)]))
+			buf = appendInt32(buf, int32(len(chunks)))
+			for _, chunk := range chunks {
+				buf = appendUint64(buf, uint64(chunk.Begin))
+				buf = appendUint64(buf, uint64(chunk.End))
+			}
+		}
+		if csiReference.HasStats {
+			buf = appendUint32(buf, csiIndex.pseudoBin())
+			buf = appendUint64(buf, 0)
+			buf = appendInt32(buf, 2)
+			buf = appendUint64(buf, uint64(csiReference.Begin))
+			buf = appendUint64(buf, uint64(csiReference.End))
+			buf = appendUint64(buf, csiReference.Mapped)
+			buf = appendUint64(buf, csiReference.Unmapped)
+		}
+	}
+	if csiIndex.HasNoCoordinate {
+		buf = appendUint64(buf, csiIndex.NoCoordinate)
+	}
+	return buf
+}
+
+// Query gets the chunks holding alignments that may overlap an interval of a
+// reference, given by its id in the reference dictionary and 0-based start
+// (inclusive) and end (exclusive) positions, either of which may be
+// RegionUnbounded. Chunks are sorted, with overlapping and adjacent chunks
+// merged. Returns an error if the start is beyond the positions the binning
+// scheme indexes
+func (csiIndex *CsiIndex) Query(refID int, start int, end int) ([]IndexChunk, error) {
+	if refID < 0 || refID >= len(csiIndex.References) {
+		return nil, errors.New("CSI reference id out of range: " + strconv.Itoa(refID))
+	}
+	if start != RegionUnbounded && start >= csiIndex.MaxPosition() {
+		return nil, errors.New("Start position " + strconv.Itoa(start) + " is beyond the " + strconv.Itoa(csiIndex.MaxPosition()) + " positions indexed with min_shift " + strconv.Itoa(csiIndex.MinShift) + " and depth " + strconv.Itoa(csiIndex.Depth))
+	}
+	start, end = indexInterval(start, end, csiIndex.MaxPosition())
+	csiReference := csiIndex.References[refID]
+
+	// chunks ending before the first alignment overlapping the smallest bin at
+	// the start, or the nearest indexed bin to its left or above, cannot
+	// overlap the interval
+	minOffset := VirtualOffset(0)
+	for bin := binFirst(csiIndex.Depth) + start>>uint(csiIndex.MinShift); ; {
+		if offset, ok := csiReference.Offsets[uint32(bin)]; ok {
+			minOffset = offset
+			break
+		}
+		if bin == 0 {
+			break
+		}
+		if bin > binParent(bin)<<3+1 {
+			bin--
+		} else {
+			bin = binParent(bin)
+		}
+	}
+
+	chunks := []IndexChunk{}
+	for _, bin := range indexReg2bins(start, end, csiIndex.MinShift, csiIndex.Depth) {
+		for _, chunk := range csiReference.Bins[uint32(bin)] {
+			if chunk.End > minOffset {
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+	return mergeIndexChunks(chunks), nil
+}
+
+// UnplacedOffset gets the offset following the alignments of every reference,
+// where unplaced unmapped alignments begin in a coordinate-sorted BAM file.
+// Returns false if no reference has alignments, so the offset is unknown
+func (csiIndex *CsiIndex) UnplacedOffset() (VirtualOffset, bool) {
+	offset, ok := VirtualOffset(0), false
+	for _, csiReference := range csiIndex.References {
+		if csiReference.HasStats && csiReference.End >= offset {
+			offset, ok = csiReference.End, true
+		}
+		if end, found := maxChunkEnd(csiReference.Bins); found && end >= offset {
+			offset, ok = end, true
+		}
+	}
+	return offset, ok
+}
+
+// csiBuilder accumulates the index of a coordinate-sorted BAM file, one
+// alignment at a time. The alignments of the current reference are grouped
+// into a chunk while they fall in the same bin, and windows hold the offset
+// of the first alignment overlapping each of the smallest bins
+type csiBuilder struct {
+	csiIndex  *CsiIndex
+	reference *CsiReference
+	refID     int
+	pos       int
+	bin       int
+	chunk     IndexChunk
+	windows   []VirtualOffset
+}
+
+// BuildCsi indexes a coordinate-sorted BAM file, read from the start of its
+// BGZF-compressed stream. The smallest bins span 2^minShift positions. If
+// depth is not positive, the smallest depth covering the longest reference is
+// used
+func BuildCsi(reader io.Reader, minShift int, depth int) (*CsiIndex, error) {
+	bgzfReader := NewBgzfReader(reader)
+	bamReader, err := NewBamReader(bgzfReader)
+	if err != nil {
+		return nil, err
+	}
+	if depth <= 0 {
+		depth = csiDepth(minShift, bamReader.References())
+	}
+	csiIndex, err := NewCsiIndex(minShift, depth)
+	if err != nil {
+		return nil, err
+	}
+	for _, reference := range bamReader.References() {
+		if reference.Length > csiIndex.MaxPosition() {
+			return nil, errors.New("Reference '" + reference.Name + "' length " + strconv.Itoa(reference.Length) + " exceeds the " + strconv.Itoa(csiIndex.MaxPosition()) + " positions indexed with min_shift " + strconv.Itoa(minShift) + " and depth " + strconv.Itoa(depth))
+		}
+		csiIndex.References = append(csiIndex.References, newCsiReference())
+	}
+
+	builder := &csiBuilder{csiIndex: csiIndex, refID: -1, bin: -1}
+	for {
+		begin := bgzfReader.VirtualOffset()
+		data, err := bamReader.NextData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		refID, flag, start, end, err := bamRecordSpan(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := builder.push(refID, flag, start, end, IndexChunk{begin, bgzfReader.VirtualOffset()}); err != nil {
+			return nil, err
+		}
+	}
+	builder.finishReference()
+	csiIndex.HasNoCoordinate = true
+	return csiIndex, nil
+}
+
+// push adds an alignment, given its reference id, FLAG, span, and the chunk
+// holding its binary representation
+func (builder *csiBuilder) push(refID int, flag int, start int, end int, chunk IndexChunk) error {
+	csiIndex := builder.csiIndex
+
+	// unplaced alignments follow all others, and are only counted
+	if refID == -1 {
+		builder.finishReference()
+		builder.refID = len(csiIndex.References)
+		csiIndex.NoCoordinate++
+		return nil
+	}
+	if refID < 0 || refID >= len(csiIndex.References) {
+		return errors.New("BAM record reference id out of range: " + strconv.Itoa(refID))
+	}
+	if refID < builder.refID || (refID == builder.refID && start < builder.pos) {
+		return errors.New("BAM file is not sorted by coordinate")
+	}
+	if start < 0 || end > csiIndex.MaxPosition() {
+		return errors.New("Alignment span " + strconv.Itoa(start) + "-" + strconv.Itoa(end) + " is outside the " + strconv.Itoa(csiIndex.MaxPosition()) + " positions indexed")
+	}
+	if refID != builder.refID {
+		builder.finishReference()
+		builder.refID = refID
+		builder.reference = csiIndex.References[refID]
+		builder.windows = []VirtualOffset{}
+	}
+	builder.pos = start
+
+	reference := builder.reference
+	if !reference.HasStats {
+		reference.HasStats = true
+		reference.Begin = chunk.Begin
+	}
+	reference.End = chunk.End
+	if SamFlag(flag).Has(FlagUnmapped) {
+		reference.Unmapped++
+	} else {
+		reference.Mapped++
+	}
+
+	for window := start >> uint(csiIndex.MinShift); window <= (end-1)>>uint(csiIndex.MinShift); window++ {
+		for len(builder.windows) <= window {
+			builder.windows = append(builder.windows, 0)
+		}
+		if builder.windows[window] == 0 {
+			builder.windows[window] = chunk.Begin
+		}
+	}
+
+	if bin := indexReg2bin(start, end, csiIndex.MinShift, csiIndex.Depth); bin != builder.bin {
+		builder.saveChunk()
+		builder.bin = bin
+		builder.chunk.Begin = chunk.Begin
+	}
+	builder.chunk.End = chunk.End
+	return nil
+}
+
+// saveChunk adds the current chunk to its bin, extending the bin's last chunk
+// if they are contiguous or share a BGZF block
+func (builder *csiBuilder) saveChunk() {
+	if builder.bin < 0 {
+		return
+	}
+	bin := uint32(builder.bin)
+	chunks := builder.reference.Bins[bin]
+	last := len(chunks) - 1
+	if last >= 0 && (chunks[last].End >= builder.chunk.Begin || chunks[last].End.Compressed() == builder.chunk.Begin.Compressed()) {
+		chunks[last].End = builder.chunk.End
+	} else {
+		builder.reference.Bins[bin] = append(chunks, builder.chunk)
+	}
+	builder.bin = -1
+}
+
+// finishReference saves the last chunk of the current reference, and sets the
+// offset of each bin from the first window at or after its start overlapped
+// by an alignment
+func (builder *csiBuilder) finishReference() {
+	if builder.reference == nil {
+		return
+	}
+	builder.saveChunk()
+	next := VirtualOffset(0)
+	for window := len(builder.windows) - 1; window >= 0; window-- {
+		if builder.windows[window] == 0 {
+			builder.windows[window] = next
+		}
+		next = builder.windows[window]
+	}
+	for bin := range builder.reference.Bins {
+		if window := binWindow(int(bin), builder.csiIndex.Depth); window < len(builder.windows) {
+			builder.reference.Offsets[bin] = builder.windows[window]
+		} else {
+			builder.reference.Offsets[bin] = 0
+		}
+	}
+	builder.reference = nil
+}
+
+// csiDepth gets the smallest depth whose bins cover the longest reference with
+// some margin, as chosen by htslib
+func csiDepth(minShift int, references []BamReference) int {
+	maxLength := 0
+	for _, reference := range references {
+		if reference.Length > maxLength {
+			maxLength = reference.Length
+		}
+	}
+	depth := 1
+	for size := 1 << uint(minShift+3); maxLength+256 > size && depth < csiMaxDepth; size <<= 3 {
+		depth++
+	}
+	return depth
+}
+
+// bamRecordSpan gets the reference id, FLAG, and 0-based, half-open span of an
+// alignment from its binary representation (excluding block_size), without
+// decoding it. Alignments without reference-consuming operations span a
+// single position
+func bamRecordSpan(data []byte) (int, int, int, int, error) {
+	refID := int(int32(binary.LittleEndian.Uint32(data[0:4])))
+	pos := int(int32(binary.LittleEndian.Uint32(data[4:8])))
+	nCigarOp := int(binary.LittleEndian.Uint16(data[12:14]))
+	flag := int(binary.LittleEndian.Uint16(data[14:16]))
+	offset := bamFixedLength + int(data[8])
+	if offset+nCigarOp*4 > len(data) {
+		return 0, 0, 0, 0, errors.New("Invalid BAM record lengths")
+	}
+	length := 0
+	for i := 0; i < nCigarOp; i++ {
+		value := binary.LittleEndian.Uint32(data[offset+4*i:])
+		if op := int(value & 0xf); op < len(bamCigarOps) && (CigarOp{int(value >> 4), bamCigarOps[op]}).ConsumesReference() {
+			length += int(value >> 4)
+		}
+	}
+	if length == 0 {
+		length = 1
+	}
+	return refID, flag, pos, pos + length, nil
+}
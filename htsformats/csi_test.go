@@ -0,0 +1,256 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module csi_test tests csi
+package htsformats
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// csiLargeHeader header of BAM files built by CSI test cases, with a reference
+// longer than BAI can index
+var csiLargeHeader = []string{
+	"@HD\tVN:1.6\tSO:coordinate",
+	"@SQ\tSN:chr1\tLN:100000",
+	"@SQ\tSN:chrL\tLN:1200000000",
+}
+
+// csiLargeQueryTC test cases for CsiIndex Query on the index of a BAM file
+// with alignments beyond 2^29 bp, by the number of alignments expected
+var csiLargeQueryTC = []struct {
+	refID    int
+	start    int
+	end      int
+	expCount int
+}{
+	{0, 0, 100000, 1},
+	{1, 0, 100000, 1},
+	{1, 600000000, 600000100, 1},
+	{1, 1100000000, RegionUnbounded, 1},
+	{1, 700000000, 800000000, 0},
+	{1, RegionUnbounded, RegionUnbounded, 3},
+}
+
+// buildTestCsi indexes the BAM test file
+func buildTestCsi(minShift int, depth int) *CsiIndex {
+	file, _ := os.Open("../data/test/input/modify-sam.bam")
+	defer file.Close()
+	csiIndex, err := BuildCsi(file, minShift, depth)
+	if err != nil {
+		panic(err)
+	}
+	return csiIndex
+}
+
+// writeLargeTestBam writes a BAM file with alignments on a reference longer
+// than BAI can index, followed by an unplaced unmapped alignment
+func writeLargeTestBam() []byte {
+	var compressed bytes.Buffer
+	bamWriter := NewBamWriter(NewBgzfWriter(&compressed))
+	bamWriter.WriteHeader(csiLargeHeader)
+	for i, position := range []string{"chr1\t101", "chrL\t5001", "chrL\t600000051", "chrL\t1150000001", "*\t0"} {
+		bamWriter.Write(newTestSamRecord("r" + strconv.Itoa(i) + "\t0\t" + position + "\t60\t100M\t*\t0\t0\t*\t*"))
+	}
+	bamWriter.Close()
+	return compressed.Bytes()
+}
+
+// countChunkRecords counts the BAM records within chunks of a BAM file
+func countChunkRecords(data []byte, chunks []IndexChunk) int {
+	count := 0
+	for _, chunk := range chunks {
+		base := chunk.Begin.Compressed()
+		end := NewVirtualOffset(chunk.End.Compressed()-base, chunk.End.Uncompressed())
+		bgzfReader := NewBgzfReader(bytes.NewReader(data[base:]))
+		io.CopyN(ioutil.Discard, bgzfReader, int64(chunk.Begin.Uncompressed()))
+		bamReader := &BamReader{reader: bgzfReader}
+		for bgzfReader.VirtualOffset() < end {
+			if _, err := bamReader.NextData(); err != nil {
+				break
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// TestBuildCsi tests that an index built with the BAI binning scheme locates
+// the same chunks as the BAI index of the BAM test file, and that other
+// binning schemes locate the same data
+func TestBuildCsi(t *testing.T) {
+	csiIndex := buildTestCsi(CsiDefaultMinShift, 0)
+	assert.Equal(t, CsiDefaultDepth, csiIndex.Depth)
+	assert.Equal(t, 1, len(csiIndex.References))
+	assert.Equal(t, uint64(30), csiIndex.References[0].Mapped)
+	assert.True(t, csiIndex.HasNoCoordinate)
+	expOffset, _ := openTestBai().UnplacedOffset()
+	unplacedOffset, ok := csiIndex.UnplacedOffset()
+	assert.True(t, ok)
+	assert.Equal(t, expOffset, unplacedOffset)
+	for _, tc := range baiIndexQueryTC {
+		chunks, err := csiIndex.Query(0, tc.start, tc.end)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expChunks, chunks, tc.start, tc.end)
+	}
+
+	file, _ := os.Open("../data/test/input/modify-sam.bam")
+	defer file.Close()
+	for _, scheme := range [][2]int{{12, 6}, {16, 5}, {20, 4}} {
+		csiIndex = buildTestCsi(scheme[0], scheme[1])
+		for _, tc := range baiIndexQueryTC {
+			chunks, err := csiIndex.Query(0, tc.start, tc.end)
+			assert.Nil(t, err)
+			dataSegments, _ := BamChunkSegments(file, chunks)
+			expDataSegments, _ := BamChunkSegments(file, tc.expChunks)
+			assert.Equal(t, expDataSegments, dataSegments, scheme, tc.start, tc.end)
+		}
+	}
+}
+
+// TestBuildCsiLargeReference tests indexing alignments beyond the positions
+// indexed by BAI, which requires a deeper binning scheme
+func TestBuildCsiLargeReference(t *testing.T) {
+	data := writeLargeTestBam()
+	_, err := BuildCsi(bytes.NewReader(data), CsiDefaultMinShift, CsiDefaultDepth)
+	assert.Equal(t, "Reference 'chrL' length 1200000000 exceeds the 536870912 positions indexed with min_shift 14 and depth 5", err.Error())
+
+	csiIndex, err := BuildCsi(bytes.NewReader(data), CsiDefaultMinShift, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, csiIndex.Depth)
+	assert.Equal(t, uint64(1), csiIndex.NoCoordinate)
+
+	for _, tc := range csiLargeQueryTC {
+		chunks, err := csiIndex.Query(tc.refID, tc.start, tc.end)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expCount, countChunkRecords(data, chunks), tc.refID, tc.start, tc.end)
+	}
+}
+
+// TestBuildCsiUnplaced tests indexing a BAM file without alignments on any
+// reference, for which the offset of unplaced alignments is unknown
+func TestBuildCsiUnplaced(t *testing.T) {
+	var compressed bytes.Buffer
+	bamWriter := NewBamWriter(NewBgzfWriter(&compressed))
+	bamWriter.WriteHeader(csiLargeHeader)
+	bamWriter.Write(newTestSamRecord("r1\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\tFFFF"))
+	bamWriter.Close()
+	csiIndex, err := BuildCsi(bytes.NewReader(compressed.Bytes()), CsiDefaultMinShift, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), csiIndex.NoCoordinate)
+	_, ok := csiIndex.UnplacedOffset()
+	assert.False(t, ok)
+}
+
+// TestBuildCsiError tests BuildCsi on unsorted input and invalid schemes
+func TestBuildCsiError(t *testing.T) {
+	var compressed bytes.Buffer
+	bamWriter := NewBamWriter(NewBgzfWriter(&compressed))
+	bamWriter.WriteHeader(csiLargeHeader)
+	bamWriter.Write(newTestSamRecord("r1\t0\tchrL\t101\t60\t100M\t*\t0\t0\t*\t*"))
+	bamWriter.Write(newTestSamRecord("r2\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*"))
+	bamWriter.Close()
+	_, err := BuildCsi(bytes.NewReader(compressed.Bytes()), CsiDefaultMinShift, 0)
+	assert.Equal(t, "BAM file is not sorted by coordinate", err.Error())
+
+	_, err = BuildCsi(bytes.NewReader(writeLargeTestBam()), 0, CsiDefaultDepth)
+	assert.Equal(t, "Invalid CSI min_shift and depth: 0, 5", err.Error())
+	_, err = BuildCsi(bytes.NewReader([]byte("not a BAM file")), CsiDefaultMinShift, 0)
+	assert.NotNil(t, err)
+}
+
+// TestWriteCsi tests that WriteCsi writes a BGZF-compressed index read back
+// by ReadCsi unchanged
+func TestWriteCsi(t *testing.T) {
+	for _, csiIndex := range []*CsiIndex{buildTestCsi(CsiDefaultMinShift, 0), buildTestCsi(12, 7)} {
+		var buf bytes.Buffer
+		assert.Nil(t, WriteCsi(&buf, csiIndex))
+		assert.Equal(t, []byte{0x1f, 0x8b}, buf.Bytes()[:2])
+		actual, err := ReadCsi(&buf)
+		assert.Nil(t, err)
+		assert.Equal(t, csiIndex, actual)
+	}
+
+	// uncompressed, with auxiliary data and without the unplaced count
+	csiIndex, _ := NewCsiIndex(14, 5)
+	csiIndex.Aux = []byte("meta")
+	csiIndex.References = append(csiIndex.References, newCsiReference())
+	actual, err := ReadCsi(bytes.NewReader(csiIndex.encode()))
+	assert.Nil(t, err)
+	assert.Equal(t, csiIndex, actual)
+}
+
+// TestReadCsiError tests ReadCsi on invalid input
+func TestReadCsiError(t *testing.T) {
+	_, err := ReadCsi(bytes.NewReader(baiMagic))
+	assert.Equal(t, "Invalid CSI magic bytes", err.Error())
+
+	csiIndex := buildTestCsi(CsiDefaultMinShift, 0)
+	data := csiIndex.encode()
+	_, err = ReadCsi(bytes.NewReader(data[:100]))
+	assert.Equal(t, "Truncated CSI index", err.Error())
+
+	data = appendInt32(appendInt32(append([]byte{}, csiMagic...), 14), 12)
+	_, err = ReadCsi(bytes.NewReader(data))
+	assert.Equal(t, "Invalid CSI min_shift and depth: 14, 12", err.Error())
+
+	_, err = csiIndex.Query(1, 0, 100)
+	assert.Equal(t, "CSI reference id out of range: 1", err.Error())
+}
+
+// TestCsiIndexQueryBeyondMaxPosition tests that querying an index with data
+// past the positions its binning scheme indexes returns an error, rather than
+// chunks of bins of deeper levels
+func TestCsiIndexQueryBeyondMaxPosition(t *testing.T) {
+	for _, csiIndex := range []*CsiIndex{buildTestCsi(CsiDefaultMinShift, 0), buildTestCsi(12, 6)} {
+		chunks, err := csiIndex.Query(0, csiIndex.MaxPosition()-1, RegionUnbounded)
+		assert.Nil(t, err)
+		assert.Equal(t, []IndexChunk{}, chunks)
+		_, err = csiIndex.Query(0, csiIndex.MaxPosition(), RegionUnbounded)
+		assert.Equal(t, "Start position "+strconv.Itoa(csiIndex.MaxPosition())+" is beyond the "+strconv.Itoa(csiIndex.MaxPosition())+" positions indexed with min_shift "+strconv.Itoa(csiIndex.MinShift)+" and depth "+strconv.Itoa(csiIndex.Depth), err.Error())
+	}
+}
+
+// TestReadAlignmentIndex tests that ReadAlignmentIndex detects the format of
+// BAI and CSI files
+func TestReadAlignmentIndex(t *testing.T) {
+	for _, path := range []string{"../data/test/input/modify-sam.bam.bai", "../data/test/input/modify-sam.bam.csi"} {
+		file, _ := os.Open(path)
+		alignmentIndex, err := ReadAlignmentIndex(file)
+		file.Close()
+		assert.Nil(t, err)
+		chunks, err := alignmentIndex.Query(0, RegionUnbounded, RegionUnbounded)
+		assert.Nil(t, err, path)
+		assert.Equal(t, []IndexChunk{{NewVirtualOffset(0, 1754), NewVirtualOffset(3285, 0)}}, chunks, path)
+	}
+
+	file, _ := os.Open("../data/test/input/modify-sam.bam.bai")
+	defer file.Close()
+	alignmentIndex, _ := ReadAlignmentIndex(file)
+	assert.IsType(t, &BaiIndex{}, alignmentIndex)
+
+	_, err := ReadAlignmentIndex(bytes.NewReader([]byte("@HD\tVN:1.6")))
+	assert.Equal(t, "Unrecognized index format, expected BAI or CSI", err.Error())
+}
+
+// TestIndexReg2bin tests the bins of the CSI binning scheme, which for the
+// default scheme are expected to match BAI bins
+func TestIndexReg2bin(t *testing.T) {
+	for _, interval := range [][2]int{{0, 1}, {16383, 16385}, {1 << 20, 1<<20 + 5000}, {100000, 9000000}, {0, 1 << 29}} {
+		bin := indexReg2bin(interval[0], interval[1], CsiDefaultMinShift, CsiDefaultDepth)
+		assert.Equal(t, reg2bin(interval[0], interval[1]), bin, interval)
+		assert.Contains(t, indexReg2bins(interval[0], interval[1], 12, 7), indexReg2bin(interval[0], interval[1], 12, 7), interval)
+	}
+	assert.Equal(t, 4681, binFirst(5))
+	assert.Equal(t, 585, binParent(4681))
+	assert.Equal(t, 5, binLevel(4681))
+	assert.Equal(t, 8, binWindow(2, 2))
+	assert.Equal(t, uint32(37450), buildTestCsi(CsiDefaultMinShift, 0).pseudoBin())
+}
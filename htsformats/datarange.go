@@ -0,0 +1,199 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module datarange locates the header and alignments within SAM and BAM files
+// as byte ranges, eg. for the Range headers of htsget tickets
+package htsformats
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ByteRange a range of bytes within a file, from Start (inclusive) to End
+// (exclusive)
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Empty indicates whether the range contains no bytes
+func (byteRange ByteRange) Empty() bool {
+	return byteRange.End <= byteRange.Start
+}
+
+// HTTPRange gets the value of an HTTP Range header requesting the byte range,
+// whose end is inclusive
+func (byteRange ByteRange) HTTPRange() string {
+	return "bytes=" + strconv.FormatInt(byteRange.Start, 10) + "-" + strconv.FormatInt(byteRange.End-1, 10)
+}
+
+// SamDataRanges locates the header lines and the alignment lines of a plain
+// text SAM file of a given size, read from the start
+func SamDataRanges(reader io.Reader, size int64) (ByteRange, ByteRange, error) {
+	lineReader := NewLineReader(reader)
+	for {
+		offset := lineReader.offset
+		line, err := lineReader.Next()
+		if err == io.EOF {
+			return ByteRange{0, size}, ByteRange{size, size}, nil
+		}
+		if err != nil {
+			return ByteRange{}, ByteRange{}, err
+		}
+		if !strings.HasPrefix(line, "@") {
+			return ByteRange{0, offset}, ByteRange{offset, size}, nil
+		}
+	}
+}
+
+// DataSegment a part of the data of a file: either a byte range within it or,
+// for a BGZF block only part of whose uncompressed data belongs to the part,
+// that data recompressed as a standalone block, such that segments of a BAM
+// file concatenate into a valid BGZF stream
+type DataSegment struct {
+	Range ByteRange
+	Data  []byte
+}
+
+// Empty indicates whether the segment contains no bytes
+func (dataSegment DataSegment) Empty() bool {
+	return dataSegment.Data == nil && dataSegment.Range.Empty()
+}
+
+// BamDataSegments locates the header and the alignments of a BAM file of a
+// given size. Whole BGZF blocks are located by byte range, while a block holding
+// both the end of the header and the first alignments is split, each part
+// recompressed as a block of its own. The alignment segments include the EOF
+// marker block
+func BamDataSegments(reader io.ReaderAt, size int64) ([]DataSegment, []DataSegment, error) {
+	bgzfReader := NewBgzfReader(io.NewSectionReader(reader, 0, size))
+	if _, err := NewBamReader(bgzfReader); err != nil {
+		return nil, nil, err
+	}
+	headerEnd := bgzfReader.VirtualOffset()
+	header, err := bamSegments(reader, NewVirtualOffset(0, 0), headerEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := bamSegments(reader, headerEnd, NewVirtualOffset(size, 0))
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, body, nil
+}
+
+// BamChunkSegments locates index chunks of a BAM file, such as those returned
+// by a BAI query. Chunks beginning or ending part way through a BGZF block
+// are trimmed to their exact virtual offsets, recompressing the data of the
+// partial blocks, so segments hold whole alignments only. Adjacent byte ranges
+// are merged
+func BamChunkSegments(reader io.ReaderAt, chunks []IndexChunk) ([]DataSegment, error) {
+	dataSegments := []DataSegment{}
+	for _, chunk := range chunks {
+		chunkSegments, err := bamSegments(reader, chunk.Begin, chunk.End)
+		if err != nil {
+			return nil, err
+		}
+		for _, dataSegment := range chunkSegments {
+			last := len(dataSegments) - 1
+			if last >= 0 && dataSegment.Data == nil && dataSegments[last].Data == nil && dataSegment.Range.Start <= dataSegments[last].Range.End {
+				if dataSegment.Range.End > dataSegments[last].Range.End {
+					dataSegments[last].Range.End = dataSegment.Range.End
+				}
+				continue
+			}
+			dataSegments = append(dataSegments, dataSegment)
+		}
+	}
+	return dataSegments, nil
+}
+
+// bamSegments locates the uncompressed data between two virtual offsets of a
+// BGZF file, the whole blocks by byte range and the data of the partial blocks
+// at either end recompressed
+func bamSegments(reader io.ReaderAt, begin VirtualOffset, end VirtualOffset) ([]DataSegment, error) {
+	dataSegments := []DataSegment{}
+	if end <= begin {
+		return dataSegments, nil
+	}
+	start := begin.Compressed()
+	if begin.Uncompressed() > 0 {
+		block, data, err := readBgzfBlockAt(reader, start)
+		if err != nil {
+			return nil, err
+		}
+		if begin.Uncompressed() > len(data) {
+			return nil, errors.New("Virtual offset out of range of BGZF block: " + begin.String())
+		}
+		stop := len(data)
+		if end.Compressed() == start {
+			if end.Uncompressed() > len(data) {
+				return nil, errors.New("Virtual offset out of range of BGZF block: " + end.String())
+			}
+			stop = end.Uncompressed()
+		}
+		if dataSegments, err = appendBgzfData(dataSegments, data[begin.Uncompressed():stop]); err != nil {
+			return nil, err
+		}
+		if end.Compressed() == start {
+			return dataSegments, nil
+		}
+		start += int64(block.size)
+	}
+	if end.Compressed() > start {
+		dataSegments = append(dataSegments, DataSegment{Range: ByteRange{start, end.Compressed()}})
+	}
+	if end.Uncompressed() > 0 {
+		_, data, err := readBgzfBlockAt(reader, end.Compressed())
+		if err != nil {
+			return nil, err
+		}
+		if end.Uncompressed() > len(data) {
+			return nil, errors.New("Virtual offset out of range of BGZF block: " + end.String())
+		}
+		if dataSegments, err = appendBgzfData(dataSegments, data[:end.Uncompressed()]); err != nil {
+			return nil, err
+		}
+	}
+	return dataSegments, nil
+}
+
+// readBgzfBlockAt reads and decompresses the BGZF block at an offset
+func readBgzfBlockAt(reader io.ReaderAt, address int64) (*bgzfBlock, []byte, error) {
+	block, err := readBgzfBlock(io.NewSectionReader(reader, address, bgzfMaxBlockSize))
+	if err == io.EOF {
+		return nil, nil, errors.New("No BGZF block at offset " + strconv.FormatInt(address, 10))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := block.inflate()
+	return block, data, err
+}
+
+// appendBgzfData appends a segment holding uncompressed data compressed as
+// BGZF blocks, unless the data is empty
+func appendBgzfData(dataSegments []DataSegment, data []byte) ([]DataSegment, error) {
+	if len(data) == 0 {
+		return dataSegments, nil
+	}
+	var cdata, blocks bytes.Buffer
+	deflate, _ := flate.NewWriter(&cdata, flate.DefaultCompression)
+	for start := 0; start < len(data); start += bgzfBlockDataSize {
+		end := start + bgzfBlockDataSize
+		if end > len(data) {
+			end = len(data)
+		}
+		block, err := compressBgzfBlock(deflate, &cdata, data[start:end])
+		if err != nil {
+			return nil, err
+		}
+		blocks.Write(block)
+	}
+	return append(dataSegments, DataSegment{Data: blocks.Bytes()}), nil
+}
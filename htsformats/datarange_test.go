@@ -0,0 +1,138 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module datarange_test tests datarange
+package htsformats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// samDataRangesTC test cases for SamDataRanges, by input text
+var samDataRangesTC = []struct {
+	input     string
+	expHeader ByteRange
+	expBody   ByteRange
+}{
+	{"", ByteRange{0, 0}, ByteRange{0, 0}},
+	{"@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n", ByteRange{0, 30}, ByteRange{30, 30}},
+	{"@HD\tVN:1.6\r\nr1\t4\t*\t0\t0\t*\t*\t0\t0\tA\tF\n", ByteRange{0, 12}, ByteRange{12, 35}},
+	{"r1\t4\t*\t0\t0\t*\t*\t0\t0\tA\tF", ByteRange{0, 0}, ByteRange{0, 22}},
+}
+
+// TestSamDataRanges tests SamDataRanges function
+func TestSamDataRanges(t *testing.T) {
+	for _, tc := range samDataRangesTC {
+		header, body, err := SamDataRanges(strings.NewReader(tc.input), int64(len(tc.input)))
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expHeader, header)
+		assert.Equal(t, tc.expBody, body)
+	}
+}
+
+// joinDataSegments concatenates the bytes of data segments of a file
+func joinDataSegments(data []byte, dataSegments []DataSegment) []byte {
+	joined := []byte{}
+	for _, dataSegment := range dataSegments {
+		if dataSegment.Data != nil {
+			joined = append(joined, dataSegment.Data...)
+		} else {
+			joined = append(joined, data[dataSegment.Range.Start:dataSegment.Range.End]...)
+		}
+	}
+	return joined
+}
+
+// inflateTestBgzf decompresses BGZF data, failing the test if it is invalid
+func inflateTestBgzf(t *testing.T, compressed []byte) []byte {
+	data, err := ioutil.ReadAll(NewBgzfReader(bytes.NewReader(compressed)))
+	assert.Nil(t, err)
+	return data
+}
+
+// TestBamDataSegments tests BamDataSegments function, with the header in its
+// own blocks, and sharing a block with alignments
+func TestBamDataSegments(t *testing.T) {
+	var compressed bytes.Buffer
+	bgzfWriter := NewBgzfWriter(&compressed)
+	bamWriter := NewBamWriter(bgzfWriter)
+	bamWriter.WriteHeader(bamWriterHeader)
+	bgzfWriter.Flush()
+	headerEnd := int64(compressed.Len())
+	for _, tc := range bamWriterWriteTC {
+		bamWriter.Write(newTestSamRecord(tc.raw))
+	}
+	bamWriter.Close()
+	size := int64(compressed.Len())
+
+	header, body, err := BamDataSegments(bytes.NewReader(compressed.Bytes()), size)
+	assert.Nil(t, err)
+	assert.Equal(t, []DataSegment{{Range: ByteRange{0, headerEnd}}}, header)
+	assert.Equal(t, []DataSegment{{Range: ByteRange{headerEnd, size}}}, body)
+
+	// header and alignments in the first block, which is split between them
+	data, _ := ioutil.ReadFile("../data/test/input/modify-sam.bam")
+	header, body, err = BamDataSegments(bytes.NewReader(data), int64(len(data)))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(header))
+	assert.NotNil(t, header[0].Data)
+	assert.Equal(t, 2, len(body))
+	assert.NotNil(t, body[0].Data)
+	assert.Equal(t, ByteRange{1423, int64(len(data))}, body[1].Range)
+
+	uncompressed := inflateTestBgzf(t, data)
+	headerData := inflateTestBgzf(t, joinDataSegments(data, header))
+	assert.Equal(t, uncompressed[:1754], headerData)
+	bamReader, err := NewBamReader(bytes.NewReader(headerData))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(bamReader.References()))
+	assert.Equal(t, uncompressed, inflateTestBgzf(t, joinDataSegments(data, append(header, body...))))
+
+	_, _, err = BamDataSegments(strings.NewReader("@HD\tVN:1.6\n"), 11)
+	assert.NotNil(t, err)
+}
+
+// TestByteRangeHTTPRange tests ByteRange Empty and HTTPRange functions
+func TestByteRangeHTTPRange(t *testing.T) {
+	assert.Equal(t, "bytes=0-1422", ByteRange{0, 1423}.HTTPRange())
+	assert.False(t, ByteRange{0, 1}.Empty())
+	assert.True(t, ByteRange{5, 5}.Empty())
+}
+
+// TestBamChunkSegments tests that BamChunkSegments locates exactly the data of
+// chunks of the BAM test file, recompressing partial blocks and locating whole
+// blocks by byte range
+func TestBamChunkSegments(t *testing.T) {
+	data, _ := ioutil.ReadFile("../data/test/input/modify-sam.bam")
+	dataSegments, err := BamChunkSegments(bytes.NewReader(data), []IndexChunk{
+		{NewVirtualOffset(0, 1754), NewVirtualOffset(0, 2259)},
+		{NewVirtualOffset(0, 2259), NewVirtualOffset(2845, 84)},
+		{NewVirtualOffset(2845, 84), NewVirtualOffset(3285, 0)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(dataSegments))
+	for i, dataSegment := range dataSegments {
+		assert.Equal(t, i != 2, dataSegment.Data != nil, i)
+	}
+	assert.Equal(t, ByteRange{1423, 2845}, dataSegments[2].Range)
+	uncompressed := inflateTestBgzf(t, data)
+	assert.Equal(t, uncompressed[1754:], inflateTestBgzf(t, joinDataSegments(data, dataSegments)))
+
+	// whole blocks are merged
+	dataSegments, err = BamChunkSegments(bytes.NewReader(data), []IndexChunk{
+		{NewVirtualOffset(0, 0), NewVirtualOffset(1423, 0)},
+		{NewVirtualOffset(1423, 0), NewVirtualOffset(2845, 0)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []DataSegment{{Range: ByteRange{0, 2845}}}, dataSegments)
+
+	_, err = BamChunkSegments(bytes.NewReader(data), []IndexChunk{{NewVirtualOffset(0, 0), NewVirtualOffset(100, 5)}})
+	assert.NotNil(t, err)
+	_, err = BamChunkSegments(bytes.NewReader(data), []IndexChunk{{NewVirtualOffset(0, 1754), NewVirtualOffset(0, 65000)}})
+	assert.Equal(t, "Virtual offset out of range of BGZF block: 0:65000", err.Error())
+}
@@ -0,0 +1,29 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module doc describes the public API and its compatibility guarantees. The
+// package reads, transforms, and writes SAM and BAM alignment streams. It
+// is the library underlying the htsget-refserver-utils subcommands, which are
+// thin wrappers parsing command line flags:
+//
+//   - DetectFormat wraps an input stream, reporting whether it is SAM or BAM
+//   - SamReader reads SAM headers and alignments, with context cancellation,
+//     and BamReader decodes BAM headers and records
+//   - SamRecord holds a single alignment, with accessors and setters for each
+//     field and typed tags
+//   - SamWriter writes headers and records, including modified ones, as SAM
+//   - SamRecordEmitter emits records with selected fields and tags
+//   - SamRecordFilter implementations select records by region, FLAG, MAPQ,
+//     tags, or filter expressions
+//   - BamWriter encodes records as BAM, BgzfWriter compresses any stream as
+//     BGZF
+//   - ReadAlignmentIndex reads BAI or CSI indexes, whose queries locate the
+//     alignments of a region as virtual offset chunks, BuildCsi and WriteCsi
+//     create CSI indexes, and ByteRange locates data in a file
+//
+// Versioning: releases are tagged following semantic versioning. Within a major
+// version, exported identifiers of this package are not removed, their
+// signatures are not changed, and their documented behavior is preserved;
+// additions may appear in minor versions. Unexported identifiers, the packages
+// under internal/, and the exact text of error messages are not covered.
+package htsformats
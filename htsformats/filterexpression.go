@@ -0,0 +1,613 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module filterexpression parses boolean filter expressions over alignment
+// fields, flags and tags, eg. 'mapq >= 30 && !flag.dup && tag.NM <= 3', which
+// are evaluated per SamRecord as a SamRecordFilter
+package htsformats
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// filterIntegerFields canonical names of fields holding integer values. All
+// other fields hold strings
+var filterIntegerFields = map[string]bool{
+	"FLAG":  true,
+	"POS":   true,
+	"MAPQ":  true,
+	"PNEXT": true,
+	"TLEN":  true,
+}
+
+// filterType static type of a filter expression node. The type of tag values
+// is only known when evaluated against a record
+type filterType int
+
+// filter expression node types
+const (
+	filterTypeBool filterType = iota
+	filterTypeNumber
+	filterTypeString
+	filterTypeTag
+)
+
+// filterTypeNames name of each filter expression type, for error messages
+var filterTypeNames = map[filterType]string{
+	filterTypeBool:   "bool",
+	filterTypeNumber: "number",
+	filterTypeString: "string",
+	filterTypeTag:    "tag",
+}
+
+// filterNode a node of a parsed filter expression. eval returns a bool,
+// float64 or string, or nil for a missing tag
+type filterNode interface {
+	eval(samRecord *SamRecord) (interface{}, error)
+	nodeType() filterType
+}
+
+// FilterExpression a parsed filter expression, implementing SamRecordFilter
+type FilterExpression struct {
+	text string
+	root filterNode
+}
+
+// ParseFilterExpression parses a filter expression. Operands are field names
+// from samFields (case-insensitive, eg. 'mapq', 'RNAME'), flag bits (eg.
+// 'flag.dup'), tag values (eg. 'tag.NM'), numbers, "quoted strings", true and
+// false. Operators are ==, !=, <, <=, >, >=, !, &&, || and parentheses. A
+// comparison involving a missing tag is false, and a tag on its own tests
+// whether it is present
+func ParseFilterExpression(text string) (*FilterExpression, error) {
+	tokens, err := tokenizeFilterExpression(text)
+	if err != nil {
+		return nil, errors.New("Invalid filter expression '" + text + "': " + err.Error())
+	}
+	parser := &filterParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err == nil && parser.peek().kind != filterTokenEnd {
+		err = parser.errorAt(parser.peek(), "unexpected '"+parser.peek().text+"'")
+	}
+	if err == nil && root.nodeType() != filterTypeBool && root.nodeType() != filterTypeTag {
+		err = errors.New("expression is a " + filterTypeNames[root.nodeType()] + ", not a bool")
+	}
+	if err != nil {
+		return nil, errors.New("Invalid filter expression '" + text + "': " + err.Error())
+	}
+	filterExpression := new(FilterExpression)
+	filterExpression.text = text
+	filterExpression.root = asBoolNode(root)
+	return filterExpression, nil
+}
+
+// Matches indicates whether the expression is true for the alignment
+func (filterExpression *FilterExpression) Matches(samRecord *SamRecord) (bool, error) {
+	value, err := filterExpression.root.eval(samRecord)
+	if err != nil {
+		return false, errors.New("Filter expression '" + filterExpression.text + "' failed for record " + samRecord.getField(0) + ": " + err.Error())
+	}
+	return value.(bool), nil
+}
+
+// String gets the expression text
+func (filterExpression *FilterExpression) String() string {
+	return filterExpression.text
+}
+
+// filter expression token kinds
+const (
+	filterTokenEnd = iota
+	filterTokenIdent
+	filterTokenNumber
+	filterTokenString
+	filterTokenOperator
+)
+
+// filterToken a single token of a filter expression, with its 1-based
+// position for error messages
+type filterToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// filterOperators operators in order of matching, longest first
+var filterOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "=", "!", "(", ")"}
+
+// tokenizeFilterExpression splits a filter expression into tokens
+func tokenizeFilterExpression(text string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	i := 0
+	for i < len(text) {
+		c := text[i]
+		pos := i + 1
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '\'':
+			var value strings.Builder
+			j := i + 1
+			for ; j < len(text) && text[j] != c; j++ {
+				if text[j] == '\\' && j+1 < len(text) {
+					j++
+				}
+				value.WriteByte(text[j])
+			}
+			if j >= len(text) {
+				return nil, errors.New("unterminated string at position " + strconv.Itoa(pos))
+			}
+			tokens = append(tokens, filterToken{filterTokenString, value.String(), pos})
+			i = j + 1
+		case isFilterDigit(c) || c == '.' || (c == '-' && i+1 < len(text) && (isFilterDigit(text[i+1]) || text[i+1] == '.') && expectsFilterOperand(tokens)):
+			j := i + 1
+			for j < len(text) && (isFilterDigit(text[j]) || text[j] == '.' || text[j] == 'e' || text[j] == 'E' ||
+				((text[j] == '-' || text[j] == '+') && (text[j-1] == 'e' || text[j-1] == 'E'))) {
+				j++
+			}
+			if _, err := strconv.ParseFloat(text[i:j], 64); err != nil {
+				return nil, errors.New("invalid number '" + text[i:j] + "' at position " + strconv.Itoa(pos))
+			}
+			tokens = append(tokens, filterToken{filterTokenNumber, text[i:j], pos})
+			i = j
+		case isFilterIdentStart(c):
+			j := i + 1
+			for j < len(text) && (isFilterIdentStart(text[j]) || isFilterDigit(text[j]) || text[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, filterToken{filterTokenIdent, text[i:j], pos})
+			i = j
+		default:
+			matched := false
+			for _, operator := range filterOperators {
+				if strings.HasPrefix(text[i:], operator) {
+					tokens = append(tokens, filterToken{filterTokenOperator, operator, pos})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.New("unexpected character '" + string(c) + "' at position " + strconv.Itoa(pos))
+			}
+		}
+	}
+	return append(tokens, filterToken{filterTokenEnd, "end of expression", len(text) + 1}), nil
+}
+
+// isFilterDigit indicates whether a character is a decimal digit
+func isFilterDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isFilterIdentStart indicates whether a character may start an identifier
+func isFilterIdentStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+// expectsFilterOperand indicates whether the next token must be an operand,
+// such that a '-' starts a negative number rather than being an error
+func expectsFilterOperand(tokens []filterToken) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == filterTokenOperator && last.text != ")"
+}
+
+// filterParser recursive descent parser over filter expression tokens
+type filterParser struct {
+	tokens []filterToken
+	next   int
+}
+
+// peek gets the next token without consuming it
+func (parser *filterParser) peek() filterToken {
+	return parser.tokens[parser.next]
+}
+
+// consume gets and consumes the next token
+func (parser *filterParser) consume() filterToken {
+	token := parser.tokens[parser.next]
+	if token.kind != filterTokenEnd {
+		parser.next++
+	}
+	return token
+}
+
+// errorAt constructs an error message referencing a token's position
+func (parser *filterParser) errorAt(token filterToken, message string) error {
+	return errors.New(message + " at position " + strconv.Itoa(token.pos))
+}
+
+// parseOr parses: and ('||' and)*
+func (parser *filterParser) parseOr() (filterNode, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for parser.peek().text == "||" && parser.peek().kind == filterTokenOperator {
+		token := parser.consume()
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = parser.logicalNode(token, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary ('&&' unary)*
+func (parser *filterParser) parseAnd() (filterNode, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for parser.peek().text == "&&" && parser.peek().kind == filterTokenOperator {
+		token := parser.consume()
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = parser.logicalNode(token, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// logicalNode constructs an && or || node, checking both sides are bools
+func (parser *filterParser) logicalNode(token filterToken, left filterNode, right filterNode) (filterNode, error) {
+	for _, operand := range []filterNode{left, right} {
+		if operand.nodeType() != filterTypeBool && operand.nodeType() != filterTypeTag {
+			return nil, parser.errorAt(token, "'"+token.text+"' requires bool operands, not "+filterTypeNames[operand.nodeType()])
+		}
+	}
+	return &filterLogicalNode{token.text, asBoolNode(left), asBoolNode(right)}, nil
+}
+
+// parseUnary parses: '!' unary | comparison
+func (parser *filterParser) parseUnary() (filterNode, error) {
+	if parser.peek().text == "!" && parser.peek().kind == filterTokenOperator {
+		token := parser.consume()
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.nodeType() != filterTypeBool && operand.nodeType() != filterTypeTag {
+			return nil, parser.errorAt(token, "'!' requires a bool operand, not "+filterTypeNames[operand.nodeType()])
+		}
+		return &filterNotNode{asBoolNode(operand)}, nil
+	}
+	return parser.parseComparison()
+}
+
+// parseComparison parses: operand (op operand)?
+func (parser *filterParser) parseComparison() (filterNode, error) {
+	left, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	token := parser.peek()
+	if token.kind != filterTokenOperator {
+		return left, nil
+	}
+	operator := token.text
+	switch operator {
+	case "=":
+		operator = "=="
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	parser.consume()
+	right, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	leftType, rightType := left.nodeType(), right.nodeType()
+	if leftType != filterTypeTag && rightType != filterTypeTag {
+		if leftType != rightType {
+			return nil, parser.errorAt(token, "cannot compare "+filterTypeNames[leftType]+" with "+filterTypeNames[rightType])
+		}
+		if leftType == filterTypeBool && operator != "==" && operator != "!=" {
+			return nil, parser.errorAt(token, "'"+operator+"' cannot compare bools")
+		}
+	}
+	return &filterComparisonNode{operator, left, right}, nil
+}
+
+// parseOperand parses a literal, field, flag, tag or parenthesized expression
+func (parser *filterParser) parseOperand() (filterNode, error) {
+	token := parser.consume()
+	switch token.kind {
+	case filterTokenNumber:
+		number, _ := strconv.ParseFloat(token.text, 64)
+		return &filterLiteralNode{number, filterTypeNumber}, nil
+	case filterTokenString:
+		return &filterLiteralNode{token.text, filterTypeString}, nil
+	case filterTokenIdent:
+		return parser.identNode(token)
+	case filterTokenOperator:
+		if token.text == "(" {
+			node, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if closing := parser.consume(); closing.text != ")" || closing.kind != filterTokenOperator {
+				return nil, parser.errorAt(closing, "expected ')', found '"+closing.text+"'")
+			}
+			return node, nil
+		}
+	}
+	return nil, parser.errorAt(token, "expected a value, found '"+token.text+"'")
+}
+
+// identNode constructs the node for an identifier: true/false, a field name,
+// 'flag.<name>' or 'tag.<XX>'
+func (parser *filterParser) identNode(token filterToken) (filterNode, error) {
+	name := token.text
+	lower := strings.ToLower(name)
+	switch {
+	case lower == "true" || lower == "false":
+		return &filterLiteralNode{lower == "true", filterTypeBool}, nil
+	case strings.HasPrefix(lower, "flag."):
+		for _, samFlagName := range samFlagNames {
+			if strings.EqualFold(name[5:], samFlagName.name) {
+				return &filterFlagNode{samFlagName.flag}, nil
+			}
+		}
+		return nil, parser.errorAt(token, "unknown flag '"+name[5:]+"'")
+	case strings.HasPrefix(lower, "tag."):
+		key := name[4:]
+		if !samTagKeyPattern.MatchString(key) {
+			return nil, parser.errorAt(token, "invalid tag '"+key+"'")
+		}
+		return &filterTagNode{key}, nil
+	}
+	field := strings.ToUpper(name)
+	if col, ok := samFields[field]; ok {
+		if filterIntegerFields[field] {
+			return &filterFieldNode{field, col, filterTypeNumber}, nil
+		}
+		return &filterFieldNode{field, col, filterTypeString}, nil
+	}
+	return nil, parser.errorAt(token, "unknown field '"+name+"'")
+}
+
+// asBoolNode wraps a tag node used as a bool, testing whether the tag exists
+func asBoolNode(node filterNode) filterNode {
+	if tagNode, ok := node.(*filterTagNode); ok {
+		return &filterTagExistsNode{tagNode.key}
+	}
+	return node
+}
+
+// filterLiteralNode a constant bool, number or string
+type filterLiteralNode struct {
+	value     interface{}
+	valueType filterType
+}
+
+func (node *filterLiteralNode) eval(samRecord *SamRecord) (interface{}, error) {
+	return node.value, nil
+}
+
+func (node *filterLiteralNode) nodeType() filterType {
+	return node.valueType
+}
+
+// filterFieldNode the value of an alignment field
+type filterFieldNode struct {
+	name      string
+	col       int
+	valueType filterType
+}
+
+func (node *filterFieldNode) eval(samRecord *SamRecord) (interface{}, error) {
+	value := samRecord.getField(node.col)
+	if node.valueType == filterTypeString {
+		return value, nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, errors.New("Invalid " + node.name + ": '" + value + "'")
+	}
+	return number, nil
+}
+
+func (node *filterFieldNode) nodeType() filterType {
+	return node.valueType
+}
+
+// filterFlagNode whether a FLAG bit is set
+type filterFlagNode struct {
+	flag SamFlag
+}
+
+func (node *filterFlagNode) eval(samRecord *SamRecord) (interface{}, error) {
+	flag, err := samRecord.Flag()
+	if err != nil {
+		return nil, err
+	}
+	return flag.Has(node.flag), nil
+}
+
+func (node *filterFlagNode) nodeType() filterType {
+	return filterTypeBool
+}
+
+// filterTagNode the value of a tag, as a number (types i, f) or string (types
+// A, Z, H), or nil if the tag is missing
+type filterTagNode struct {
+	key string
+}
+
+func (node *filterTagNode) eval(samRecord *SamRecord) (interface{}, error) {
+	if !samRecord.HasTag(node.key) {
+		return nil, nil
+	}
+	samTag, err := samRecord.Tag(node.key)
+	if err != nil {
+		return nil, err
+	}
+	switch value := samTag.Value.(type) {
+	case int64:
+		return float64(value), nil
+	case float32:
+		return float64(value), nil
+	case byte:
+		return string(value), nil
+	case string:
+		return value, nil
+	case []byte:
+		return strings.ToUpper(hex.EncodeToString(value)), nil
+	}
+	return nil, errors.New("cannot compare array tag '" + node.key + "'")
+}
+
+func (node *filterTagNode) nodeType() filterType {
+	return filterTypeTag
+}
+
+// filterTagExistsNode whether a tag is present
+type filterTagExistsNode struct {
+	key string
+}
+
+func (node *filterTagExistsNode) eval(samRecord *SamRecord) (interface{}, error) {
+	return samRecord.HasTag(node.key), nil
+}
+
+func (node *filterTagExistsNode) nodeType() filterType {
+	return filterTypeBool
+}
+
+// filterNotNode negates a bool
+type filterNotNode struct {
+	operand filterNode
+}
+
+func (node *filterNotNode) eval(samRecord *SamRecord) (interface{}, error) {
+	value, err := node.operand.eval(samRecord)
+	if err != nil {
+		return nil, err
+	}
+	return !value.(bool), nil
+}
+
+func (node *filterNotNode) nodeType() filterType {
+	return filterTypeBool
+}
+
+// filterLogicalNode && or || of two bools, short-circuiting
+type filterLogicalNode struct {
+	operator    string
+	left, right filterNode
+}
+
+func (node *filterLogicalNode) eval(samRecord *SamRecord) (interface{}, error) {
+	left, err := node.left.eval(samRecord)
+	if err != nil {
+		return nil, err
+	}
+	if left.(bool) == (node.operator == "||") {
+		return left, nil
+	}
+	return node.right.eval(samRecord)
+}
+
+func (node *filterLogicalNode) nodeType() filterType {
+	return filterTypeBool
+}
+
+// filterComparisonNode compares two values of the same type. Comparisons
+// involving a missing tag are false
+type filterComparisonNode struct {
+	operator    string
+	left, right filterNode
+}
+
+func (node *filterComparisonNode) eval(samRecord *SamRecord) (interface{}, error) {
+	left, err := node.left.eval(samRecord)
+	if err != nil {
+		return nil, err
+	}
+	right, err := node.right.eval(samRecord)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil || right == nil {
+		return false, nil
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, filterMismatchError(left, right)
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, filterMismatchError(left, right)
+		}
+		cmp = strings.Compare(l, r)
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return nil, filterMismatchError(left, right)
+		}
+		if l != r {
+			cmp = 1
+		}
+	}
+
+	switch node.operator {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func (node *filterComparisonNode) nodeType() filterType {
+	return filterTypeBool
+}
+
+// filterMismatchError constructs the error for comparing values of different
+// types, found when evaluating tags
+func filterMismatchError(left interface{}, right interface{}) error {
+	return errors.New("cannot compare " + filterValueTypeName(left) + " with " + filterValueTypeName(right))
+}
+
+// filterValueTypeName gets the type name of an evaluated value
+func filterValueTypeName(value interface{}) string {
+	switch value.(type) {
+	case float64:
+		return filterTypeNames[filterTypeNumber]
+	case string:
+		return filterTypeNames[filterTypeString]
+	}
+	return filterTypeNames[filterTypeBool]
+}
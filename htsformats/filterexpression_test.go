@@ -0,0 +1,102 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module filterexpression_test tests filterexpression
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// filterExpressionRecordRaw record evaluated by filter expression tests
+var filterExpressionRecordRaw = "r1\t1123\tchr1\t100\t30\t10S90M\t=\t300\t-290\t*\t*\tNM:i:2\tXF:f:0.5\tXA:A:+\tRG:Z:grp1\tXH:H:1a\tXB:B:c,1"
+
+// parseFilterExpressionTC test cases for ParseFilterExpression and Matches
+var parseFilterExpressionTC = []struct {
+	text       string
+	expError   string
+	expMatches bool
+}{
+	// fields
+	{"mapq >= 30", "", true},
+	{"MAPQ > 30", "", false},
+	{"rname == \"chr1\"", "", true},
+	{"rname == 'chr2'", "", false},
+	{"qname < \"r2\"", "", true},
+	{"tlen == -290", "", true},
+	{"tlen<-289", "", true},
+	{"cigar = \"10S90M\"", "", true},
+	{"flag == 1123", "", true},
+	// flags
+	{"flag.dup", "", true},
+	{"flag.PAIRED && flag.read1", "", true},
+	{"!flag.secondary", "", true},
+	{"flag.dup == false", "", false},
+	// tags
+	{"tag.NM <= 2", "", true},
+	{"tag.NM < 2.5 && tag.XF == 0.5", "", true},
+	{"tag.RG == \"grp1\" && tag.XA == \"+\" && tag.XH == \"1A\"", "", true},
+	{"tag.NH", "", false},
+	{"tag.RG", "", true},
+	{"!tag.NH && !(tag.NH == 1)", "", true},
+	{"tag.NH >= 0 || tag.NH < 0", "", false},
+	// precedence and grouping
+	{"false && true || true", "", true},
+	{"false && (true || true)", "", false},
+	{"!(mapq >= 30) || (rname == \"chr1\" && pos > 50)", "", true},
+	{"true", "", true},
+	// errors
+	{"mapqq >= 30", "unknown field 'mapqq' at position 1", false},
+	{"flag.duplicate", "unknown flag 'duplicate' at position 1", false},
+	{"tag.NMM > 1", "invalid tag 'NMM' at position 1", false},
+	{"rname >= 30", "cannot compare string with number at position 7", false},
+	{"mapq", "expression is a number, not a bool", false},
+	{"mapq && flag.dup", "'&&' requires bool operands, not number at position 6", false},
+	{"!rname", "'!' requires a bool operand, not string at position 1", false},
+	{"flag.dup < true", "'<' cannot compare bools at position 10", false},
+	{"(mapq >= 30", "expected ')', found 'end of expression' at position 12", false},
+	{"mapq >= ", "expected a value, found 'end of expression' at position 9", false},
+	{"mapq >= 30 30", "unexpected '30' at position 12", false},
+	{"rname == \"chr1", "unterminated string at position 10", false},
+	{"mapq >= 3.0.1", "invalid number '3.0.1' at position 9", false},
+	{"pos - 1 > 0", "unexpected character '-' at position 5", false},
+	{"mapq # 3", "unexpected character '#' at position 6", false},
+}
+
+// TestParseFilterExpression tests functions ParseFilterExpression and Matches
+func TestParseFilterExpression(t *testing.T) {
+	samRecord := newTestSamRecord(filterExpressionRecordRaw)
+	for _, tc := range parseFilterExpressionTC {
+		filterExpression, err := ParseFilterExpression(tc.text)
+		if tc.expError != "" {
+			assert.NotNil(t, err, tc.text)
+			if err != nil {
+				assert.Equal(t, "Invalid filter expression '"+tc.text+"': "+tc.expError, err.Error())
+			}
+			continue
+		}
+		assert.Nil(t, err, tc.text)
+		matches, err := filterExpression.Matches(samRecord)
+		assert.Nil(t, err, tc.text)
+		assert.Equal(t, tc.expMatches, matches, tc.text)
+		assert.Equal(t, tc.text, filterExpression.String())
+	}
+}
+
+// TestFilterExpressionMatchesError tests function Matches for expressions that
+// cannot be evaluated against a record
+func TestFilterExpressionMatchesError(t *testing.T) {
+	samRecord := newTestSamRecord(filterExpressionRecordRaw)
+	for _, text := range []string{"tag.RG > 3", "tag.NM == \"2\"", "tag.XB == 1", "tag.NM == true"} {
+		filterExpression, err := ParseFilterExpression(text)
+		assert.Nil(t, err, text)
+		_, err = filterExpression.Matches(samRecord)
+		assert.NotNil(t, err, text)
+	}
+
+	filterExpression, _ := ParseFilterExpression("pos > 1")
+	_, err := filterExpression.Matches(newTestSamRecord("r\t0\tchr1\tx\t60\t1M\t*\t0\t0\tA\tF"))
+	assert.Equal(t, "Filter expression 'pos > 1' failed for record r: Invalid POS: 'x'", err.Error())
+}
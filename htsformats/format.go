@@ -0,0 +1,68 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module format detects whether an alignment stream is SAM or BAM
+package htsformats
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// FormatSam plain text SAM format
+const FormatSam = "SAM"
+
+// FormatBam binary, BGZF-compressed BAM format
+const FormatBam = "BAM"
+
+// gzipMagic magic bytes at the start of any gzip (and therefore BGZF) stream
+var gzipMagic = []byte{31, 139}
+
+// DetectFormat inspects the magic bytes of an alignment stream, returning a
+// reader over the decompressed stream along with the detected format. BGZF
+// streams are decompressed, and are considered BAM if the decompressed data
+// starts with the BAM magic bytes, otherwise (bgzipped) SAM. All other streams
+// are considered plain text SAM
+func DetectFormat(reader io.Reader) (io.Reader, string, error) {
+	return DetectFormatThreads(reader, 1)
+}
+
+// DetectFormatThreads detects the format of an alignment stream as
+// DetectFormat, decompressing BGZF streams on the given number of goroutines.
+// If the returned reader implements io.Closer, it should be closed once no
+// longer needed, to stop decompressing ahead
+func DetectFormatThreads(reader io.Reader, threads int) (io.Reader, string, error) {
+	bufReader := bufio.NewReader(reader)
+	magic, err := bufReader.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return bufReader, FormatSam, nil
+	}
+
+	bgzfReader := NewBgzfReaderThreads(bufReader, threads)
+	decompressed := &bgzfStream{bufio.NewReader(bgzfReader), bgzfReader}
+	magic, err = decompressed.Peek(len(bamMagic))
+	if err != nil && err != io.EOF {
+		bgzfReader.Close()
+		return nil, "", err
+	}
+	if bytes.Equal(magic, bamMagic) {
+		return decompressed, FormatBam, nil
+	}
+	return decompressed, FormatSam, nil
+}
+
+// bgzfStream a buffered reader over decompressed BGZF, which closes the
+// BgzfReader
+type bgzfStream struct {
+	*bufio.Reader
+	bgzfReader *BgzfReader
+}
+
+// Close closes the BgzfReader, stopping decompressing ahead
+func (stream *bgzfStream) Close() error {
+	return stream.bgzfReader.Close()
+}
@@ -0,0 +1,45 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module format_test tests format
+package htsformats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDetectFormat tests DetectFormat on SAM, BAM, and empty input
+func TestDetectFormat(t *testing.T) {
+	samFile, _ := os.Open("../data/test/input/modify-sam.sam")
+	_, format, err := DetectFormat(samFile)
+	assert.Nil(t, err)
+	assert.Equal(t, FormatSam, format)
+
+	bamFile, _ := os.Open("../data/test/input/modify-sam.bam")
+	reader, format, err := DetectFormat(bamFile)
+	assert.Nil(t, err)
+	assert.Equal(t, FormatBam, format)
+	magic := make([]byte, 4)
+	reader.Read(magic)
+	assert.Equal(t, bamMagic, magic)
+
+	_, format, err = DetectFormat(strings.NewReader(""))
+	assert.Nil(t, err)
+	assert.Equal(t, FormatSam, format)
+}
+
+// TestDetectFormatBgzippedSam tests that BGZF-compressed text is detected as
+// SAM and decompressed
+func TestDetectFormatBgzippedSam(t *testing.T) {
+	reader, format, err := DetectFormat(bytes.NewReader(bgzfEOF))
+	assert.Nil(t, err)
+	assert.Equal(t, FormatSam, format)
+	data, _ := ioutil.ReadAll(reader)
+	assert.Equal(t, 0, len(data))
+}
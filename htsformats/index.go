@@ -0,0 +1,279 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module index contains the objects shared by BAM indices (BAI and CSI), and
+// reads either format behind a common query interface
+package htsformats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// VirtualOffset a BGZF virtual file offset, the compressed offset of a block in
+// the upper 48 bits and an offset into its uncompressed data in the lower 16
+type VirtualOffset uint64
+
+// NewVirtualOffset constructs a VirtualOffset from a block's compressed offset
+// and an offset into its uncompressed data
+func NewVirtualOffset(compressed int64, uncompressed int) VirtualOffset {
+	return VirtualOffset(uint64(compressed)<<16 | uint64(uncompressed))
+}
+
+// Compressed gets the offset of the BGZF block in the file
+func (virtualOffset VirtualOffset) Compressed() int64 {
+	return int64(virtualOffset >> 16)
+}
+
+// Uncompressed gets the offset into the uncompressed data of the block
+func (virtualOffset VirtualOffset) Uncompressed() int {
+	return int(virtualOffset & 0xffff)
+}
+
+// String gets the offset as compressed:uncompressed
+func (virtualOffset VirtualOffset) String() string {
+	return strconv.FormatInt(virtualOffset.Compressed(), 10) + ":" + strconv.Itoa(virtualOffset.Uncompressed())
+}
+
+// IndexChunk a contiguous range of alignments in a BGZF-compressed file, from
+// Begin (inclusive) to End (exclusive)
+type IndexChunk struct {
+	Begin VirtualOffset
+	End   VirtualOffset
+}
+
+// AlignmentIndex an index of a coordinate-sorted BAM file, either BAI or CSI,
+// locating the alignments of a genomic interval
+type AlignmentIndex interface {
+
+	// Query gets the sorted, merged chunks holding alignments that may overlap
+	// an interval of a reference, given by its id in the reference dictionary
+	// and 0-based, half-open positions, either of which may be RegionUnbounded
+	Query(refID int, start int, end int) ([]IndexChunk, error)
+
+	// UnplacedOffset gets the offset following the alignments of every
+	// reference, where unplaced unmapped alignments begin. The offset is
+	// unknown, reported as false, if the index locates no alignments
+	UnplacedOffset() (VirtualOffset, bool)
+}
+
+// ReadAlignmentIndex parses a BAI or CSI file, detecting the format from its
+// magic bytes. The index may be BGZF-compressed, as CSI files usually are
+func ReadAlignmentIndex(reader io.Reader) (AlignmentIndex, error) {
+	bufReader := decompressIndex(reader)
+	magic, _ := bufReader.Peek(4)
+	switch string(magic) {
+	case string(baiMagic):
+		return ReadBai(bufReader)
+	case string(csiMagic):
+		return ReadCsi(bufReader)
+	}
+	return nil, errors.New("Unrecognized index format, expected BAI or CSI")
+}
+
+// decompressIndex buffers an index stream, decompressing it if it starts with
+// a gzip header
+func decompressIndex(reader io.Reader) *bufio.Reader {
+	bufReader := bufio.NewReader(reader)
+	if magic, _ := bufReader.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return bufio.NewReader(NewBgzfReader(bufReader))
+	}
+	return bufReader
+}
+
+// indexReader reads little-endian values from a BAI or CSI stream, retaining
+// the first error
+type indexReader struct {
+	reader io.Reader
+	format string
+	buf    []byte
+	err    error
+}
+
+// newIndexReader constructs an indexReader, naming the index format in errors
+func newIndexReader(reader io.Reader, format string) *indexReader {
+	indexReader := new(indexReader)
+	indexReader.reader = reader
+	indexReader.format = format
+	indexReader.buf = make([]byte, 8)
+	return indexReader
+}
+
+// read reads n bytes, returning nil once an error has been encountered
+func (indexReader *indexReader) read(n int) []byte {
+	if indexReader.err != nil {
+		return nil
+	}
+	if n > len(indexReader.buf) {
+		indexReader.buf = make([]byte, n)
+	}
+	if _, err := io.ReadFull(indexReader.reader, indexReader.buf[:n]); err != nil {
+		indexReader.err = errors.New("Truncated " + indexReader.format + " index")
+		return nil
+	}
+	return indexReader.buf[:n]
+}
+
+// count reads a non-negative count
+func (indexReader *indexReader) count() int {
+	data := indexReader.read(4)
+	if data == nil {
+		return 0
+	}
+	n := int32(binary.LittleEndian.Uint32(data))
+	if n < 0 {
+		indexReader.err = errors.New("Invalid " + indexReader.format + " count: " + strconv.Itoa(int(n)))
+		return 0
+	}
+	return int(n)
+}
+
+// uint32 reads an unsigned 32-bit value
+func (indexReader *indexReader) uint32() uint32 {
+	data := indexReader.read(4)
+	if data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(data)
+}
+
+// uint64 reads an unsigned 64-bit value
+func (indexReader *indexReader) uint64() uint64 {
+	data := indexReader.read(8)
+	if data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(data)
+}
+
+// chunks reads a count-prefixed list of chunks
+func (indexReader *indexReader) chunks() []IndexChunk {
+	nChunk := indexReader.count()
+	chunks := []IndexChunk{}
+	for k := 0; k < nChunk && indexReader.err == nil; k++ {
+		begin := VirtualOffset(indexReader.uint64())
+		end := VirtualOffset(indexReader.uint64())
+		chunks = append(chunks, IndexChunk{begin, end})
+	}
+	return chunks
+}
+
+// noCoordinate reads the optional trailing count of unplaced unmapped
+// alignments
+func (indexReader *indexReader) noCoordinate() (bool, uint64) {
+	data := make([]byte, 8)
+	if _, err := io.ReadFull(indexReader.reader, data); err != nil {
+		return false, 0
+	}
+	return true, binary.LittleEndian.Uint64(data)
+}
+
+// indexInterval replaces unbounded interval positions with the limits of the
+// coordinate space indexed, up to maxPosition
+func indexInterval(start int, end int, maxPosition int) (int, int) {
+	if start == RegionUnbounded || start < 0 {
+		start = 0
+	}
+	if end == RegionUnbounded || end > maxPosition {
+		end = maxPosition
+	}
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
+
+// binFirst gets the first bin of a level of the binning scheme, level 0 being
+// the single bin spanning the whole coordinate space
+func binFirst(level int) int {
+	return ((1 << uint(3*level)) - 1) / 7
+}
+
+// binParent gets the bin of the level above, containing a bin
+func binParent(bin int) int {
+	return (bin - 1) >> 3
+}
+
+// binLevel gets the level of a bin
+func binLevel(bin int) int {
+	level := 0
+	for bin >= binFirst(level+1) {
+		level++
+	}
+	return level
+}
+
+// binWindow gets the index of the smallest (2^minShift) window at the start of
+// a bin, given the depth of the binning scheme
+func binWindow(bin int, depth int) int {
+	level := binLevel(bin)
+	return (bin - binFirst(level)) << uint(3*(depth-level))
+}
+
+// indexReg2bin gets the smallest bin containing a 0-based, half-open
+// interval, as specified in the CSI specification
+func indexReg2bin(beg int, end int, minShift int, depth int) int {
+	end--
+	shift := uint(minShift)
+	for level := depth; level > 0; level-- {
+		if beg>>shift == end>>shift {
+			return binFirst(level) + beg>>shift
+		}
+		shift += 3
+	}
+	return 0
+}
+
+// indexReg2bins gets the bins that may hold alignments overlapping a 0-based,
+// half-open interval, as specified in the CSI specification
+func indexReg2bins(beg int, end int, minShift int, depth int) []int {
+	end--
+	bins := []int{}
+	shift := uint(minShift + 3*depth)
+	for level := 0; level <= depth; level++ {
+		first := binFirst(level)
+		for k := first + beg>>shift; k <= first+end>>shift; k++ {
+			bins = append(bins, k)
+		}
+		shift -= 3
+	}
+	return bins
+}
+
+// maxChunkEnd gets the end of the last chunk of any bin, false if there are
+// no chunks
+func maxChunkEnd(bins map[uint32][]IndexChunk) (VirtualOffset, bool) {
+	offset, ok := VirtualOffset(0), false
+	for _, chunks := range bins {
+		for _, chunk := range chunks {
+			if chunk.End >= offset {
+				offset, ok = chunk.End, true
+			}
+		}
+	}
+	return offset, ok
+}
+
+// mergeIndexChunks sorts chunks, merging those that overlap or end and begin
+// within the same BGZF block
+func mergeIndexChunks(chunks []IndexChunk) []IndexChunk {
+	sort.Slice(chunks, func(i int, j int) bool {
+		return chunks[i].Begin < chunks[j].Begin
+	})
+	merged := []IndexChunk{}
+	for _, chunk := range chunks {
+		last := len(merged) - 1
+		if last >= 0 && (chunk.Begin <= merged[last].End || chunk.Begin.Compressed() == merged[last].End.Compressed()) {
+			if chunk.End > merged[last].End {
+				merged[last].End = chunk.End
+			}
+			continue
+		}
+		merged = append(merged, chunk)
+	}
+	return merged
+}
@@ -0,0 +1,64 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module linereader reads newline-delimited text, such as SAM and BED, without
+// a limit on line length
+package htsformats
+
+import (
+	"bufio"
+	"io"
+)
+
+// LineReader reads lines of any length from a stream. Unlike bufio.Scanner,
+// which fails on lines over 64 KiB, lines are only limited by memory, as
+// required for long-read (eg. ONT, PacBio) alignments
+type LineReader struct {
+	reader *bufio.Reader
+	line   []byte
+	offset int64
+}
+
+// NewLineReader constructs a LineReader over a stream
+func NewLineReader(reader io.Reader) *LineReader {
+	lineReader := new(LineReader)
+	if bufReader, ok := reader.(*bufio.Reader); ok {
+		lineReader.reader = bufReader
+	} else {
+		lineReader.reader = bufio.NewReader(reader)
+	}
+	return lineReader
+}
+
+// Next gets the next line, without its trailing "\n" or "\r\n". The final
+// line need not be newline-terminated. Returns io.EOF once all lines have been
+// read, or any other error from the underlying stream
+func (lineReader *LineReader) Next() (string, error) {
+	lineReader.line = lineReader.line[:0]
+	for {
+		// ReadSlice returns at most a buffer's worth at a time, which is
+		// accumulated until the newline
+		chunk, err := lineReader.reader.ReadSlice('\n')
+		lineReader.line = append(lineReader.line, chunk...)
+		lineReader.offset += int64(len(chunk))
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(lineReader.line) > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	line := lineReader.line
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), nil
+}
@@ -0,0 +1,86 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module linereader_test tests linereader
+package htsformats
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingReader returns data, then an error instead of io.EOF
+type failingReader struct {
+	reader io.Reader
+}
+
+// Read reads from the wrapped reader, failing at its end
+func (failingReader *failingReader) Read(p []byte) (int, error) {
+	n, err := failingReader.reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("read failed")
+	}
+	return n, err
+}
+
+// longLine a line several times the default bufio.Reader and bufio.Scanner
+// buffer sizes
+var longLine = strings.Repeat("ACGT", 1<<20)
+
+// lineReaderTC test cases for LineReader
+var lineReaderTC = []struct {
+	input    string
+	expLines []string
+}{
+	{"", []string{}},
+	{"\n", []string{""}},
+	{"a\nb\n", []string{"a", "b"}},
+	{"a\nb", []string{"a", "b"}},
+	{"a\r\nb\r\n\r\nc\r", []string{"a", "b", "", "c"}},
+	{"a\n" + longLine + "\nb\n", []string{"a", longLine, "b"}},
+	{longLine + longLine, []string{longLine + longLine}},
+}
+
+// readAllLines reads lines until io.EOF or an error
+func readAllLines(lineReader *LineReader) ([]string, error) {
+	lines := []string{}
+	for {
+		line, err := lineReader.Next()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+}
+
+// TestLineReaderNext tests LineReader Next function, including lines longer
+// than the read buffer
+func TestLineReaderNext(t *testing.T) {
+	for _, tc := range lineReaderTC {
+		lines, err := readAllLines(NewLineReader(strings.NewReader(tc.input)))
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expLines, lines)
+
+		// small buffer, so every line spans multiple reads
+		lines, err = readAllLines(NewLineReader(bufio.NewReaderSize(strings.NewReader(tc.input), 16)))
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expLines, lines)
+	}
+}
+
+// TestLineReaderError tests that LineReader Next surfaces read errors, rather
+// than treating them as the end of input
+func TestLineReaderError(t *testing.T) {
+	lineReader := NewLineReader(&failingReader{strings.NewReader("a\n" + longLine)})
+	lines, err := readAllLines(lineReader)
+	assert.Equal(t, []string{"a"}, lines)
+	assert.Equal(t, errors.New("read failed"), err)
+}
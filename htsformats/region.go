@@ -0,0 +1,80 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module region defines genomic regions, and the overlap of alignments with
+// those regions
+package htsformats
+
+import (
+	"errors"
+	"strconv"
+)
+
+// UnplacedReferenceName reference name denoting unplaced, unmapped reads
+const UnplacedReferenceName = "*"
+
+// RegionUnbounded indicates a region start or end was not specified
+const RegionUnbounded = -1
+
+// Region a genomic interval on a single reference, using htsget semantics:
+// 0-based start (inclusive) and end (exclusive)
+type Region struct {
+	ReferenceName string
+	Start         int
+	End           int
+}
+
+// NewRegion constructs and validates a Region. Start and end may be
+// RegionUnbounded, in which case the region extends to the beginning/end of the
+// reference. The unplaced reference name may not have a start or end
+func NewRegion(referenceName string, start int, end int) (*Region, error) {
+	if referenceName == "" {
+		return nil, errors.New("Region reference name must be specified")
+	}
+	if referenceName == UnplacedReferenceName && (start != RegionUnbounded || end != RegionUnbounded) {
+		return nil, errors.New("Region start/end cannot be specified for unplaced reads")
+	}
+	if start < RegionUnbounded || end < RegionUnbounded {
+		return nil, errors.New("Region start/end must be non-negative")
+	}
+	if start != RegionUnbounded && end != RegionUnbounded && end <= start {
+		return nil, errors.New("Region end must be greater than start")
+	}
+	return &Region{referenceName, start, end}, nil
+}
+
+// Matches indicates whether an alignment overlaps the region, implementing
+// SamRecordFilter. Unplaced reads only match the unplaced reference name
+func (region *Region) Matches(samRecord *SamRecord) (bool, error) {
+	if samRecord.getField(2) != region.ReferenceName {
+		return false, nil
+	}
+	if region.ReferenceName == UnplacedReferenceName {
+		return true, nil
+	}
+
+	start, end, err := samRecord.alignmentSpan()
+	if err != nil {
+		return false, err
+	}
+	if region.Start != RegionUnbounded && end <= region.Start {
+		return false, nil
+	}
+	if region.End != RegionUnbounded && start >= region.End {
+		return false, nil
+	}
+	return true, nil
+}
+
+// String gets a string representation of the Region
+func (region *Region) String() string {
+	start := ""
+	if region.Start != RegionUnbounded {
+		start = strconv.Itoa(region.Start)
+	}
+	end := ""
+	if region.End != RegionUnbounded {
+		end = strconv.Itoa(region.End)
+	}
+	return "[Region " + region.ReferenceName + ":" + start + "-" + end + "]"
+}
@@ -0,0 +1,88 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module region_test tests region
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newRegionTC test cases for NewRegion
+var newRegionTC = []struct {
+	referenceName string
+	start, end    int
+	expError      bool
+}{
+	{"chr1", RegionUnbounded, RegionUnbounded, false},
+	{"chr1", 0, 100, false},
+	{"chr1", 100, RegionUnbounded, false},
+	{"*", RegionUnbounded, RegionUnbounded, false},
+	{"", RegionUnbounded, RegionUnbounded, true},
+	{"*", 0, RegionUnbounded, true},
+	{"chr1", 100, 100, true},
+	{"chr1", -5, 100, true},
+}
+
+// regionMatchesTC test cases for Region Matches
+var regionMatchesTC = []struct {
+	referenceName string
+	start, end    int
+	raw           string
+	expMatches    bool
+	expError      bool
+}{
+	// 100M at POS 101 covers [100, 200)
+	{"chr1", 0, 100, "r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*", false, false},
+	{"chr1", 0, 101, "r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*", true, false},
+	{"chr1", 199, 300, "r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*", true, false},
+	{"chr1", 200, 300, "r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*", false, false},
+	{"chr1", RegionUnbounded, RegionUnbounded, "r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*", true, false},
+	{"chr2", RegionUnbounded, RegionUnbounded, "r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*", false, false},
+	// soft clips and insertions don't consume reference, deletions and skips do
+	{"chr1", 110, 120, "r\t0\tchr1\t101\t60\t50S10M50I\t*\t0\t0\t*\t*", false, false},
+	{"chr1", 500, 600, "r\t0\tchr1\t101\t60\t10M500N10D10M\t*\t0\t0\t*\t*", true, false},
+	// unmapped read placed at its mate's position covers a single base
+	{"chr1", 100, 101, "r\t4\tchr1\t101\t0\t*\t=\t101\t0\t*\t*", true, false},
+	{"chr1", 101, 102, "r\t4\tchr1\t101\t0\t*\t=\t101\t0\t*\t*", false, false},
+	// unplaced unmapped reads
+	{"*", RegionUnbounded, RegionUnbounded, "r\t4\t*\t0\t0\t*\t*\t0\t0\t*\t*", true, false},
+	{"*", RegionUnbounded, RegionUnbounded, "r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*", false, false},
+	// invalid records
+	{"chr1", 0, 100, "r\t0\tchr1\tX\t60\t100M\t*\t0\t0\t*\t*", false, true},
+	{"chr1", 0, 100, "r\t0\tchr1\t101\t60\t100Q\t*\t0\t0\t*\t*", false, true},
+}
+
+// TestNewRegion tests NewRegion function
+func TestNewRegion(t *testing.T) {
+	for _, tc := range newRegionTC {
+		_, err := NewRegion(tc.referenceName, tc.start, tc.end)
+		if tc.expError {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+// TestRegionMatches tests Region Matches function
+func TestRegionMatches(t *testing.T) {
+	for _, tc := range regionMatchesTC {
+		region, _ := NewRegion(tc.referenceName, tc.start, tc.end)
+		matches, err := region.Matches(newTestSamRecord(tc.raw))
+		if tc.expError {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expMatches, matches)
+		}
+	}
+}
+
+// TestRegionString tests Region String function
+func TestRegionString(t *testing.T) {
+	region, _ := NewRegion("chr1", 100, RegionUnbounded)
+	assert.Equal(t, "[Region chr1:100-]", region.String())
+}
@@ -0,0 +1,191 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module regionset combines multiple requested regions, merging overlapping
+// intervals, and parses regions from strings and BED files
+package htsformats
+
+import (
+	"errors"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// regionIntervalPattern matches the optional interval suffix of a region
+// string, ie. 'start-end', 'start-', '-end', or 'start'
+var regionIntervalPattern = regexp.MustCompile(`^(\d*)(-(\d*))?$`)
+
+// RegionSet a union of regions, with overlapping or adjacent intervals on the
+// same reference merged
+type RegionSet struct {
+	intervals map[string][][2]int
+}
+
+// NewRegionSet constructs a RegionSet, merging the intervals of all regions per
+// reference. Unbounded starts/ends extend to the beginning/end of the reference
+func NewRegionSet(regions []*Region) *RegionSet {
+	regionSet := new(RegionSet)
+	regionSet.intervals = make(map[string][][2]int)
+
+	for _, region := range regions {
+		start, end := region.Start, region.End
+		if start == RegionUnbounded {
+			start = 0
+		}
+		if end == RegionUnbounded {
+			end = math.MaxInt32
+		}
+		name := region.ReferenceName
+		regionSet.intervals[name] = append(regionSet.intervals[name], [2]int{start, end})
+	}
+
+	for name, intervals := range regionSet.intervals {
+		sort.Slice(intervals, func(i, j int) bool {
+			return intervals[i][0] < intervals[j][0]
+		})
+		merged := [][2]int{intervals[0]}
+		for _, interval := range intervals[1:] {
+			last := &merged[len(merged)-1]
+			if interval[0] <= last[1] {
+				if interval[1] > last[1] {
+					last[1] = interval[1]
+				}
+			} else {
+				merged = append(merged, interval)
+			}
+		}
+		regionSet.intervals[name] = merged
+	}
+	return regionSet
+}
+
+// Regions gets the merged regions, ordered by reference name then start
+func (regionSet *RegionSet) Regions() []*Region {
+	names := []string{}
+	for name := range regionSet.intervals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	regions := []*Region{}
+	for _, name := range names {
+		for _, interval := range regionSet.intervals[name] {
+			start, end := interval[0], interval[1]
+			if name == UnplacedReferenceName {
+				start, end = RegionUnbounded, RegionUnbounded
+			} else if end == math.MaxInt32 {
+				end = RegionUnbounded
+			}
+			regions = append(regions, &Region{name, start, end})
+		}
+	}
+	return regions
+}
+
+// Matches indicates whether an alignment overlaps any region in the set,
+// implementing SamRecordFilter
+func (regionSet *RegionSet) Matches(samRecord *SamRecord) (bool, error) {
+	name := samRecord.getField(2)
+	intervals, ok := regionSet.intervals[name]
+	if !ok {
+		return false, nil
+	}
+	if name == UnplacedReferenceName {
+		return true, nil
+	}
+
+	start, end, err := samRecord.alignmentSpan()
+	if err != nil {
+		return false, err
+	}
+
+	// find the first interval ending after the alignment start, the alignment
+	// overlaps it if that interval begins before the alignment end
+	i := sort.Search(len(intervals), func(i int) bool {
+		return intervals[i][1] > start
+	})
+	return i < len(intervals) && intervals[i][0] < end, nil
+}
+
+// ParseRegion parses a region string of the form 'name', 'name:start-end',
+// 'name:start-' or 'name:-end', where start and end are 0-based and end is
+// exclusive, as in htsget. Reference names containing ':' are supported
+func ParseRegion(regionString string) (*Region, error) {
+	name := regionString
+	start, end := RegionUnbounded, RegionUnbounded
+
+	colon := strings.LastIndex(regionString, ":")
+	if colon >= 0 {
+		if match := regionIntervalPattern.FindStringSubmatch(regionString[colon+1:]); match != nil {
+			name = regionString[:colon]
+			if match[1] != "" {
+				start, _ = strconv.Atoi(match[1])
+			}
+			if match[3] != "" {
+				end, _ = strconv.Atoi(match[3])
+			}
+			// a single position without '-' selects one base
+			if match[2] == "" && match[1] != "" {
+				end = start + 1
+			}
+		}
+	}
+
+	region, err := NewRegion(name, start, end)
+	if err != nil {
+		return nil, errors.New("Invalid region '" + regionString + "': " + err.Error())
+	}
+	return region, nil
+}
+
+// ReadBedRegions reads regions from a BED file (0-based, end exclusive). Only
+// the first three columns are used. Blank, comment, track and browser lines
+// are skipped
+func ReadBedRegions(reader io.Reader) ([]*Region, error) {
+	regions := []*Region{}
+	lineReader := NewLineReader(reader)
+	lineNumber := 0
+	for {
+		text, err := lineReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lineNumber++
+		line := strings.TrimSpace(text)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+
+		columns := strings.Fields(line)
+		lineError := func(reason string) error {
+			return errors.New("Invalid BED line " + strconv.Itoa(lineNumber) + ": " + reason)
+		}
+		if len(columns) < 3 {
+			return nil, lineError("expected at least 3 columns")
+		}
+		start, err := strconv.Atoi(columns[1])
+		if err != nil {
+			return nil, lineError("invalid start '" + columns[1] + "'")
+		}
+		end, err := strconv.Atoi(columns[2])
+		if err != nil {
+			return nil, lineError("invalid end '" + columns[2] + "'")
+		}
+		if start < 0 || end < 0 {
+			return nil, lineError("start/end must be non-negative")
+		}
+		region, err := NewRegion(columns[0], start, end)
+		if err != nil {
+			return nil, lineError(err.Error())
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
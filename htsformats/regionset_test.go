@@ -0,0 +1,149 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module regionset_test tests regionset
+package htsformats
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newRegionSetTC test cases for NewRegionSet, by region strings
+var newRegionSetTC = []struct {
+	regions    []string
+	expRegions []string
+}{
+	{
+		[]string{"chr1:100-200", "chr1:150-300", "chr1:400-500"},
+		[]string{"[Region chr1:100-300]", "[Region chr1:400-500]"},
+	},
+	{
+		[]string{"chr2:0-10", "chr1:400-500", "chr1:100-200", "chr1:200-250"},
+		[]string{"[Region chr1:100-250]", "[Region chr1:400-500]", "[Region chr2:0-10]"},
+	},
+	{
+		[]string{"chr1:100-", "chr1:50-150", "chr1:-10"},
+		[]string{"[Region chr1:0-10]", "[Region chr1:50-]"},
+	},
+	{
+		[]string{"*", "chr1", "*"},
+		[]string{"[Region *:-]", "[Region chr1:0-]"},
+	},
+}
+
+// parseRegionTC test cases for ParseRegion
+var parseRegionTC = []struct {
+	regionString string
+	expError     bool
+	expRegion    Region
+}{
+	{"chr1", false, Region{"chr1", RegionUnbounded, RegionUnbounded}},
+	{"chr1:100-200", false, Region{"chr1", 100, 200}},
+	{"chr1:100-", false, Region{"chr1", 100, RegionUnbounded}},
+	{"chr1:-200", false, Region{"chr1", RegionUnbounded, 200}},
+	{"chr1:100", false, Region{"chr1", 100, 101}},
+	{"HLA-A*01:01:01:01", false, Region{"HLA-A*01:01:01", 1, 2}},
+	{"HLA-A*01:01:01:01:10-20", false, Region{"HLA-A*01:01:01:01", 10, 20}},
+	{"chrUn:abc", false, Region{"chrUn:abc", RegionUnbounded, RegionUnbounded}},
+	{"*", false, Region{"*", RegionUnbounded, RegionUnbounded}},
+	{"chr1:200-100", true, Region{}},
+	{"*:1-10", true, Region{}},
+	{"", true, Region{}},
+}
+
+// readBedRegionsTC test cases for ReadBedRegions
+var readBedRegionsTC = []struct {
+	bed        string
+	expError   bool
+	expRegions []Region
+}{
+	{"", false, []Region{}},
+	{"chr1\t0\t100\nchr2 5 10 name 0 +\n", false, []Region{{"chr1", 0, 100}, {"chr2", 5, 10}}},
+	{"browser position chr1\ntrack name=x\n# comment\n\nchr1\t0\t100\n", false, []Region{{"chr1", 0, 100}}},
+	{"chr1\t0\n", true, nil},
+	{"chr1\tX\t100\n", true, nil},
+	{"chr1\t0\tY\n", true, nil},
+	{"chr1\t100\t100\n", true, nil},
+	{"chr1\t-1\t100\n", true, nil},
+	{"chr1\t0\t-1\n", true, nil},
+}
+
+// regionSetMatchesTC test cases for RegionSet Matches
+var regionSetMatchesTC = []struct {
+	regions    []string
+	raw        string
+	expMatches bool
+}{
+	{[]string{"chr1:0-100", "chr1:300-400"}, "r\t0\tchr1\t151\t60\t100M\t*\t0\t0\t*\t*", false},
+	{[]string{"chr1:0-100", "chr1:300-400"}, "r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*", false},
+	{[]string{"chr1:0-100", "chr1:300-400"}, "r\t0\tchr1\t100\t60\t100M\t*\t0\t0\t*\t*", true},
+	{[]string{"chr1:0-100", "chr1:300-400"}, "r\t0\tchr1\t201\t60\t100M\t*\t0\t0\t*\t*", false},
+	{[]string{"chr1:0-100", "chr1:300-400"}, "r\t0\tchr1\t202\t60\t100M\t*\t0\t0\t*\t*", true},
+	{[]string{"chr1:0-100", "chr1:300-400"}, "r\t0\tchr1\t50\t60\t10M500N10M\t*\t0\t0\t*\t*", true},
+	{[]string{"chr1:0-100", "chr1:300-400"}, "r\t0\tchr2\t50\t60\t10M\t*\t0\t0\t*\t*", false},
+	{[]string{"chr1:0-100", "*"}, "r\t4\t*\t0\t0\t*\t*\t0\t0\t*\t*", true},
+	{[]string{"chr1:0-100"}, "r\t4\t*\t0\t0\t*\t*\t0\t0\t*\t*", false},
+}
+
+// TestNewRegionSet tests NewRegionSet and Regions functions
+func TestNewRegionSet(t *testing.T) {
+	for _, tc := range newRegionSetTC {
+		regions := []*Region{}
+		for _, regionString := range tc.regions {
+			region, _ := ParseRegion(regionString)
+			regions = append(regions, region)
+		}
+		actual := []string{}
+		for _, region := range NewRegionSet(regions).Regions() {
+			actual = append(actual, region.String())
+		}
+		assert.Equal(t, tc.expRegions, actual)
+	}
+}
+
+// TestParseRegion tests ParseRegion function
+func TestParseRegion(t *testing.T) {
+	for _, tc := range parseRegionTC {
+		region, err := ParseRegion(tc.regionString)
+		if tc.expError {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expRegion, *region)
+		}
+	}
+}
+
+// TestReadBedRegions tests ReadBedRegions function
+func TestReadBedRegions(t *testing.T) {
+	for _, tc := range readBedRegionsTC {
+		regions, err := ReadBedRegions(strings.NewReader(tc.bed))
+		if tc.expError {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+			actual := []Region{}
+			for _, region := range regions {
+				actual = append(actual, *region)
+			}
+			assert.Equal(t, tc.expRegions, actual)
+		}
+	}
+}
+
+// TestRegionSetMatches tests RegionSet Matches function
+func TestRegionSetMatches(t *testing.T) {
+	for _, tc := range regionSetMatchesTC {
+		regions := []*Region{}
+		for _, regionString := range tc.regions {
+			region, _ := ParseRegion(regionString)
+			regions = append(regions, region)
+		}
+		matches, err := NewRegionSet(regions).Matches(newTestSamRecord(tc.raw))
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expMatches, matches)
+	}
+}
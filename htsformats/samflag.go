@@ -0,0 +1,121 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samflag defines the bitwise FLAG of an alignment, its symbolic names,
+// and a SamRecordFilter requiring or excluding flag bits
+package htsformats
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SamFlag the bitwise FLAG field of an alignment
+type SamFlag uint16
+
+// FLAG bits, as in the SAM specification
+const (
+	FlagPaired        SamFlag = 0x1
+	FlagProperPair    SamFlag = 0x2
+	FlagUnmapped      SamFlag = 0x4
+	FlagMateUnmapped  SamFlag = 0x8
+	FlagReverse       SamFlag = 0x10
+	FlagMateReverse   SamFlag = 0x20
+	FlagRead1         SamFlag = 0x40
+	FlagRead2         SamFlag = 0x80
+	FlagSecondary     SamFlag = 0x100
+	FlagQCFail        SamFlag = 0x200
+	FlagDuplicate     SamFlag = 0x400
+	FlagSupplementary SamFlag = 0x800
+)
+
+// samFlagNames symbolic name of each FLAG bit, as used by samtools, in bit order
+var samFlagNames = []struct {
+	name string
+	flag SamFlag
+}{
+	{"PAIRED", FlagPaired},
+	{"PROPER_PAIR", FlagProperPair},
+	{"UNMAP", FlagUnmapped},
+	{"MUNMAP", FlagMateUnmapped},
+	{"REVERSE", FlagReverse},
+	{"MREVERSE", FlagMateReverse},
+	{"READ1", FlagRead1},
+	{"READ2", FlagRead2},
+	{"SECONDARY", FlagSecondary},
+	{"QCFAIL", FlagQCFail},
+	{"DUP", FlagDuplicate},
+	{"SUPPLEMENTARY", FlagSupplementary},
+}
+
+// ParseSamFlag parses a FLAG mask, either numeric (decimal, 0x hexadecimal or
+// 0 octal) or a comma-delimited list of symbolic names, eg. 'PAIRED,DUP'
+func ParseSamFlag(text string) (SamFlag, error) {
+	if text == "" {
+		return 0, errors.New("Invalid flag: ''")
+	}
+	if text[0] >= '0' && text[0] <= '9' {
+		value, err := strconv.ParseUint(text, 0, 16)
+		if err != nil || value > 0xfff {
+			return 0, errors.New("Invalid flag: '" + text + "'")
+		}
+		return SamFlag(value), nil
+	}
+
+	var flag SamFlag
+	for _, name := range strings.Split(text, ",") {
+		found := false
+		for _, samFlagName := range samFlagNames {
+			if strings.EqualFold(name, samFlagName.name) {
+				flag |= samFlagName.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, errors.New("Invalid flag name '" + name + "' in flag: '" + text + "'")
+		}
+	}
+	return flag, nil
+}
+
+// Has indicates whether all bits of a mask are set
+func (flag SamFlag) Has(mask SamFlag) bool {
+	return flag&mask == mask
+}
+
+// String gets the comma-delimited symbolic names of all set bits
+func (flag SamFlag) String() string {
+	names := []string{}
+	for _, samFlagName := range samFlagNames {
+		if flag.Has(samFlagName.flag) {
+			names = append(names, samFlagName.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// FlagFilter matches alignments with all required FLAG bits set and none of
+// the excluded bits set, as samtools view -f and -F
+type FlagFilter struct {
+	require SamFlag
+	exclude SamFlag
+}
+
+// NewFlagFilter constructs a FlagFilter from required and excluded masks
+func NewFlagFilter(require SamFlag, exclude SamFlag) *FlagFilter {
+	flagFilter := new(FlagFilter)
+	flagFilter.require = require
+	flagFilter.exclude = exclude
+	return flagFilter
+}
+
+// Matches indicates whether the alignment's FLAG satisfies the filter
+func (flagFilter *FlagFilter) Matches(samRecord *SamRecord) (bool, error) {
+	flag, err := samRecord.Flag()
+	if err != nil {
+		return false, err
+	}
+	return flag.Has(flagFilter.require) && flag&flagFilter.exclude == 0, nil
+}
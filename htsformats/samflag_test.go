@@ -0,0 +1,96 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samflag_test tests samflag
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parseSamFlagTC test cases for ParseSamFlag
+var parseSamFlagTC = []struct {
+	text     string
+	expError bool
+	exp      SamFlag
+}{
+	{"0", false, 0},
+	{"1796", false, FlagUnmapped | FlagSecondary | FlagQCFail | FlagDuplicate},
+	{"0x900", false, FlagSecondary | FlagSupplementary},
+	{"020", false, FlagReverse},
+	{"PAIRED", false, FlagPaired},
+	{"UNMAP,SECONDARY,dup", false, FlagUnmapped | FlagSecondary | FlagDuplicate},
+	{"PROPER_PAIR,MUNMAP,MREVERSE,READ1,READ2,QCFAIL,SUPPLEMENTARY", false, 0xaea},
+	{"", true, 0},
+	{"4096", true, 0},
+	{"-1", true, 0},
+	{"0xz", true, 0},
+	{"PAIRED,", true, 0},
+	{"UNMAPPED", true, 0},
+}
+
+// TestParseSamFlag tests function ParseSamFlag
+func TestParseSamFlag(t *testing.T) {
+	for _, tc := range parseSamFlagTC {
+		flag, err := ParseSamFlag(tc.text)
+		if tc.expError {
+			assert.NotNil(t, err, tc.text)
+			continue
+		}
+		assert.Nil(t, err, tc.text)
+		assert.Equal(t, tc.exp, flag, tc.text)
+	}
+}
+
+// TestSamFlagString tests SamFlag String and Has functions
+func TestSamFlagString(t *testing.T) {
+	flag := SamFlag(99)
+	assert.Equal(t, "PAIRED,PROPER_PAIR,MREVERSE,READ1", flag.String())
+	assert.Equal(t, "", SamFlag(0).String())
+	assert.True(t, flag.Has(FlagPaired|FlagRead1))
+	assert.False(t, flag.Has(FlagPaired|FlagRead2))
+	assert.True(t, flag.Has(0))
+}
+
+// TestSamRecordFlag tests SamRecord Flag and SetFlag functions
+func TestSamRecordFlag(t *testing.T) {
+	samRecord := newTestSamRecord("r\t147\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF")
+	flag, err := samRecord.Flag()
+	assert.Nil(t, err)
+	assert.Equal(t, FlagPaired|FlagProperPair|FlagReverse|FlagRead2, flag)
+
+	assert.Nil(t, samRecord.SetFlag(flag|FlagDuplicate))
+	assert.Equal(t, "1171", samRecord.emitFields()[1])
+	assert.Equal(t, "1171", samRecord.getField(1))
+
+	_, err = newTestSamRecord("r\tx\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF").Flag()
+	assert.NotNil(t, err)
+}
+
+// flagFilterTC test cases for FlagFilter
+var flagFilterTC = []struct {
+	require, exclude SamFlag
+	flag             string
+	expMatches       bool
+}{
+	{0, 0, "0", true},
+	{FlagPaired, 0, "99", true},
+	{FlagPaired | FlagRead2, 0, "99", false},
+	{0, FlagReverse, "99", true},
+	{0, FlagReverse | FlagSecondary, "339", false},
+	{FlagRead1, FlagUnmapped, "69", false},
+}
+
+// TestFlagFilterMatches tests FlagFilter Matches function
+func TestFlagFilterMatches(t *testing.T) {
+	for _, tc := range flagFilterTC {
+		samRecord := newTestSamRecord("r\t" + tc.flag + "\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF")
+		matches, err := NewFlagFilter(tc.require, tc.exclude).Matches(samRecord)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expMatches, matches, tc.flag)
+	}
+	_, err := NewFlagFilter(FlagPaired, 0).Matches(newTestSamRecord("r\tx\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,389 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samheader defines the SAM header, parsed into individual @HD, @SQ,
+// @RG, @PG, and @CO records
+package htsformats
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// samHeaderRequiredTags tags required on each header record type
+var samHeaderRequiredTags = map[string][]string{
+	"HD": {"VN"},
+	"SQ": {"SN", "LN"},
+	"RG": {"ID"},
+	"PG": {"ID"},
+	"CO": {},
+}
+
+// samHeaderUniqueTags tag of each header record type that must be unique
+// across all records of that type
+var samHeaderUniqueTags = map[string]string{
+	"SQ": "SN",
+	"RG": "ID",
+	"PG": "ID",
+}
+
+// SamHeaderTag a single TAG:VALUE pair of a header record
+type SamHeaderTag struct {
+	Key   string
+	Value string
+}
+
+// SamHeaderRecord a single header line. @CO records hold free text rather
+// than tags. The original line is kept so that unmodified records serialize
+// identically
+type SamHeaderRecord struct {
+	recordType string
+	tags       []SamHeaderTag
+	comment    string
+	raw        string
+}
+
+// SamHeader holds all header records, in the order they appeared
+type SamHeader struct {
+	Records []*SamHeaderRecord
+}
+
+// NewSamHeaderRecord parses a single header line
+func NewSamHeaderRecord(line string) (*SamHeaderRecord, error) {
+	if len(line) < 3 || line[0] != '@' {
+		return nil, errors.New("Invalid header line: '" + line + "'")
+	}
+	samHeaderRecord := new(SamHeaderRecord)
+	samHeaderRecord.recordType = line[1:3]
+	samHeaderRecord.tags = []SamHeaderTag{}
+	samHeaderRecord.raw = line
+
+	rest := line[3:]
+	if rest != "" && rest[0] != '\t' {
+		return nil, errors.New("Invalid header line: '" + line + "'")
+	}
+	if samHeaderRecord.recordType == "CO" {
+		samHeaderRecord.comment = strings.TrimPrefix(rest, "\t")
+		return samHeaderRecord, nil
+	}
+	if rest == "" {
+		return samHeaderRecord, nil
+	}
+	for _, field := range strings.Split(rest[1:], "\t") {
+		if len(field) < 3 || field[2] != ':' {
+			return nil, errors.New("Invalid header tag '" + field + "' in line: '" + line + "'")
+		}
+		samHeaderRecord.tags = append(samHeaderRecord.tags, SamHeaderTag{field[:2], field[3:]})
+	}
+	return samHeaderRecord, nil
+}
+
+// NewSamHeaderComment constructs a @CO record
+func NewSamHeaderComment(comment string) *SamHeaderRecord {
+	return &SamHeaderRecord{recordType: "CO", tags: []SamHeaderTag{}, comment: comment}
+}
+
+// NewSamHeaderTagRecord constructs a record of the given type (eg. "SQ") from
+// an ordered list of tags
+func NewSamHeaderTagRecord(recordType string, tags []SamHeaderTag) *SamHeaderRecord {
+	return &SamHeaderRecord{recordType: recordType, tags: append([]SamHeaderTag{}, tags...)}
+}
+
+// Type gets the two-letter record type, eg. "SQ"
+func (samHeaderRecord *SamHeaderRecord) Type() string {
+	return samHeaderRecord.recordType
+}
+
+// Comment gets the free text of a @CO record
+func (samHeaderRecord *SamHeaderRecord) Comment() string {
+	return samHeaderRecord.comment
+}
+
+// Tags gets a copy of the record's tags, in order
+func (samHeaderRecord *SamHeaderRecord) Tags() []SamHeaderTag {
+	return append([]SamHeaderTag{}, samHeaderRecord.tags...)
+}
+
+// Map gets the record's tags as a key/value map
+func (samHeaderRecord *SamHeaderRecord) Map() map[string]string {
+	values := make(map[string]string)
+	for _, tag := range samHeaderRecord.tags {
+		values[tag.Key] = tag.Value
+	}
+	return values
+}
+
+// Get gets the value of a tag, and whether the tag was present
+func (samHeaderRecord *SamHeaderRecord) Get(key string) (string, bool) {
+	for _, tag := range samHeaderRecord.tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetInt gets the integer value of a tag, eg. LN of a @SQ record
+func (samHeaderRecord *SamHeaderRecord) GetInt(key string) (int, error) {
+	value, ok := samHeaderRecord.Get(key)
+	if !ok {
+		return 0, errors.New("Tag '" + key + "' not found in header line: '" + samHeaderRecord.String() + "'")
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("Invalid integer tag '" + key + ":" + value + "' in header line: '" + samHeaderRecord.String() + "'")
+	}
+	return intValue, nil
+}
+
+// Set sets the value of a tag, replacing the existing value in place or
+// appending the tag if not present
+func (samHeaderRecord *SamHeaderRecord) Set(key string, value string) {
+	samHeaderRecord.raw = ""
+	for i, tag := range samHeaderRecord.tags {
+		if tag.Key == key {
+			samHeaderRecord.tags[i].Value = value
+			return
+		}
+	}
+	samHeaderRecord.tags = append(samHeaderRecord.tags, SamHeaderTag{key, value})
+}
+
+// Delete removes a tag, if present
+func (samHeaderRecord *SamHeaderRecord) Delete(key string) {
+	tags := []SamHeaderTag{}
+	for _, tag := range samHeaderRecord.tags {
+		if tag.Key != key {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) != len(samHeaderRecord.tags) {
+		samHeaderRecord.raw = ""
+		samHeaderRecord.tags = tags
+	}
+}
+
+// String serializes the record as a single header line. Unmodified records
+// are serialized exactly as they were parsed
+func (samHeaderRecord *SamHeaderRecord) String() string {
+	if samHeaderRecord.raw != "" {
+		return samHeaderRecord.raw
+	}
+	if samHeaderRecord.recordType == "CO" {
+		return "@CO\t" + samHeaderRecord.comment
+	}
+	fields := []string{"@" + samHeaderRecord.recordType}
+	for _, tag := range samHeaderRecord.tags {
+		fields = append(fields, tag.Key+":"+tag.Value)
+	}
+	return strings.Join(fields, "\t")
+}
+
+// validate checks the record has all tags required for its type, and that
+// no tag is repeated
+func (samHeaderRecord *SamHeaderRecord) validate() error {
+	required, ok := samHeaderRequiredTags[samHeaderRecord.recordType]
+	if !ok {
+		return errors.New("Invalid header record type '@" + samHeaderRecord.recordType + "'")
+	}
+	seen := make(map[string]bool)
+	for _, tag := range samHeaderRecord.tags {
+		if seen[tag.Key] {
+			return errors.New("Duplicate tag '" + tag.Key + "' in header line: '" + samHeaderRecord.String() + "'")
+		}
+		seen[tag.Key] = true
+	}
+	for _, key := range required {
+		if !seen[key] {
+			return errors.New("@" + samHeaderRecord.recordType + " line missing required tag '" + key + "': '" + samHeaderRecord.String() + "'")
+		}
+	}
+	return nil
+}
+
+// NewSamHeader parses a list of header lines. The header is not validated
+func NewSamHeader(lines []string) (*SamHeader, error) {
+	samHeader := new(SamHeader)
+	samHeader.Records = []*SamHeaderRecord{}
+	for _, line := range lines {
+		samHeaderRecord, err := NewSamHeaderRecord(line)
+		if err != nil {
+			return nil, err
+		}
+		samHeader.Records = append(samHeader.Records, samHeaderRecord)
+	}
+	return samHeader, nil
+}
+
+// Lines serializes the header as a list of lines
+func (samHeader *SamHeader) Lines() []string {
+	lines := make([]string, len(samHeader.Records))
+	for i, samHeaderRecord := range samHeader.Records {
+		lines[i] = samHeaderRecord.String()
+	}
+	return lines
+}
+
+// String serializes the header as text, each line terminated by a newline
+func (samHeader *SamHeader) String() string {
+	if len(samHeader.Records) == 0 {
+		return ""
+	}
+	return strings.Join(samHeader.Lines(), "\n") + "\n"
+}
+
+// Validate checks that all records are of a known type with their required
+// tags, that @HD is only present as the first line, that @SQ lengths are
+// valid, that @SQ names and @RG/@PG ids are unique, and that each @PG PP
+// refers to an existing @PG
+func (samHeader *SamHeader) Validate() error {
+	unique := make(map[string]map[string]bool)
+	for i, samHeaderRecord := range samHeader.Records {
+		if err := samHeaderRecord.validate(); err != nil {
+			return err
+		}
+		recordType := samHeaderRecord.recordType
+		if recordType == "HD" && i != 0 {
+			return errors.New("@HD must be the first header line")
+		}
+		if recordType == "SQ" {
+			length, err := samHeaderRecord.GetInt("LN")
+			if err != nil {
+				return err
+			}
+			if length < 1 || length > math.MaxInt32 {
+				return errors.New("@SQ length out of range: '" + samHeaderRecord.String() + "'")
+			}
+		}
+		if key, ok := samHeaderUniqueTags[recordType]; ok {
+			value, _ := samHeaderRecord.Get(key)
+			if unique[recordType] == nil {
+				unique[recordType] = make(map[string]bool)
+			}
+			if unique[recordType][value] {
+				return errors.New("Duplicate @" + recordType + " " + key + " '" + value + "'")
+			}
+			unique[recordType][value] = true
+		}
+	}
+	for _, program := range samHeader.RecordsOfType("PG") {
+		if pp, ok := program.Get("PP"); ok && !unique["PG"][pp] {
+			return errors.New("@PG PP '" + pp + "' does not refer to an existing @PG ID")
+		}
+	}
+	return nil
+}
+
+// RecordsOfType gets all records of a type (eg. "SQ"), in order
+func (samHeader *SamHeader) RecordsOfType(recordType string) []*SamHeaderRecord {
+	records := []*SamHeaderRecord{}
+	for _, samHeaderRecord := range samHeader.Records {
+		if samHeaderRecord.recordType == recordType {
+			records = append(records, samHeaderRecord)
+		}
+	}
+	return records
+}
+
+// lookup gets the first record of a type with a tag value
+func (samHeader *SamHeader) lookup(recordType string, key string, value string) (*SamHeaderRecord, bool) {
+	for _, samHeaderRecord := range samHeader.RecordsOfType(recordType) {
+		if v, ok := samHeaderRecord.Get(key); ok && v == value {
+			return samHeaderRecord, true
+		}
+	}
+	return nil, false
+}
+
+// Reference gets the @SQ record for a reference name
+func (samHeader *SamHeader) Reference(name string) (*SamHeaderRecord, bool) {
+	return samHeader.lookup("SQ", "SN", name)
+}
+
+// ReadGroup gets the @RG record for a read group id
+func (samHeader *SamHeader) ReadGroup(id string) (*SamHeaderRecord, bool) {
+	return samHeader.lookup("RG", "ID", id)
+}
+
+// Program gets the @PG record for a program id
+func (samHeader *SamHeader) Program(id string) (*SamHeaderRecord, bool) {
+	return samHeader.lookup("PG", "ID", id)
+}
+
+// References gets the reference dictionary from the SN and LN values of @SQ
+// records, in order
+func (samHeader *SamHeader) References() ([]BamReference, error) {
+	references := []BamReference{}
+	for _, samHeaderRecord := range samHeader.RecordsOfType("SQ") {
+		name, ok := samHeaderRecord.Get("SN")
+		if !ok || name == "" {
+			return nil, errors.New("@SQ line missing required tag 'SN': '" + samHeaderRecord.String() + "'")
+		}
+		length, err := samHeaderRecord.GetInt("LN")
+		if err != nil {
+			return nil, err
+		}
+		references = append(references, BamReference{name, length})
+	}
+	return references, nil
+}
+
+// lastProgramID gets the ID of the @PG record ending the program chain, ie.
+// the last @PG record whose ID is not referenced by another record's PP
+func (samHeader *SamHeader) lastProgramID() string {
+	programs := samHeader.RecordsOfType("PG")
+	referenced := make(map[string]bool)
+	for _, program := range programs {
+		if pp, ok := program.Get("PP"); ok {
+			referenced[pp] = true
+		}
+	}
+	for i := len(programs) - 1; i >= 0; i-- {
+		if id, ok := programs[i].Get("ID"); ok && !referenced[id] {
+			return id
+		}
+	}
+	return ""
+}
+
+// AddProgram appends a @PG record chained to the last existing @PG record via
+// PP. The ID is the program name, suffixed with '.N' if already in use
+func (samHeader *SamHeader) AddProgram(name string, commandLine string) *SamHeaderRecord {
+	id := name
+	for i := 1; ; i++ {
+		if _, ok := samHeader.Program(id); !ok {
+			break
+		}
+		id = name + "." + strconv.Itoa(i)
+	}
+
+	tags := []SamHeaderTag{{"ID", id}, {"PN", name}}
+	if pp := samHeader.lastProgramID(); pp != "" {
+		tags = append(tags, SamHeaderTag{"PP", pp})
+	}
+	tags = append(tags, SamHeaderTag{"CL", strings.Replace(commandLine, "\t", " ", -1)})
+	program := NewSamHeaderTagRecord("PG", tags)
+	samHeader.Records = append(samHeader.Records, program)
+	return program
+}
+
+// FilterReferences removes @SQ records whose SN is not in the list of
+// reference names to keep
+func (samHeader *SamHeader) FilterReferences(referenceNames []string) {
+	keep := make(map[string]bool)
+	for _, name := range referenceNames {
+		keep[name] = true
+	}
+	records := []*SamHeaderRecord{}
+	for _, samHeaderRecord := range samHeader.Records {
+		if samHeaderRecord.recordType == "SQ" {
+			if name, _ := samHeaderRecord.Get("SN"); !keep[name] {
+				continue
+			}
+		}
+		records = append(records, samHeaderRecord)
+	}
+	samHeader.Records = records
+}
@@ -0,0 +1,207 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samheader_test tests samheader
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// samHeaderLines header lines used across SamHeader tests
+var samHeaderLines = []string{
+	"@HD\tVN:1.6\tSO:coordinate",
+	"@SQ\tSN:chr1\tLN:1000",
+	"@SQ\tSN:chr2\tLN:2000",
+	"@RG\tID:rg1\tSM:sample",
+	"@PG\tID:bwa\tPN:bwa\tCL:bwa mem ref.fa r1.fq",
+	"@PG\tID:samtools\tPN:samtools\tPP:bwa",
+	"@CO\tfree text: with\ttabs",
+}
+
+// newSamHeaderRecordTC test cases for NewSamHeaderRecord
+var newSamHeaderRecordTC = []struct {
+	line     string
+	expError bool
+	expType  string
+	expTags  []SamHeaderTag
+}{
+	{"@HD\tVN:1.6", false, "HD", []SamHeaderTag{{"VN", "1.6"}}},
+	{"@SQ\tSN:chr1:1\tLN:100", false, "SQ", []SamHeaderTag{{"SN", "chr1:1"}, {"LN", "100"}}},
+	{"@CO\tVN:1.6", false, "CO", []SamHeaderTag{}},
+	{"@PG", false, "PG", []SamHeaderTag{}},
+	{"HD\tVN:1.6", true, "", nil},
+	{"@HDVN:1.6", true, "", nil},
+	{"@SQ\tSN", true, "", nil},
+}
+
+// TestNewSamHeaderRecord tests function NewSamHeaderRecord
+func TestNewSamHeaderRecord(t *testing.T) {
+	for _, tc := range newSamHeaderRecordTC {
+		samHeaderRecord, err := NewSamHeaderRecord(tc.line)
+		if tc.expError {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expType, samHeaderRecord.Type())
+		assert.Equal(t, tc.expTags, samHeaderRecord.Tags())
+		assert.Equal(t, tc.line, samHeaderRecord.String())
+	}
+}
+
+// TestSamHeaderRecordModify tests functions Set and Delete, and that modified
+// records are serialized from their tags
+func TestSamHeaderRecordModify(t *testing.T) {
+	samHeaderRecord, _ := NewSamHeaderRecord("@SQ\tSN:chr1\tLN:100\tM5:abc")
+	assert.Equal(t, map[string]string{"SN": "chr1", "LN": "100", "M5": "abc"}, samHeaderRecord.Map())
+	length, err := samHeaderRecord.GetInt("LN")
+	assert.Nil(t, err)
+	assert.Equal(t, 100, length)
+	_, err = samHeaderRecord.GetInt("M5")
+	assert.NotNil(t, err)
+	_, err = samHeaderRecord.GetInt("AS")
+	assert.NotNil(t, err)
+
+	samHeaderRecord.Set("LN", "200")
+	samHeaderRecord.Set("AS", "GRCm38")
+	samHeaderRecord.Delete("M5")
+	samHeaderRecord.Delete("UR")
+	assert.Equal(t, "@SQ\tSN:chr1\tLN:200\tAS:GRCm38", samHeaderRecord.String())
+
+	assert.Equal(t, "@CO\tnote", NewSamHeaderComment("note").String())
+	assert.Equal(t, "@RG\tID:a", NewSamHeaderTagRecord("RG", []SamHeaderTag{{"ID", "a"}}).String())
+}
+
+// TestSamHeaderLines tests function Lines, which must reproduce unmodified
+// input exactly
+func TestSamHeaderLines(t *testing.T) {
+	samHeader, err := NewSamHeader(samHeaderLines)
+	assert.Nil(t, err)
+	assert.Equal(t, samHeaderLines, samHeader.Lines())
+	assert.Equal(t, 2, len(samHeader.RecordsOfType("SQ")))
+
+	// irregular but parseable lines
+	irregular := []string{"@CO", "@CO\t", "@SQ\tSN:chr1\tLN:100\r"}
+	samHeader, err = NewSamHeader(irregular)
+	assert.Nil(t, err)
+	assert.Equal(t, irregular, samHeader.Lines())
+	assert.Equal(t, "@CO\n@CO\t\n@SQ\tSN:chr1\tLN:100\r\n", samHeader.String())
+
+	_, err = NewSamHeader([]string{"@HD\tVN:1.6", "bad"})
+	assert.NotNil(t, err)
+}
+
+// samHeaderAddProgramTC test cases for AddProgram
+var samHeaderAddProgramTC = []struct {
+	lines []string
+	exp   string
+}{
+	// chained to the last program in the chain
+	{samHeaderLines, "@PG\tID:htsget-refserver-utils\tPN:htsget-refserver-utils\tPP:samtools\tCL:a b"},
+	// no previous program
+	{[]string{"@HD\tVN:1.6"}, "@PG\tID:htsget-refserver-utils\tPN:htsget-refserver-utils\tCL:a b"},
+	// ID already in use, and chain ending in an earlier line
+	{
+		[]string{
+			"@PG\tID:htsget-refserver-utils\tPN:htsget-refserver-utils\tPP:htsget-refserver-utils.1",
+			"@PG\tID:htsget-refserver-utils.1\tPN:htsget-refserver-utils",
+		},
+		"@PG\tID:htsget-refserver-utils.2\tPN:htsget-refserver-utils\tPP:htsget-refserver-utils\tCL:a b",
+	},
+}
+
+// TestSamHeaderAddProgram tests function AddProgram
+func TestSamHeaderAddProgram(t *testing.T) {
+	for _, tc := range samHeaderAddProgramTC {
+		samHeader, _ := NewSamHeader(tc.lines)
+		samHeader.AddProgram("htsget-refserver-utils", "a\tb")
+		lines := samHeader.Lines()
+		assert.Equal(t, len(tc.lines)+1, len(lines))
+		assert.Equal(t, tc.exp, lines[len(lines)-1])
+	}
+}
+
+// TestSamHeaderFilterReferences tests function FilterReferences
+func TestSamHeaderFilterReferences(t *testing.T) {
+	samHeader, _ := NewSamHeader(samHeaderLines)
+	samHeader.FilterReferences([]string{"chr2", "chr3"})
+	expected := append([]string{samHeaderLines[0]}, samHeaderLines[2:]...)
+	assert.Equal(t, expected, samHeader.Lines())
+
+	samHeader.FilterReferences([]string{})
+	assert.Equal(t, 0, len(samHeader.RecordsOfType("SQ")))
+}
+
+// samHeaderValidateTC test cases for Validate
+var samHeaderValidateTC = []struct {
+	lines    []string
+	expError bool
+}{
+	{samHeaderLines, false},
+	{[]string{}, false},
+	{[]string{"@SQ\tSN:chr1\tLN:1"}, false},
+	// missing required tags
+	{[]string{"@HD\tSO:coordinate"}, true},
+	{[]string{"@SQ\tLN:100"}, true},
+	{[]string{"@SQ\tSN:chr1"}, true},
+	{[]string{"@RG\tSM:sample"}, true},
+	{[]string{"@PG\tPN:bwa"}, true},
+	// invalid values
+	{[]string{"@SQ\tSN:chr1\tLN:abc"}, true},
+	{[]string{"@SQ\tSN:chr1\tLN:0"}, true},
+	{[]string{"@SQ\tSN:chr1\tLN:2147483648"}, true},
+	// duplicates
+	{[]string{"@SQ\tSN:chr1\tLN:100", "@SQ\tSN:chr1\tLN:200"}, true},
+	{[]string{"@RG\tID:rg1", "@RG\tID:rg1"}, true},
+	{[]string{"@PG\tID:bwa", "@PG\tID:bwa"}, true},
+	{[]string{"@SQ\tSN:chr1\tLN:100\tSN:chr2"}, true},
+	// record placement and types
+	{[]string{"@SQ\tSN:chr1\tLN:100", "@HD\tVN:1.6"}, true},
+	{[]string{"@XX\tID:1"}, true},
+	{[]string{"@PG\tID:samtools\tPP:bwa"}, true},
+}
+
+// TestSamHeaderValidate tests function Validate
+func TestSamHeaderValidate(t *testing.T) {
+	for _, tc := range samHeaderValidateTC {
+		samHeader, err := NewSamHeader(tc.lines)
+		assert.Nil(t, err)
+		err = samHeader.Validate()
+		if tc.expError {
+			assert.NotNil(t, err, tc.lines)
+		} else {
+			assert.Nil(t, err, tc.lines)
+		}
+	}
+}
+
+// TestSamHeaderLookup tests functions Reference, ReadGroup, Program, and
+// References
+func TestSamHeaderLookup(t *testing.T) {
+	samHeader, _ := NewSamHeader(samHeaderLines)
+
+	reference, ok := samHeader.Reference("chr2")
+	assert.True(t, ok)
+	assert.Equal(t, samHeaderLines[2], reference.String())
+	_, ok = samHeader.Reference("chr3")
+	assert.False(t, ok)
+
+	readGroup, ok := samHeader.ReadGroup("rg1")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"ID": "rg1", "SM": "sample"}, readGroup.Map())
+	program, ok := samHeader.Program("samtools")
+	assert.True(t, ok)
+	assert.Equal(t, "PG", program.Type())
+	_, ok = samHeader.Program("STAR")
+	assert.False(t, ok)
+
+	references, err := samHeader.References()
+	assert.Nil(t, err)
+	assert.Equal(t, []BamReference{{"chr1", 1000}, {"chr2", 2000}}, references)
+	samHeader, _ = NewSamHeader([]string{"@SQ\tSN:chr1"})
+	_, err = samHeader.References()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,119 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samreader reads plain text SAM, parsing the header up front and
+// alignments one at a time
+package htsformats
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// SamReader reads a plain text SAM stream. Header lines, those starting with
+// '@' before the first alignment, are read on construction. Alignments are
+// then read on demand, until the end of the stream or the context is done
+type SamReader struct {
+	ctx         context.Context
+	lineReader  *LineReader
+	headerLines []string
+	line        string
+	lineNumber  int
+	pending     bool
+	err         error
+}
+
+// NewSamReader constructs a SamReader over a stream, reading the header.
+// Returns an error if the stream could not be read
+func NewSamReader(reader io.Reader) (*SamReader, error) {
+	return NewSamReaderContext(context.Background(), reader)
+}
+
+// NewSamReaderContext constructs a SamReader over a stream, reading the header.
+// Once the context is done, reading stops and the context's error is returned.
+// Returns an error if the stream could not be read
+func NewSamReaderContext(ctx context.Context, reader io.Reader) (*SamReader, error) {
+	samReader := new(SamReader)
+	samReader.ctx = ctx
+	samReader.lineReader = NewLineReader(reader)
+	samReader.headerLines = []string{}
+	for {
+		line, err := samReader.readLine()
+		if err == io.EOF {
+			samReader.err = io.EOF
+			return samReader, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "@") {
+			// the first alignment line is held until requested
+			samReader.pending = true
+			samReader.lineNumber--
+			return samReader, nil
+		}
+		samReader.headerLines = append(samReader.headerLines, line)
+	}
+}
+
+// readLine reads the next line, unless the context is done
+func (samReader *SamReader) readLine() (string, error) {
+	if err := samReader.ctx.Err(); err != nil {
+		return "", err
+	}
+	line, err := samReader.lineReader.Next()
+	if err != nil {
+		return "", err
+	}
+	samReader.line = line
+	samReader.lineNumber++
+	return line, nil
+}
+
+// HeaderLines gets the SAM header as a list of lines
+func (samReader *SamReader) HeaderLines() []string {
+	return samReader.headerLines
+}
+
+// NextLine gets the text of the next alignment line without parsing it.
+// Returns io.EOF once all alignments have been read, the context's error once
+// it is done, or any error reading the stream. Reading stops at the first error
+func (samReader *SamReader) NextLine() (string, error) {
+	if samReader.err != nil {
+		return "", samReader.err
+	}
+	if samReader.pending {
+		if err := samReader.ctx.Err(); err != nil {
+			samReader.err = err
+			return "", err
+		}
+		samReader.pending = false
+		samReader.lineNumber++
+		return samReader.line, nil
+	}
+	line, err := samReader.readLine()
+	if err != nil {
+		samReader.err = err
+		return "", err
+	}
+	return line, nil
+}
+
+// Next reads and parses the next alignment. Returns io.EOF once all alignments
+// have been read, the context's error once it is done, or any error reading the
+// stream. A line that cannot be parsed is returned as an error, after which
+// reading may continue with the next line
+func (samReader *SamReader) Next() (*SamRecord, error) {
+	line, err := samReader.NextLine()
+	if err != nil {
+		return nil, err
+	}
+	return NewSamRecord(line)
+}
+
+// LineNumber gets the 1-based line number, counting header lines, of the
+// alignment last returned by Next or NextLine
+func (samReader *SamReader) LineNumber() int {
+	return samReader.lineNumber
+}
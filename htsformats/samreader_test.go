@@ -0,0 +1,129 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samreader_test tests samreader
+package htsformats
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// samReaderTC test cases for SamReader, by input text
+var samReaderTC = []struct {
+	input          string
+	expHeaderLines []string
+	expLines       []string
+	expLineNumbers []int
+}{
+	{"", []string{}, []string{}, []int{}},
+	{"@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n", []string{"@HD\tVN:1.6", "@SQ\tSN:chr1\tLN:100"}, []string{}, []int{}},
+	{
+		"@HD\tVN:1.6\nr1\t4\t*\t0\t0\t*\t*\t0\t0\tA\tF\nr2\t4\t*\t0\t0\t*\t*\t0\t0\tC\tF",
+		[]string{"@HD\tVN:1.6"},
+		[]string{"r1\t4\t*\t0\t0\t*\t*\t0\t0\tA\tF", "r2\t4\t*\t0\t0\t*\t*\t0\t0\tC\tF"},
+		[]int{2, 3},
+	},
+	{"r1\t4\t*\t0\t0\t*\t*\t0\t0\tA\tF\n", []string{}, []string{"r1\t4\t*\t0\t0\t*\t*\t0\t0\tA\tF"}, []int{1}},
+}
+
+// TestSamReaderNextLine tests SamReader HeaderLines, NextLine and LineNumber
+// functions
+func TestSamReaderNextLine(t *testing.T) {
+	for _, tc := range samReaderTC {
+		samReader, err := NewSamReader(strings.NewReader(tc.input))
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expHeaderLines, samReader.HeaderLines())
+		lines := []string{}
+		lineNumbers := []int{}
+		for {
+			line, err := samReader.NextLine()
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			lines = append(lines, line)
+			lineNumbers = append(lineNumbers, samReader.LineNumber())
+		}
+		assert.Equal(t, tc.expLines, lines)
+		assert.Equal(t, tc.expLineNumbers, lineNumbers)
+
+		// the end of input is sticky
+		_, err = samReader.NextLine()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+// TestSamReaderNext tests SamReader Next function, reading the test file and
+// continuing after a line that cannot be parsed
+func TestSamReaderNext(t *testing.T) {
+	file, _ := os.Open("../data/test/input/modify-sam.sam")
+	defer file.Close()
+	samReader, err := NewSamReader(file)
+	assert.Nil(t, err)
+	assert.True(t, len(samReader.HeaderLines()) > 0)
+	records := 0
+	for {
+		samRecord, err := samReader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.NotEqual(t, "", samRecord.QName())
+		records++
+	}
+	assert.Equal(t, 30, records)
+
+	samReader, _ = NewSamReader(strings.NewReader("@HD\tVN:1.6\nr1\t4\nr2\t4\t*\t0\t0\t*\t*\t0\t0\tA\tF\n"))
+	_, err = samReader.Next()
+	assert.Equal(t, "Expected at least 11 tab-delimited fields, found 2", err.Error())
+	assert.Equal(t, 2, samReader.LineNumber())
+	samRecord, err := samReader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "r2", samRecord.QName())
+	assert.Equal(t, 3, samReader.LineNumber())
+}
+
+// TestSamReaderError tests that SamReader surfaces read errors, in the header
+// and after it
+func TestSamReaderError(t *testing.T) {
+	_, err := NewSamReader(&failingReader{strings.NewReader("@HD\tVN:1.6\n")})
+	assert.Equal(t, errors.New("read failed"), err)
+
+	samReader, err := NewSamReader(&failingReader{strings.NewReader("@HD\tVN:1.6\nr1\nr2\n")})
+	assert.Nil(t, err)
+	line, err := samReader.NextLine()
+	assert.Equal(t, "r1", line)
+	assert.Nil(t, err)
+	line, err = samReader.NextLine()
+	assert.Equal(t, "r2", line)
+	assert.Nil(t, err)
+	_, err = samReader.NextLine()
+	assert.Equal(t, errors.New("read failed"), err)
+}
+
+// TestSamReaderContext tests that SamReader stops reading once its context is
+// cancelled
+func TestSamReaderContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewSamReaderContext(ctx, strings.NewReader("@HD\tVN:1.6\n"))
+	assert.Equal(t, context.Canceled, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	samReader, err := NewSamReaderContext(ctx, strings.NewReader("@HD\tVN:1.6\nr1\t4\t*\t0\t0\t*\t*\t0\t0\tA\tF\nr2\t4\t*\t0\t0\t*\t*\t0\t0\tC\tF\n"))
+	assert.Nil(t, err)
+	_, err = samReader.Next()
+	assert.Nil(t, err)
+	cancel()
+	_, err = samReader.Next()
+	assert.Equal(t, context.Canceled, err)
+	_, err = samReader.Next()
+	assert.Equal(t, context.Canceled, err)
+}
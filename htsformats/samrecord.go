@@ -0,0 +1,540 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samrecord defines single alignments, as appearing in a SAM file
+package htsformats
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// MapqUnavailable MAPQ value indicating the mapping quality is not available
+const MapqUnavailable = 255
+
+// SamRecord holds a single alignment of a SAM file over the bytes of its line.
+// Only the boundaries of the 11 mandatory fields are located on construction,
+// field values and tags are materialized from the line as they are accessed
+type SamRecord struct {
+	data      []byte
+	fieldEnds [samRecordMinColumns]int
+}
+
+// samRecordMinColumns number of mandatory fields of an alignment line
+const samRecordMinColumns = 11
+
+// NewSamRecord constructs a SamRecord from a single line. Returns an error if
+// the line has fewer than the 11 mandatory fields
+func NewSamRecord(raw string) (*SamRecord, error) {
+	return NewSamRecordBytes([]byte(raw))
+}
+
+// NewSamRecordBytes constructs a SamRecord over a single line without copying
+// it, so the line must not be modified while the SamRecord is in use. Returns
+// an error if the line has fewer than the 11 mandatory fields
+func NewSamRecordBytes(line []byte) (*SamRecord, error) {
+	samRecord := new(SamRecord)
+	if err := samRecord.setData(line); err != nil {
+		return nil, err
+	}
+	return samRecord, nil
+}
+
+// setData sets the line of the record, locating the end of each mandatory
+// field. Returns an error if the line has fewer than 11 fields, leaving the
+// record unchanged
+func (samRecord *SamRecord) setData(line []byte) error {
+	var fieldEnds [samRecordMinColumns]int
+	end := -1
+	for col := 0; col < samRecordMinColumns; col++ {
+		if end == len(line) {
+			return errors.New("Expected at least " + strconv.Itoa(samRecordMinColumns) + " tab-delimited fields, found " + strconv.Itoa(col))
+		}
+		start := end + 1
+		end = bytes.IndexByte(line[start:], '\t')
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += start
+		}
+		fieldEnds[col] = end
+	}
+	samRecord.data = line
+	samRecord.fieldEnds = fieldEnds
+	return nil
+}
+
+// field gets the bytes of a field given the column position (0-10 inclusive),
+// without copying
+func (samRecord *SamRecord) field(col int) []byte {
+	start := 0
+	if col > 0 {
+		start = samRecord.fieldEnds[col-1] + 1
+	}
+	return samRecord.data[start:samRecord.fieldEnds[col]]
+}
+
+// emitFields emits all SAM record fields without modification
+func (samRecord *SamRecord) emitFields() []string {
+	fields := make([]string, samRecordMinColumns)
+	for col := range fields {
+		fields[col] = samRecord.getField(col)
+	}
+	return fields
+}
+
+// getField retrieves the corresponding field value given the column position
+// (0-10 inclusive)
+func (samRecord *SamRecord) getField(col int) string {
+	return string(samRecord.field(col))
+}
+
+// tagsStart gets the offset of the first tag in the line. Tags follow the
+// mandatory fields, each preceded by a tab, so the offset is past the end of
+// the line if there are none
+func (samRecord *SamRecord) tagsStart() int {
+	return samRecord.fieldEnds[samRecordMinColumns-1] + 1
+}
+
+// tagEnd gets the offset of the end of the tag starting at an offset
+func (samRecord *SamRecord) tagEnd(start int) int {
+	end := bytes.IndexByte(samRecord.data[start:], '\t')
+	if end < 0 {
+		return len(samRecord.data)
+	}
+	return start + end
+}
+
+// tagKey gets the key of the tag between two offsets, ie. the text preceding
+// the first colon
+func (samRecord *SamRecord) tagKey(start int, end int) []byte {
+	tag := samRecord.data[start:end]
+	if colon := bytes.IndexByte(tag, ':'); colon >= 0 {
+		return tag[:colon]
+	}
+	return tag
+}
+
+// findTag locates the first tag with a key, returning its start and end
+// offsets, or -1 if the record does not have the tag
+func (samRecord *SamRecord) findTag(key string) (int, int) {
+	for start := samRecord.tagsStart(); start <= len(samRecord.data); {
+		end := samRecord.tagEnd(start)
+		if string(samRecord.tagKey(start, end)) == key {
+			return start, end
+		}
+		start = end + 1
+	}
+	return -1, -1
+}
+
+// emitTags emits all tags within the SAM record without modification, in the
+// same order they were parsed
+func (samRecord *SamRecord) emitTags() []string {
+	tags := []string{}
+	for start := samRecord.tagsStart(); start <= len(samRecord.data); {
+		end := samRecord.tagEnd(start)
+		tags = append(tags, string(samRecord.data[start:end]))
+		start = end + 1
+	}
+	return tags
+}
+
+// getTag gets a parsed tag value by its two-letter tag name/key
+func (samRecord *SamRecord) getTag(key string) string {
+	start, end := samRecord.findTag(key)
+	if start < 0 {
+		return ""
+	}
+	return string(samRecord.data[start:end])
+}
+
+// replace replaces the bytes between two offsets of the line, copying it so
+// that a line the record was constructed over is not modified. Returns an
+// error, leaving the record unchanged, if the result is not a valid line
+func (samRecord *SamRecord) replace(start int, end int, value string) error {
+	data := make([]byte, 0, len(samRecord.data)-(end-start)+len(value))
+	data = append(data, samRecord.data[:start]...)
+	data = append(data, value...)
+	data = append(data, samRecord.data[end:]...)
+	return samRecord.setData(data)
+}
+
+// Line gets the tab-delimited SAM line of the record, including any
+// modifications
+func (samRecord *SamRecord) Line() string {
+	return string(samRecord.data)
+}
+
+// QName gets the query template name (QNAME)
+func (samRecord *SamRecord) QName() string {
+	return samRecord.getField(0)
+}
+
+// RName gets the reference sequence name (RNAME), "*" if unplaced
+func (samRecord *SamRecord) RName() string {
+	return samRecord.getField(2)
+}
+
+// Pos gets the parsed 1-based leftmost mapping position (POS), 0 if unplaced
+func (samRecord *SamRecord) Pos() (int, error) {
+	return samRecord.intField(3, "POS")
+}
+
+// Cigar gets the CIGAR string, "*" if unavailable. ParseCigar parses it into
+// operations
+func (samRecord *SamRecord) Cigar() string {
+	return samRecord.getField(5)
+}
+
+// RNext gets the reference sequence name of the mate (RNEXT), "=" if identical
+// to RNAME
+func (samRecord *SamRecord) RNext() string {
+	return samRecord.getField(6)
+}
+
+// PNext gets the parsed 1-based position of the mate (PNEXT)
+func (samRecord *SamRecord) PNext() (int, error) {
+	return samRecord.intField(7, "PNEXT")
+}
+
+// TLen gets the parsed observed template length (TLEN)
+func (samRecord *SamRecord) TLen() (int, error) {
+	return samRecord.intField(8, "TLEN")
+}
+
+// Seq gets the segment sequence (SEQ), "*" if not stored
+func (samRecord *SamRecord) Seq() string {
+	return samRecord.getField(9)
+}
+
+// Qual gets the ASCII of base quality plus 33 (QUAL), "*" if not stored
+func (samRecord *SamRecord) Qual() string {
+	return samRecord.getField(10)
+}
+
+// Tags gets the text of each tag, in the order they appear
+func (samRecord *SamRecord) Tags() []string {
+	return samRecord.emitTags()
+}
+
+// TagKeys gets the two-letter key of each tag, in the order they appear
+func (samRecord *SamRecord) TagKeys() []string {
+	keys := []string{}
+	for start := samRecord.tagsStart(); start <= len(samRecord.data); {
+		end := samRecord.tagEnd(start)
+		keys = append(keys, string(samRecord.tagKey(start, end)))
+		start = end + 1
+	}
+	return keys
+}
+
+// intField parses an integer field given its column position and name
+func (samRecord *SamRecord) intField(col int, name string) (int, error) {
+	text := samRecord.getField(col)
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, errors.New("Invalid " + name + ": '" + text + "'")
+	}
+	return value, nil
+}
+
+// Flag gets the parsed bitwise FLAG
+func (samRecord *SamRecord) Flag() (SamFlag, error) {
+	text := samRecord.getField(1)
+	flag, err := strconv.ParseUint(text, 10, 16)
+	if err != nil {
+		return 0, errors.New("Invalid FLAG: '" + text + "'")
+	}
+	return SamFlag(flag), nil
+}
+
+// SetFlag sets the bitwise FLAG. Returns an error if the record could not be
+// modified
+func (samRecord *SamRecord) SetFlag(flag SamFlag) error {
+	return samRecord.setField(1, "FLAG", strconv.Itoa(int(flag)))
+}
+
+// SetQName sets the query template name (QNAME). Returns an error if the name
+// is empty or contains whitespace
+func (samRecord *SamRecord) SetQName(qname string) error {
+	return samRecord.setField(0, "QNAME", qname)
+}
+
+// SetRName sets the reference sequence name (RNAME), "*" if unplaced. Returns
+// an error if the name is empty or contains whitespace
+func (samRecord *SamRecord) SetRName(rname string) error {
+	return samRecord.setField(2, "RNAME", rname)
+}
+
+// SetPos sets the 1-based leftmost mapping position (POS). Returns an error if
+// the position is negative
+func (samRecord *SamRecord) SetPos(pos int) error {
+	return samRecord.setPositionField(3, "POS", pos)
+}
+
+// SetMapq sets the MAPQ. Returns an error if it is outside of 0-255
+func (samRecord *SamRecord) SetMapq(mapq int) error {
+	if mapq < 0 || mapq > MapqUnavailable {
+		return errors.New("Invalid MAPQ: '" + strconv.Itoa(mapq) + "'")
+	}
+	return samRecord.setField(4, "MAPQ", strconv.Itoa(mapq))
+}
+
+// SetCigar sets the CIGAR string, "*" if unavailable. Returns an error if it
+// cannot be parsed
+func (samRecord *SamRecord) SetCigar(cigar string) error {
+	if _, err := ParseCigar(cigar); err != nil {
+		return err
+	}
+	return samRecord.setField(5, "CIGAR", cigar)
+}
+
+// SetRNext sets the reference sequence name of the mate (RNEXT), "=" if
+// identical to RNAME. Returns an error if the name is empty or contains
+// whitespace
+func (samRecord *SamRecord) SetRNext(rnext string) error {
+	return samRecord.setField(6, "RNEXT", rnext)
+}
+
+// SetPNext sets the 1-based position of the mate (PNEXT). Returns an error if
+// the position is negative
+func (samRecord *SamRecord) SetPNext(pnext int) error {
+	return samRecord.setPositionField(7, "PNEXT", pnext)
+}
+
+// SetTLen sets the observed template length (TLEN). Returns an error if the
+// record could not be modified
+func (samRecord *SamRecord) SetTLen(tlen int) error {
+	return samRecord.setField(8, "TLEN", strconv.Itoa(tlen))
+}
+
+// SetSeq sets the segment sequence (SEQ), "*" if not stored. Returns an error
+// if the sequence is empty or contains whitespace
+func (samRecord *SamRecord) SetSeq(seq string) error {
+	return samRecord.setField(9, "SEQ", seq)
+}
+
+// SetQual sets the ASCII of base quality plus 33 (QUAL), "*" if not stored.
+// Returns an error if the qualities are empty or contain whitespace
+func (samRecord *SamRecord) SetQual(qual string) error {
+	return samRecord.setField(10, "QUAL", qual)
+}
+
+// setField replaces a field given its column position, checking the value can
+// be serialized as a single SAM field
+func (samRecord *SamRecord) setField(col int, name string, value string) error {
+	if value == "" || strings.ContainsAny(value, " \t\r\n") {
+		return errors.New("Invalid " + name + ": '" + value + "'")
+	}
+	start := 0
+	if col > 0 {
+		start = samRecord.fieldEnds[col-1] + 1
+	}
+	return samRecord.replace(start, samRecord.fieldEnds[col], value)
+}
+
+// setPositionField replaces a field holding a 1-based position, 0 if unset
+func (samRecord *SamRecord) setPositionField(col int, name string, pos int) error {
+	if pos < 0 {
+		return errors.New("Invalid " + name + ": '" + strconv.Itoa(pos) + "'")
+	}
+	return samRecord.setField(col, name, strconv.Itoa(pos))
+}
+
+// Mapq gets the parsed MAPQ. MapqUnavailable (255) indicates that the mapping
+// quality is not available
+func (samRecord *SamRecord) Mapq() (int, error) {
+	text := samRecord.getField(4)
+	mapq, err := strconv.Atoi(text)
+	if err != nil || mapq < 0 || mapq > MapqUnavailable {
+		return 0, errors.New("Invalid MAPQ: '" + text + "'")
+	}
+	return mapq, nil
+}
+
+// AlignedLength gets the number of query bases aligned to the reference, ie.
+// the total length of M, =, and X CIGAR operations
+func (samRecord *SamRecord) AlignedLength() (int, error) {
+	cigarOps, err := ParseCigar(samRecord.getField(5))
+	if err != nil {
+		return 0, err
+	}
+	return cigarAlignedLength(cigarOps), nil
+}
+
+// HasTag indicates whether the record has a tag
+func (samRecord *SamRecord) HasTag(key string) bool {
+	start, _ := samRecord.findTag(key)
+	return start >= 0
+}
+
+// Tag gets a tag parsed into its typed value
+func (samRecord *SamRecord) Tag(key string) (*SamTag, error) {
+	start, end := samRecord.findTag(key)
+	if start < 0 {
+		return nil, errors.New("Tag '" + key + "' not found for record " + samRecord.getField(0))
+	}
+	return ParseSamTag(string(samRecord.data[start:end]))
+}
+
+// typedTag gets a parsed tag, checking it is of the expected type
+func (samRecord *SamRecord) typedTag(key string, valueType byte) (*SamTag, error) {
+	samTag, err := samRecord.Tag(key)
+	if err != nil {
+		return nil, err
+	}
+	if samTag.Type != valueType {
+		return nil, errors.New("Tag '" + key + "' is of type " + string(samTag.Type) + ", not " + string(valueType))
+	}
+	return samTag, nil
+}
+
+// CharTag gets the value of a printable character ('A') tag
+func (samRecord *SamRecord) CharTag(key string) (byte, error) {
+	samTag, err := samRecord.typedTag(key, 'A')
+	if err != nil {
+		return 0, err
+	}
+	return samTag.Value.(byte), nil
+}
+
+// IntTag gets the value of an integer ('i') tag
+func (samRecord *SamRecord) IntTag(key string) (int64, error) {
+	samTag, err := samRecord.typedTag(key, 'i')
+	if err != nil {
+		return 0, err
+	}
+	return samTag.Value.(int64), nil
+}
+
+// FloatTag gets the value of a float ('f') tag
+func (samRecord *SamRecord) FloatTag(key string) (float32, error) {
+	samTag, err := samRecord.typedTag(key, 'f')
+	if err != nil {
+		return 0, err
+	}
+	return samTag.Value.(float32), nil
+}
+
+// StringTag gets the value of a string ('Z') tag
+func (samRecord *SamRecord) StringTag(key string) (string, error) {
+	samTag, err := samRecord.typedTag(key, 'Z')
+	if err != nil {
+		return "", err
+	}
+	return samTag.Value.(string), nil
+}
+
+// HexTag gets the value of a hex byte array ('H') tag
+func (samRecord *SamRecord) HexTag(key string) ([]byte, error) {
+	samTag, err := samRecord.typedTag(key, 'H')
+	if err != nil {
+		return nil, err
+	}
+	return samTag.Value.([]byte), nil
+}
+
+// ArrayTag gets the value of a numeric array ('B') tag
+func (samRecord *SamRecord) ArrayTag(key string) (*SamTagArray, error) {
+	samTag, err := samRecord.typedTag(key, 'B')
+	if err != nil {
+		return nil, err
+	}
+	return samTag.Value.(*SamTagArray), nil
+}
+
+// SetTag sets a tag, serialized canonically. An existing tag with the same key
+// is replaced in place, otherwise the tag is appended. Returns an error if the
+// record could not be modified
+func (samRecord *SamRecord) SetTag(samTag *SamTag) error {
+	start, end := samRecord.findTag(samTag.Key)
+	if start < 0 {
+		return samRecord.replace(len(samRecord.data), len(samRecord.data), "\t"+samTag.String())
+	}
+	return samRecord.replace(start, end, samTag.String())
+}
+
+// AddTag appends a tag, serialized canonically. Returns an error if the record
+// already has a tag with the same key
+func (samRecord *SamRecord) AddTag(samTag *SamTag) error {
+	if samRecord.HasTag(samTag.Key) {
+		return errors.New("Tag '" + samTag.Key + "' already exists for record " + samRecord.QName())
+	}
+	return samRecord.SetTag(samTag)
+}
+
+// ReplaceTag replaces an existing tag in place, serialized canonically.
+// Returns an error if the record does not have a tag with the same key
+func (samRecord *SamRecord) ReplaceTag(samTag *SamTag) error {
+	if !samRecord.HasTag(samTag.Key) {
+		return errors.New("Tag '" + samTag.Key + "' not found for record " + samRecord.QName())
+	}
+	return samRecord.SetTag(samTag)
+}
+
+// ReorderTags moves the tags with the given keys to the start, in that order.
+// Remaining tags follow in their existing order, and keys the record does not
+// have are ignored. Returns an error if the record could not be modified
+func (samRecord *SamRecord) ReorderTags(keys []string) error {
+	tags := samRecord.Tags()
+	tagKeys := samRecord.TagKeys()
+	moved := make([]bool, len(tags))
+	ordered := []string{}
+	for _, key := range keys {
+		for i, tagKey := range tagKeys {
+			if tagKey == key && !moved[i] {
+				ordered = append(ordered, tags[i])
+				moved[i] = true
+			}
+		}
+	}
+	for i, tag := range tags {
+		if !moved[i] {
+			ordered = append(ordered, tag)
+		}
+	}
+	if len(ordered) == 0 {
+		return nil
+	}
+	return samRecord.replace(samRecord.tagsStart()-1, len(samRecord.data), "\t"+strings.Join(ordered, "\t"))
+}
+
+// RemoveTag removes a tag, if present. Returns an error if the record could
+// not be modified
+func (samRecord *SamRecord) RemoveTag(key string) error {
+	for start, end := samRecord.findTag(key); start >= 0; start, end = samRecord.findTag(key) {
+		if err := samRecord.replace(start-1, end, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alignmentSpan computes the 0-based, half-open reference interval covered by
+// the alignment, from POS and the reference-consuming CIGAR operations. Records
+// without reference-consuming operations (eg. unmapped reads placed at their
+// mate's position) are considered to cover a single base
+func (samRecord *SamRecord) alignmentSpan() (int, int, error) {
+	pos, err := samRecord.Pos()
+	if err != nil {
+		return 0, 0, err
+	}
+	cigarOps, err := ParseCigar(samRecord.getField(5))
+	if err != nil {
+		return 0, 0, err
+	}
+	start := pos - 1
+	length := cigarReferenceLength(cigarOps)
+	if length == 0 {
+		length = 1
+	}
+	return start, start + length, nil
+}
+
+// String gets a string representation of the SamRecord
+func (samRecord *SamRecord) String() string {
+	return "[SamRecord qname=" + samRecord.getField(0) + "]"
+}
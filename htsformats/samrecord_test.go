@@ -135,18 +135,36 @@ var samRecordStringTC = []struct {
 	},
 }
 
+// newTestSamRecord constructs a SamRecord from a line known to be valid
+func newTestSamRecord(raw string) *SamRecord {
+	samRecord, _ := NewSamRecord(raw)
+	return samRecord
+}
+
 // TestNewSamRecord tests NewSamRecord function
 func TestNewSamRecord(t *testing.T) {
 	for _, tc := range newSamRecordTC {
-		samRecord := NewSamRecord(tc.raw)
-		assert.Equal(t, tc.expQname, samRecord.qname)
+		samRecord, err := NewSamRecord(tc.raw)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expQname, samRecord.getField(0))
+	}
+}
+
+// TestNewSamRecordError tests NewSamRecord function with too few fields
+func TestNewSamRecordError(t *testing.T) {
+	for _, raw := range []string{"", "r\t0\tchr1", "r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA"} {
+		samRecord, err := NewSamRecord(raw)
+		assert.Nil(t, samRecord)
+		assert.NotNil(t, err)
 	}
+	_, err := NewSamRecord("r\t0\tchr1")
+	assert.Equal(t, "Expected at least 11 tab-delimited fields, found 3", err.Error())
 }
 
 // TestEmitFields tests emitFields function
 func TestEmitFields(t *testing.T) {
 	for _, tc := range samRecordEmitFieldsTC {
-		samRecord := NewSamRecord(tc.raw)
+		samRecord, _ := NewSamRecord(tc.raw)
 		expected := strings.Split(tc.raw, "\t")[:11]
 		actual := samRecord.emitFields()
 		assert.Equal(t, expected, actual)
@@ -156,7 +174,7 @@ func TestEmitFields(t *testing.T) {
 // TestGetField tests getField function
 func TestGetField(t *testing.T) {
 	for _, tc := range samRecordGetFieldTC {
-		samRecord := NewSamRecord(tc.raw)
+		samRecord, _ := NewSamRecord(tc.raw)
 		for i := range tc.cols {
 			expVal := tc.expVals[i]
 			actualVal := samRecord.getField(tc.cols[i])
@@ -168,7 +186,7 @@ func TestGetField(t *testing.T) {
 // TestEmitTags tests emitTags function
 func TestEmitTags(t *testing.T) {
 	for _, tc := range samRecordEmitTagsTC {
-		samRecord := NewSamRecord(tc.raw)
+		samRecord, _ := NewSamRecord(tc.raw)
 		expected := strings.Split(tc.raw, "\t")[11:]
 		actual := samRecord.emitTags()
 		assert.Equal(t, expected, actual)
@@ -178,7 +196,7 @@ func TestEmitTags(t *testing.T) {
 // TestGetTag tests getTag function
 func TestGetTag(t *testing.T) {
 	for _, tc := range samRecordGetTagTC {
-		samRecord := NewSamRecord(tc.raw)
+		samRecord, _ := NewSamRecord(tc.raw)
 		for i := range tc.keys {
 			expected := tc.expVals[i]
 			actual := samRecord.getTag(tc.keys[i])
@@ -190,7 +208,192 @@ func TestGetTag(t *testing.T) {
 // TestSamRecordString tests String function
 func TestSamRecordString(t *testing.T) {
 	for _, tc := range samRecordStringTC {
-		samRecord := NewSamRecord(tc.raw)
+		samRecord, _ := NewSamRecord(tc.raw)
 		assert.Equal(t, tc.exp, samRecord.String())
 	}
 }
+
+// samRecordTypedTagsRaw record with tags of each type, for typed tag tests
+var samRecordTypedTagsRaw = "r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tNM:i:1\tXA:A:c\tXF:f:1.50\tMD:Z:0A0\tXH:H:1A\tXB:B:s,-1,1"
+
+// TestSamRecordTypedTags tests functions HasTag, Tag, CharTag, IntTag,
+// FloatTag, StringTag, HexTag, and ArrayTag
+func TestSamRecordTypedTags(t *testing.T) {
+	samRecord, _ := NewSamRecord(samRecordTypedTagsRaw)
+
+	assert.True(t, samRecord.HasTag("NM"))
+	assert.False(t, samRecord.HasTag("NH"))
+	samTag, err := samRecord.Tag("XF")
+	assert.Nil(t, err)
+	assert.Equal(t, byte('f'), samTag.Type)
+	_, err = samRecord.Tag("NH")
+	assert.NotNil(t, err)
+
+	c, err := samRecord.CharTag("XA")
+	assert.Nil(t, err)
+	assert.Equal(t, byte('c'), c)
+	i, err := samRecord.IntTag("NM")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), i)
+	f, err := samRecord.FloatTag("XF")
+	assert.Nil(t, err)
+	assert.Equal(t, float32(1.5), f)
+	s, err := samRecord.StringTag("MD")
+	assert.Nil(t, err)
+	assert.Equal(t, "0A0", s)
+	h, err := samRecord.HexTag("XH")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x1a}, h)
+	array, err := samRecord.ArrayTag("XB")
+	assert.Nil(t, err)
+	assert.Equal(t, &SamTagArray{Subtype: 's', Ints: []int64{-1, 1}}, array)
+
+	// wrong type and missing tags
+	_, err = samRecord.IntTag("MD")
+	assert.NotNil(t, err)
+	_, err = samRecord.StringTag("NM")
+	assert.NotNil(t, err)
+	_, err = samRecord.FloatTag("NH")
+	assert.NotNil(t, err)
+}
+
+// TestSamRecordSetTag tests functions SetTag and RemoveTag
+func TestSamRecordSetTag(t *testing.T) {
+	samRecord, _ := NewSamRecord(samRecordTypedTagsRaw)
+
+	// unmodified tags are emitted as parsed, set tags canonically
+	nm, _ := NewIntTag("NM", 2)
+	assert.Nil(t, samRecord.SetTag(nm))
+	nh, _ := NewIntTag("NH", 1)
+	assert.Nil(t, samRecord.SetTag(nh))
+	assert.Nil(t, samRecord.RemoveTag("XH"))
+	assert.Nil(t, samRecord.RemoveTag("ZZ"))
+	expected := []string{"NM:i:2", "XA:A:c", "XF:f:1.50", "MD:Z:0A0", "XB:B:s,-1,1", "NH:i:1"}
+	assert.Equal(t, expected, samRecord.emitTags())
+
+	i, _ := samRecord.IntTag("NM")
+	assert.Equal(t, int64(2), i)
+	assert.False(t, samRecord.HasTag("XH"))
+}
+
+// TestNewSamRecordBytes tests NewSamRecordBytes function, and that modifying
+// the record does not modify the line it was constructed over
+func TestNewSamRecordBytes(t *testing.T) {
+	line := []byte(samRecordTypedTagsRaw)
+	samRecord, err := NewSamRecordBytes(line)
+	assert.Nil(t, err)
+	assert.Equal(t, "chr1", samRecord.getField(2))
+	assert.Equal(t, "MD:Z:0A0", samRecord.getTag("MD"))
+
+	nm, _ := NewIntTag("NM", 12)
+	assert.Nil(t, samRecord.SetTag(nm))
+	assert.Nil(t, samRecord.SetFlag(16))
+	assert.Nil(t, samRecord.RemoveTag("XA"))
+	assert.Equal(t, samRecordTypedTagsRaw, string(line))
+	assert.Equal(t, "16", samRecord.getField(1))
+	assert.Equal(t, "chr1", samRecord.getField(2))
+	assert.Equal(t, []string{"NM:i:12", "XF:f:1.50", "MD:Z:0A0", "XH:H:1A", "XB:B:s,-1,1"}, samRecord.emitTags())
+
+	_, err = NewSamRecordBytes([]byte("r\t0\tchr1"))
+	assert.Equal(t, "Expected at least 11 tab-delimited fields, found 3", err.Error())
+}
+
+// TestSamRecordAccessors tests the exported field and tag accessors
+func TestSamRecordAccessors(t *testing.T) {
+	samRecord, _ := NewSamRecord("r1\t99\tchr1\t100\t60\t4M\t=\t200\t104\tACGT\tFFFF\tNM:i:0\tMD:Z:4")
+	assert.Equal(t, "r1", samRecord.QName())
+	assert.Equal(t, "chr1", samRecord.RName())
+	assert.Equal(t, "4M", samRecord.Cigar())
+	assert.Equal(t, "=", samRecord.RNext())
+	assert.Equal(t, "ACGT", samRecord.Seq())
+	assert.Equal(t, "FFFF", samRecord.Qual())
+	assert.Equal(t, []string{"NM:i:0", "MD:Z:4"}, samRecord.Tags())
+	assert.Equal(t, []string{"NM", "MD"}, samRecord.TagKeys())
+	pos, _ := samRecord.Pos()
+	assert.Equal(t, 100, pos)
+	pnext, _ := samRecord.PNext()
+	assert.Equal(t, 200, pnext)
+	tlen, _ := samRecord.TLen()
+	assert.Equal(t, 104, tlen)
+
+	assert.Nil(t, samRecord.RemoveTag("MD"))
+	assert.Equal(t, "r1\t99\tchr1\t100\t60\t4M\t=\t200\t104\tACGT\tFFFF\tNM:i:0", samRecord.Line())
+
+	samRecord, _ = NewSamRecord("r1\t4\t*\tx\t0\t*\t*\t0\t0\t*\t*")
+	assert.Equal(t, []string{}, samRecord.TagKeys())
+	_, err := samRecord.Pos()
+	assert.Equal(t, "Invalid POS: 'x'", err.Error())
+}
+
+// samRecordSetFieldErrorTC test cases for field setters given invalid values
+var samRecordSetFieldErrorTC = []struct {
+	set    func(samRecord *SamRecord) error
+	expErr string
+}{
+	{func(samRecord *SamRecord) error { return samRecord.SetQName("") }, "Invalid QNAME: ''"},
+	{func(samRecord *SamRecord) error { return samRecord.SetQName("a b") }, "Invalid QNAME: 'a b'"},
+	{func(samRecord *SamRecord) error { return samRecord.SetRName("chr\t1") }, "Invalid RNAME: 'chr\t1'"},
+	{func(samRecord *SamRecord) error { return samRecord.SetPos(-1) }, "Invalid POS: '-1'"},
+	{func(samRecord *SamRecord) error { return samRecord.SetMapq(256) }, "Invalid MAPQ: '256'"},
+	{func(samRecord *SamRecord) error { return samRecord.SetCigar("4Q") }, "Invalid CIGAR operation 'Q' in '4Q'"},
+	{func(samRecord *SamRecord) error { return samRecord.SetRNext("") }, "Invalid RNEXT: ''"},
+	{func(samRecord *SamRecord) error { return samRecord.SetPNext(-5) }, "Invalid PNEXT: '-5'"},
+	{func(samRecord *SamRecord) error { return samRecord.SetSeq("AC\nGT") }, "Invalid SEQ: 'AC\nGT'"},
+	{func(samRecord *SamRecord) error { return samRecord.SetQual("") }, "Invalid QUAL: ''"},
+}
+
+// TestSamRecordSetFields tests the exported field setters
+func TestSamRecordSetFields(t *testing.T) {
+	samRecord := newTestSamRecord("r1\t99\tchr1\t100\t60\t4M\t=\t200\t104\tACGT\tFFFF\tNM:i:0")
+	assert.Nil(t, samRecord.SetQName("r2"))
+	assert.Nil(t, samRecord.SetFlag(16))
+	assert.Nil(t, samRecord.SetRName("chr2"))
+	assert.Nil(t, samRecord.SetPos(5))
+	assert.Nil(t, samRecord.SetMapq(255))
+	assert.Nil(t, samRecord.SetCigar("2M1I1M"))
+	assert.Nil(t, samRecord.SetRNext("*"))
+	assert.Nil(t, samRecord.SetPNext(0))
+	assert.Nil(t, samRecord.SetTLen(0))
+	assert.Nil(t, samRecord.SetSeq("TTGCA"))
+	assert.Nil(t, samRecord.SetQual("*"))
+	assert.Equal(t, "r2\t16\tchr2\t5\t255\t2M1I1M\t*\t0\t0\tTTGCA\t*\tNM:i:0", samRecord.Line())
+
+	for _, tc := range samRecordSetFieldErrorTC {
+		err := tc.set(samRecord)
+		assert.Equal(t, tc.expErr, err.Error())
+	}
+	assert.Equal(t, "r2\t16\tchr2\t5\t255\t2M1I1M\t*\t0\t0\tTTGCA\t*\tNM:i:0", samRecord.Line())
+}
+
+// TestSamRecordTagMutation tests functions AddTag, ReplaceTag and ReorderTags
+func TestSamRecordTagMutation(t *testing.T) {
+	samRecord := newTestSamRecord(samRecordTypedTagsRaw)
+	nm, _ := NewIntTag("NM", 3)
+	assert.Equal(t, "Tag 'NM' already exists for record r", samRecord.AddTag(nm).Error())
+	assert.Nil(t, samRecord.ReplaceTag(nm))
+	nh, _ := NewIntTag("NH", 1)
+	assert.Equal(t, "Tag 'NH' not found for record r", samRecord.ReplaceTag(nh).Error())
+	assert.Nil(t, samRecord.AddTag(nh))
+	assert.Equal(t, []string{"NM", "XA", "XF", "MD", "XH", "XB", "NH"}, samRecord.TagKeys())
+
+	assert.Nil(t, samRecord.ReorderTags([]string{"NH", "MD", "ZZ"}))
+	assert.Equal(t, []string{"NH:i:1", "MD:Z:0A0", "NM:i:3", "XA:A:c", "XF:f:1.50", "XH:H:1A", "XB:B:s,-1,1"}, samRecord.Tags())
+
+	samRecord = newTestSamRecord("r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF")
+	assert.Nil(t, samRecord.ReorderTags([]string{"NM"}))
+	assert.Equal(t, "r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF", samRecord.Line())
+}
+
+// TestSamRecordReplaceError tests that an edit which would leave fewer than
+// the mandatory fields returns an error and leaves the record unchanged
+func TestSamRecordReplaceError(t *testing.T) {
+	line := "r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tNM:i:0"
+	samRecord := newTestSamRecord(line)
+	err := samRecord.replace(0, samRecord.fieldEnds[2], "r")
+	assert.Equal(t, "Expected at least 11 tab-delimited fields, found 10", err.Error())
+	assert.Equal(t, line, samRecord.Line())
+	assert.Equal(t, "chr1", samRecord.RName())
+	assert.Equal(t, "F", samRecord.Qual())
+	assert.Nil(t, samRecord.SetTLen(-1))
+	assert.Equal(t, "r\t0\tchr1\t1\t60\t1M\t*\t0\t-1\tA\tF\tNM:i:0", samRecord.Line())
+}
@@ -10,7 +10,6 @@ import (
 	"strings"
 
 	"github.com/ga4gh/htsget-refserver-utils/internal/htsutils"
-	"github.com/golang-collections/collections/set"
 )
 
 // samFields map of canonical field name to its column position in a SamRecord
@@ -53,6 +52,7 @@ type SamRecordEmitter struct {
 	fields        []bool
 	tags          []string
 	notags        []string
+	tagLookup     map[string]bool
 }
 
 // NewSamRecordEmitter constructs and configures a SamRecordEmitter
@@ -128,6 +128,16 @@ func (samRecordEmitter *SamRecordEmitter) setupTagsNotags(tags string, notags st
 		if tagsSet.Intersection(notagsSet).Len() > 0 {
 			return errors.New("Overlap between 'tags' and 'notags'")
 		}
+
+		// precompute whether each requested tag is emitted, so that tags are
+		// looked up without building sets per alignment
+		samRecordEmitter.tagLookup = make(map[string]bool)
+		for _, tag := range samRecordEmitter.tags {
+			samRecordEmitter.tagLookup[tag] = true
+		}
+		for _, notag := range samRecordEmitter.notags {
+			samRecordEmitter.tagLookup[notag] = false
+		}
 	}
 	return nil
 }
@@ -135,74 +145,71 @@ func (samRecordEmitter *SamRecordEmitter) setupTagsNotags(tags string, notags st
 // CustomEmit accepts an unmodified SamRecord, and returns a string representing
 // the SamRecord after modification by field and tag inclusion/exclusion
 func (samRecordEmitter *SamRecordEmitter) CustomEmit(samRecord *SamRecord) string {
+	return string(samRecordEmitter.AppendCustomEmit(nil, samRecord))
+}
 
-	customEmit := []string{}
-
-	// emit fields
-	if samRecordEmitter.emitAllFields {
-		emitFields := samRecord.emitFields()
-		customEmit = append(customEmit, emitFields...)
-	} else {
-		customEmitFields := samRecordEmitter.customEmitFields(samRecord)
-		customEmit = append(customEmit, customEmitFields...)
-	}
+// AppendCustomEmit appends the line emitted by CustomEmit, without a trailing
+// newline, to a byte buffer. Reusing the buffer across alignments avoids
+// allocating per alignment
+func (samRecordEmitter *SamRecordEmitter) AppendCustomEmit(buf []byte, samRecord *SamRecord) []byte {
+	buf = samRecordEmitter.appendCustomFields(buf, samRecord)
+	return samRecordEmitter.appendCustomTags(buf, samRecord)
+}
 
-	// emit tags
-	if samRecordEmitter.emitAllTags {
-		emitTags := samRecord.emitTags()
-		customEmit = append(customEmit, emitTags...)
-	} else {
-		customEmitTags := samRecordEmitter.customEmitTags(samRecord)
-		customEmit = append(customEmit, customEmitTags...)
+// CustomEmitRecord accepts an unmodified SamRecord, and returns a new SamRecord
+// after modification by field and tag inclusion/exclusion. If all fields and
+// tags are emitted, the SamRecord itself is returned
+func (samRecordEmitter *SamRecordEmitter) CustomEmitRecord(samRecord *SamRecord) (*SamRecord, error) {
+	if samRecordEmitter.emitAllFields && samRecordEmitter.emitAllTags {
+		return samRecord, nil
 	}
-
-	return strings.Join(customEmit, "\t")
+	return NewSamRecordBytes(samRecordEmitter.AppendCustomEmit(nil, samRecord))
 }
 
-// customEmitFields returns only the specified fields of a SamRecord, replacing
+// appendCustomFields appends the tab-delimited fields of a SamRecord, replacing
 // excluded fields with their appropriate non-specified values
-func (samRecordEmitter *SamRecordEmitter) customEmitFields(samRecord *SamRecord) []string {
+func (samRecordEmitter *SamRecordEmitter) appendCustomFields(buf []byte, samRecord *SamRecord) []byte {
 
 	// for each field, check if the corresponding column position is set to
-	// true (emit real value). If true, get and emit the real value, if false,
-	// emit the replacement value
-	customEmitFields := make([]string, 11)
-	for i := 0; i < len(samRecordEmitter.fields); i++ {
-		if samRecordEmitter.fields[i] {
-			customEmitFields[i] = samRecord.getField(i)
+	// true (emit real value). If true, emit the real value, if false, emit
+	// the replacement value
+	for i := 0; i < samRecordMinColumns; i++ {
+		if i > 0 {
+			buf = append(buf, '\t')
+		}
+		if samRecordEmitter.emitAllFields || samRecordEmitter.fields[i] {
+			buf = append(buf, samRecord.field(i)...)
 		} else {
-			customEmitFields[i] = samFieldReplacements[i]
+			buf = append(buf, samFieldReplacements[i]...)
 		}
 	}
-	return customEmitFields
+	return buf
 }
 
-// customEmitTags returns only the specified tags of a SamRecord, excluding everything
-// either not specified by 'tags' or specified by 'notags'
-func (samRecordEmitter *SamRecordEmitter) customEmitTags(samRecord *SamRecord) []string {
-
-	customEmitTags := []string{}
-	allTagKeysSet := htsutils.CreateSet(samRecord.tagKeys)
-	var tagsToEmit *set.Set
-
-	if samRecordEmitter.inclusionEmit {
-		// (inclusionEmit = true) means emit ONLY the tags specified by 'tags'
-		// get overlap between available tags in sam record, and those specified in tags
-		requestedTagsSet := htsutils.CreateSet(samRecordEmitter.tags)
-		tagsToEmit = allTagKeysSet.Intersection(requestedTagsSet)
-	} else {
-		// (inclusionEmit = false) means emit everything EXCEPT the tags specified by 'notags'
-		// get the difference between available tags in sam record, and those specified in notags
-		requestedNoTagsSet := htsutils.CreateSet(samRecordEmitter.notags)
-		tagsToEmit = allTagKeysSet.Difference(requestedNoTagsSet)
+// appendCustomTags appends only the specified tags of a SamRecord, each
+// preceded by a tab, excluding everything either not specified by 'tags' or
+// specified by 'notags'
+func (samRecordEmitter *SamRecordEmitter) appendCustomTags(buf []byte, samRecord *SamRecord) []byte {
+	if samRecordEmitter.emitAllTags {
+		return append(buf, samRecord.data[samRecord.tagsStart()-1:]...)
 	}
-
-	// for each tag in the set, lookup the tag value and emit
-	for _, tagKey := range samRecord.tagKeys {
-		if tagsToEmit.Has(tagKey) {
-			customEmitTags = append(customEmitTags, samRecord.getTag(tagKey))
+	for start := samRecord.tagsStart(); start <= len(samRecord.data); {
+		end := samRecord.tagEnd(start)
+		if samRecordEmitter.emitTag(samRecord.tagKey(start, end)) {
+			buf = append(buf, '\t')
+			buf = append(buf, samRecord.data[start:end]...)
 		}
+		start = end + 1
 	}
+	return buf
+}
 
-	return customEmitTags
+// emitTag indicates whether a tag is emitted, from the lookup table of
+// requested tags. Tags not in the table are emitted only if 'tags' was not
+// specified (inclusionEmit = false)
+func (samRecordEmitter *SamRecordEmitter) emitTag(tagKey []byte) bool {
+	if emit, ok := samRecordEmitter.tagLookup[string(tagKey)]; ok {
+		return emit
+	}
+	return !samRecordEmitter.inclusionEmit
 }
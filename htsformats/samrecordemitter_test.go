@@ -5,7 +5,10 @@
 package htsformats
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -86,7 +89,7 @@ var samRecordEmitterCustomEmitTC = []struct {
 	},
 }
 
-// samRecordEmitterCustomEmitFieldsTC test cases for customEmitFields
+// samRecordEmitterCustomEmitFieldsTC test cases for appendCustomFields
 var samRecordEmitterCustomEmitFieldsTC = []struct {
 	rawRecord, fields string
 	exp               []string
@@ -118,7 +121,7 @@ var samRecordEmitterCustomEmitFieldsTC = []struct {
 	},
 }
 
-// samRecordEmitterCustomEmitTagsTC test cases for customEmitTags
+// samRecordEmitterCustomEmitTagsTC test cases for appendCustomTags
 var samRecordEmitterCustomEmitTagsTC = []struct {
 	rawRecord, tags, notags string
 	exp                     []string
@@ -199,28 +202,126 @@ func TestSamRecordEmitterSetupTagsNotags(t *testing.T) {
 func TestSamRecordEmitterCustomEmit(t *testing.T) {
 	for _, tc := range samRecordEmitterCustomEmitTC {
 		samRecordEmitter, _ := NewSamRecordEmitter(tc.fields, tc.tags, tc.notags)
-		samRecord := NewSamRecord(tc.rawRecord)
+		samRecord := newTestSamRecord(tc.rawRecord)
 		actual := samRecordEmitter.CustomEmit(samRecord)
 		assert.Equal(t, tc.exp, actual)
 	}
 }
 
-// TestSamRecordEmitterCustomEmitFields tests customEmitFields function
+// TestSamRecordEmitterCustomEmitFields tests appendCustomFields function
 func TestSamRecordEmitterCustomEmitFields(t *testing.T) {
 	for _, tc := range samRecordEmitterCustomEmitFieldsTC {
 		samRecordEmitter, _ := NewSamRecordEmitter(tc.fields, "", "")
-		samRecord := NewSamRecord(tc.rawRecord)
-		actual := samRecordEmitter.customEmitFields(samRecord)
+		samRecord := newTestSamRecord(tc.rawRecord)
+		actual := strings.Split(string(samRecordEmitter.appendCustomFields(nil, samRecord)), "\t")
 		assert.Equal(t, tc.exp, actual)
 	}
 }
 
-// TestSamRecordEmitterCustomEmitTags tests customEmitTags function
+// TestSamRecordEmitterCustomEmitTags tests appendCustomTags function
 func TestSamRecordEmitterCustomEmitTags(t *testing.T) {
 	for _, tc := range samRecordEmitterCustomEmitTagsTC {
 		samRecordEmitter, _ := NewSamRecordEmitter("", tc.tags, tc.notags)
-		samRecord := NewSamRecord(tc.rawRecord)
-		actual := samRecordEmitter.customEmitTags(samRecord)
+		samRecord := newTestSamRecord(tc.rawRecord)
+		actual := strings.Split(string(samRecordEmitter.appendCustomTags(nil, samRecord)), "\t")[1:]
 		assert.Equal(t, tc.exp, actual)
 	}
 }
+
+// TestSamRecordEmitterCustomEmitRecord tests CustomEmitRecord function
+func TestSamRecordEmitterCustomEmitRecord(t *testing.T) {
+	for _, tc := range samRecordEmitterCustomEmitTC {
+		samRecordEmitter, _ := NewSamRecordEmitter(tc.fields, tc.tags, tc.notags)
+		samRecord := newTestSamRecord(tc.rawRecord)
+		actual, err := samRecordEmitter.CustomEmitRecord(samRecord)
+		assert.Nil(t, err)
+		assert.Equal(t, strings.Split(tc.exp, "\t")[:11], actual.emitFields())
+	}
+}
+
+// benchmarkSamRecords loads the alignments of the SAM test input
+func benchmarkSamRecords(b *testing.B) []*SamRecord {
+	data, err := ioutil.ReadFile("../data/test/input/modify-sam.sam")
+	if err != nil {
+		b.Fatal(err)
+	}
+	samRecords := []*SamRecord{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, "@") {
+			samRecords = append(samRecords, newTestSamRecord(line))
+		}
+	}
+	return samRecords
+}
+
+// benchmarkAppendCustomEmit benchmarks AppendCustomEmit with a reused buffer,
+// reporting alignments emitted per second
+func benchmarkAppendCustomEmit(b *testing.B, fields string, tags string, notags string) {
+	samRecords := benchmarkSamRecords(b)
+	samRecordEmitter, _ := NewSamRecordEmitter(fields, tags, notags)
+	buf := []byte{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		buf = samRecordEmitter.AppendCustomEmit(buf[:0], samRecords[i%len(samRecords)])
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "records/s")
+}
+
+// BenchmarkAppendCustomEmitAll benchmarks emitting all fields and tags
+func BenchmarkAppendCustomEmitAll(b *testing.B) {
+	benchmarkAppendCustomEmit(b, "", "", "")
+}
+
+// BenchmarkAppendCustomEmitCustom benchmarks emitting selected fields and tags
+func BenchmarkAppendCustomEmitCustom(b *testing.B) {
+	benchmarkAppendCustomEmit(b, "QNAME,FLAG,RNAME,POS,CIGAR", "", "MD,HI")
+}
+
+// BenchmarkNewSamRecord benchmarks parsing alignment lines, reporting
+// alignments parsed per second
+func BenchmarkNewSamRecord(b *testing.B) {
+	samRecords := benchmarkSamRecords(b)
+	lines := []string{}
+	for _, samRecord := range samRecords {
+		lines = append(lines, string(samRecord.data))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		NewSamRecord(lines[i%len(lines)])
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "records/s")
+}
+
+// BenchmarkNewSamRecordBytes benchmarks constructing records over alignment
+// lines without copying them, reporting alignments parsed per second
+func BenchmarkNewSamRecordBytes(b *testing.B) {
+	samRecords := benchmarkSamRecords(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		NewSamRecordBytes(samRecords[i%len(samRecords)].data)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "records/s")
+}
+
+// BenchmarkSamRecordAccess benchmarks constructing records and accessing the
+// fields and tags used by typical filters, reporting alignments per second
+func BenchmarkSamRecordAccess(b *testing.B) {
+	samRecords := benchmarkSamRecords(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		samRecord, _ := NewSamRecordBytes(samRecords[i%len(samRecords)].data)
+		samRecord.Flag()
+		samRecord.Mapq()
+		samRecord.HasTag("NM")
+		samRecord.IntTag("NH")
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "records/s")
+}
@@ -0,0 +1,27 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samrecordfilter defines filters deciding whether individual
+// alignments should be emitted
+package htsformats
+
+// SamRecordFilter decides whether a SamRecord should be emitted. Returns an
+// error if the record could not be evaluated
+type SamRecordFilter interface {
+	Matches(samRecord *SamRecord) (bool, error)
+}
+
+// SamRecordFilterChain a list of filters, all of which must match for a record
+// to be emitted. An empty chain matches every record
+type SamRecordFilterChain []SamRecordFilter
+
+// Matches indicates whether every filter in the chain matches the record
+func (samRecordFilterChain SamRecordFilterChain) Matches(samRecord *SamRecord) (bool, error) {
+	for _, samRecordFilter := range samRecordFilterChain {
+		matches, err := samRecordFilter.Matches(samRecord)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+	return true, nil
+}
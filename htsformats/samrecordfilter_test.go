@@ -0,0 +1,25 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samrecordfilter_test tests samrecordfilter
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSamRecordFilterChainMatches tests SamRecordFilterChain Matches function
+func TestSamRecordFilterChainMatches(t *testing.T) {
+	samRecord := newTestSamRecord("r\t0\tchr1\t101\t60\t100M\t*\t0\t0\t*\t*")
+	chr1, _ := NewRegion("chr1", RegionUnbounded, RegionUnbounded)
+	chr2, _ := NewRegion("chr2", RegionUnbounded, RegionUnbounded)
+
+	matches, _ := SamRecordFilterChain{}.Matches(samRecord)
+	assert.True(t, matches)
+	matches, _ = SamRecordFilterChain{chr1}.Matches(samRecord)
+	assert.True(t, matches)
+	matches, _ = SamRecordFilterChain{chr1, chr2}.Matches(samRecord)
+	assert.False(t, matches)
+}
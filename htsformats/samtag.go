@@ -0,0 +1,265 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samtag defines typed alignment tags (optional fields), parsed from and
+// serialized to TAG:TYPE:VALUE text
+package htsformats
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// samTagKeyPattern matches a valid two-letter tag name
+var samTagKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]$`)
+
+// samTagFloatPattern matches a valid float value, as in the SAM specification
+var samTagFloatPattern = regexp.MustCompile(`^[-+]?[0-9]*\.?[0-9]+([eE][-+]?[0-9]+)?$`)
+
+// samTagArrayRange inclusive range of values for each integer array subtype
+var samTagArrayRange = map[byte][2]int64{
+	'c': {math.MinInt8, math.MaxInt8},
+	'C': {0, math.MaxUint8},
+	's': {math.MinInt16, math.MaxInt16},
+	'S': {0, math.MaxUint16},
+	'i': {math.MinInt32, math.MaxInt32},
+	'I': {0, math.MaxUint32},
+}
+
+// SamTag a single typed tag. Value holds a byte for type 'A', int64 for 'i',
+// float32 for 'f', string for 'Z', []byte for 'H', and *SamTagArray for 'B'
+type SamTag struct {
+	Key   string
+	Type  byte
+	Value interface{}
+}
+
+// SamTagArray the value of a 'B' array tag. Integer subtypes (cCsSiI) hold
+// their values in Ints, subtype 'f' in Floats
+type SamTagArray struct {
+	Subtype byte
+	Ints    []int64
+	Floats  []float32
+}
+
+// NewCharTag constructs a printable character ('A') tag
+func NewCharTag(key string, value byte) (*SamTag, error) {
+	return newSamTag(key, 'A', value)
+}
+
+// NewIntTag constructs an integer ('i') tag
+func NewIntTag(key string, value int64) (*SamTag, error) {
+	return newSamTag(key, 'i', value)
+}
+
+// NewFloatTag constructs a single-precision float ('f') tag
+func NewFloatTag(key string, value float32) (*SamTag, error) {
+	return newSamTag(key, 'f', value)
+}
+
+// NewStringTag constructs a printable string ('Z') tag
+func NewStringTag(key string, value string) (*SamTag, error) {
+	return newSamTag(key, 'Z', value)
+}
+
+// NewHexTag constructs a byte array ('H') tag
+func NewHexTag(key string, value []byte) (*SamTag, error) {
+	return newSamTag(key, 'H', value)
+}
+
+// NewArrayTag constructs a numeric array ('B') tag
+func NewArrayTag(key string, value *SamTagArray) (*SamTag, error) {
+	return newSamTag(key, 'B', value)
+}
+
+// newSamTag constructs a SamTag, validating the key and value
+func newSamTag(key string, valueType byte, value interface{}) (*SamTag, error) {
+	samTag := &SamTag{key, valueType, value}
+	if err := samTag.validate(); err != nil {
+		return nil, err
+	}
+	return samTag, nil
+}
+
+// ParseSamTag parses a TAG:TYPE:VALUE string into a typed SamTag
+func ParseSamTag(text string) (*SamTag, error) {
+	split := strings.SplitN(text, ":", 3)
+	if len(split) != 3 || len(split[1]) != 1 {
+		return nil, errors.New("Invalid tag: '" + text + "'")
+	}
+	key, valueType, value := split[0], split[1][0], split[2]
+	invalid := errors.New("Invalid tag value: '" + text + "'")
+
+	var parsed interface{}
+	switch valueType {
+	case 'A':
+		if len(value) != 1 {
+			return nil, invalid
+		}
+		parsed = value[0]
+	case 'i':
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		parsed = i
+	case 'f':
+		f, err := parseSamTagFloat(value)
+		if err != nil {
+			return nil, invalid
+		}
+		parsed = f
+	case 'Z':
+		parsed = value
+	case 'H':
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, invalid
+		}
+		parsed = b
+	case 'B':
+		values := strings.Split(value, ",")
+		if len(values[0]) != 1 {
+			return nil, invalid
+		}
+		array := &SamTagArray{Subtype: values[0][0]}
+		for _, v := range values[1:] {
+			if array.Subtype == 'f' {
+				f, err := parseSamTagFloat(v)
+				if err != nil {
+					return nil, invalid
+				}
+				array.Floats = append(array.Floats, f)
+			} else {
+				i, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return nil, invalid
+				}
+				array.Ints = append(array.Ints, i)
+			}
+		}
+		parsed = array
+	default:
+		return nil, errors.New("Invalid tag type: '" + text + "'")
+	}
+
+	samTag := &SamTag{key, valueType, parsed}
+	if err := samTag.validate(); err != nil {
+		return nil, errors.New(err.Error() + ": '" + text + "'")
+	}
+	return samTag, nil
+}
+
+// parseSamTagFloat parses a single-precision float in SAM notation
+func parseSamTagFloat(value string) (float32, error) {
+	if !samTagFloatPattern.MatchString(value) {
+		return 0, errors.New("Invalid float: '" + value + "'")
+	}
+	f, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return 0, err
+	}
+	return float32(f), nil
+}
+
+// validate checks the key is valid, and that the value has the Go type and
+// range of values allowed for the tag type
+func (samTag *SamTag) validate() error {
+	if !samTagKeyPattern.MatchString(samTag.Key) {
+		return errors.New("Invalid tag key")
+	}
+	invalid := errors.New("Invalid value for tag type " + string(samTag.Type))
+
+	switch samTag.Type {
+	case 'A':
+		c, ok := samTag.Value.(byte)
+		if !ok || c < '!' || c > '~' {
+			return invalid
+		}
+	case 'i':
+		i, ok := samTag.Value.(int64)
+		if !ok || i < math.MinInt32 || i > math.MaxUint32 {
+			return invalid
+		}
+	case 'f':
+		f, ok := samTag.Value.(float32)
+		if !ok || math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+			return invalid
+		}
+	case 'Z':
+		s, ok := samTag.Value.(string)
+		if !ok {
+			return invalid
+		}
+		for i := 0; i < len(s); i++ {
+			if s[i] < ' ' || s[i] > '~' {
+				return invalid
+			}
+		}
+	case 'H':
+		if _, ok := samTag.Value.([]byte); !ok {
+			return invalid
+		}
+	case 'B':
+		array, ok := samTag.Value.(*SamTagArray)
+		if !ok || array == nil {
+			return invalid
+		}
+		if array.Subtype == 'f' {
+			if len(array.Ints) > 0 {
+				return invalid
+			}
+			return nil
+		}
+		valueRange, ok := samTagArrayRange[array.Subtype]
+		if !ok || len(array.Floats) > 0 {
+			return invalid
+		}
+		for _, i := range array.Ints {
+			if i < valueRange[0] || i > valueRange[1] {
+				return invalid
+			}
+		}
+	default:
+		return errors.New("Invalid tag type " + string(samTag.Type))
+	}
+	return nil
+}
+
+// formatSamTagFloat formats a single-precision float with the fewest digits
+// that represent it exactly
+func formatSamTagFloat(value float32) string {
+	return strconv.FormatFloat(float64(value), 'g', -1, 32)
+}
+
+// String serializes the tag canonically as TAG:TYPE:VALUE. Integers and
+// floats are written in their shortest form, and hex in upper case
+func (samTag *SamTag) String() string {
+	prefix := samTag.Key + ":" + string(samTag.Type) + ":"
+	switch value := samTag.Value.(type) {
+	case byte:
+		return prefix + string(value)
+	case int64:
+		return prefix + strconv.FormatInt(value, 10)
+	case float32:
+		return prefix + formatSamTagFloat(value)
+	case string:
+		return prefix + value
+	case []byte:
+		return prefix + strings.ToUpper(hex.EncodeToString(value))
+	case *SamTagArray:
+		values := []string{string(value.Subtype)}
+		for _, i := range value.Ints {
+			values = append(values, strconv.FormatInt(i, 10))
+		}
+		for _, f := range value.Floats {
+			values = append(values, formatSamTagFloat(f))
+		}
+		return prefix + strings.Join(values, ",")
+	}
+	return prefix
+}
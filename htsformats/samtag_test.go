@@ -0,0 +1,103 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samtag_test tests samtag
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parseSamTagTC test cases for ParseSamTag
+var parseSamTagTC = []struct {
+	text         string
+	expError     bool
+	expType      byte
+	expValue     interface{}
+	expCanonical string
+}{
+	{"XA:A:c", false, 'A', byte('c'), "XA:A:c"},
+	{"NM:i:0", false, 'i', int64(0), "NM:i:0"},
+	{"XS:i:+007", false, 'i', int64(7), "XS:i:7"},
+	{"Xs:i:-2147483648", false, 'i', int64(-2147483648), "Xs:i:-2147483648"},
+	{"XI:i:4294967295", false, 'i', int64(4294967295), "XI:i:4294967295"},
+	{"XF:f:1.50", false, 'f', float32(1.5), "XF:f:1.5"},
+	{"XF:f:-.25e2", false, 'f', float32(-25), "XF:f:-25"},
+	{"MD:Z:80T19", false, 'Z', "80T19", "MD:Z:80T19"},
+	{"CO:Z:with: colons and spaces", false, 'Z', "with: colons and spaces", "CO:Z:with: colons and spaces"},
+	{"XZ:Z:", false, 'Z', "", "XZ:Z:"},
+	{"XH:H:1aE3", false, 'H', []byte{0x1a, 0xe3}, "XH:H:1AE3"},
+	{"XB:B:c,-1,2", false, 'B', &SamTagArray{Subtype: 'c', Ints: []int64{-1, 2}}, "XB:B:c,-1,2"},
+	{"XB:B:I", false, 'B', &SamTagArray{Subtype: 'I'}, "XB:B:I"},
+	{"XB:B:f,1.0,2e-1", false, 'B', &SamTagArray{Subtype: 'f', Floats: []float32{1, 0.2}}, "XB:B:f,1,0.2"},
+	// invalid
+	{"NM:i", true, 0, nil, ""},
+	{"NMM:i:1", true, 0, nil, ""},
+	{"1M:i:1", true, 0, nil, ""},
+	{"XA:A:cd", true, 0, nil, ""},
+	{"XA:A: ", true, 0, nil, ""},
+	{"NM:i:1.5", true, 0, nil, ""},
+	{"NM:i:4294967296", true, 0, nil, ""},
+	{"NM:i:-2147483649", true, 0, nil, ""},
+	{"XF:f:nan", true, 0, nil, ""},
+	{"XF:f:1e40", true, 0, nil, ""},
+	{"XZ:Z:tab\there", true, 0, nil, ""},
+	{"XH:H:ABC", true, 0, nil, ""},
+	{"XB:B:c,128", true, 0, nil, ""},
+	{"XB:B:C,-1", true, 0, nil, ""},
+	{"XB:B:x,1", true, 0, nil, ""},
+	{"XB:B:f,a", true, 0, nil, ""},
+	{"XX:Q:1", true, 0, nil, ""},
+}
+
+// TestParseSamTag tests function ParseSamTag and String
+func TestParseSamTag(t *testing.T) {
+	for _, tc := range parseSamTagTC {
+		samTag, err := ParseSamTag(tc.text)
+		if tc.expError {
+			assert.NotNil(t, err, tc.text)
+			continue
+		}
+		assert.Nil(t, err, tc.text)
+		assert.Equal(t, tc.expType, samTag.Type)
+		assert.Equal(t, tc.expValue, samTag.Value)
+		assert.Equal(t, tc.expCanonical, samTag.String())
+	}
+}
+
+// TestNewSamTag tests the typed SamTag constructors
+func TestNewSamTag(t *testing.T) {
+	samTag, err := NewCharTag("XA", 'y')
+	assert.Nil(t, err)
+	assert.Equal(t, "XA:A:y", samTag.String())
+	samTag, err = NewIntTag("NM", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "NM:i:3", samTag.String())
+	samTag, err = NewFloatTag("XF", 0.1)
+	assert.Nil(t, err)
+	assert.Equal(t, "XF:f:0.1", samTag.String())
+	samTag, err = NewStringTag("MD", "100")
+	assert.Nil(t, err)
+	assert.Equal(t, "MD:Z:100", samTag.String())
+	samTag, err = NewHexTag("XH", []byte{0, 255})
+	assert.Nil(t, err)
+	assert.Equal(t, "XH:H:00FF", samTag.String())
+	samTag, err = NewArrayTag("XB", &SamTagArray{Subtype: 'S', Ints: []int64{1, 65535}})
+	assert.Nil(t, err)
+	assert.Equal(t, "XB:B:S,1,65535", samTag.String())
+
+	_, err = NewIntTag("NMM", 3)
+	assert.NotNil(t, err)
+	_, err = NewIntTag("NM", 1<<32)
+	assert.NotNil(t, err)
+	_, err = NewStringTag("MD", "a\nb")
+	assert.NotNil(t, err)
+	_, err = NewArrayTag("XB", nil)
+	assert.NotNil(t, err)
+	_, err = NewArrayTag("XB", &SamTagArray{Subtype: 'f', Ints: []int64{1}})
+	assert.NotNil(t, err)
+	_, err = NewArrayTag("XB", &SamTagArray{Subtype: 'i', Floats: []float32{1}})
+	assert.NotNil(t, err)
+}
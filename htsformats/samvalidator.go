@@ -0,0 +1,217 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samvalidator checks header and alignment lines against the field
+// formats and ranges of the SAM specification, collecting violations
+package htsformats
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// samQnamePattern valid QNAME, as in the SAM specification
+var samQnamePattern = regexp.MustCompile(`^[!-?A-~]{1,254}$`)
+
+// samReferenceNamePattern valid RNAME/RNEXT reference name, as in the SAM
+// specification
+var samReferenceNamePattern = regexp.MustCompile(`^[0-9A-Za-z!#$%&+./:;?@^_|~-][0-9A-Za-z!#$%&*+./:;=?@^_|~-]*$`)
+
+// samSeqPattern valid SEQ, as in the SAM specification
+var samSeqPattern = regexp.MustCompile(`^[A-Za-z=.]+$`)
+
+// samQualPattern valid QUAL, as in the SAM specification
+var samQualPattern = regexp.MustCompile(`^[!-~]+$`)
+
+// SamViolation a single violation of the SAM specification, at a 1-based line
+// number. Field is the name of the offending field (eg. "CIGAR"), tag (eg.
+// "NM"), or "HEADER"/"LINE" for violations of a whole line
+type SamViolation struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// SamValidator validates header and alignment lines. Alignments are checked
+// against the reference dictionary of the header, if present
+type SamValidator struct {
+	references map[string]int
+}
+
+// NewSamValidator constructs a SamValidator
+func NewSamValidator() *SamValidator {
+	samValidator := new(SamValidator)
+	samValidator.references = make(map[string]int)
+	return samValidator
+}
+
+// ValidateHeader validates header lines, the first at the given line number,
+// and registers the reference dictionary for validating alignments
+func (samValidator *SamValidator) ValidateHeader(firstLine int, headerLines []string) []SamViolation {
+	violations := []SamViolation{}
+	samHeader := new(SamHeader)
+	for i, line := range headerLines {
+		samHeaderRecord, err := NewSamHeaderRecord(line)
+		if err == nil {
+			err = samHeaderRecord.validate()
+		}
+		if err != nil {
+			violations = append(violations, SamViolation{firstLine + i, "HEADER", err.Error()})
+			continue
+		}
+		samHeader.Records = append(samHeader.Records, samHeaderRecord)
+	}
+
+	// whole header checks, eg. duplicate ids, only if all lines are valid
+	if len(violations) == 0 {
+		if err := samHeader.Validate(); err != nil {
+			violations = append(violations, SamViolation{firstLine, "HEADER", err.Error()})
+		}
+	}
+
+	samValidator.references = make(map[string]int)
+	for _, samHeaderRecord := range samHeader.RecordsOfType("SQ") {
+		name, _ := samHeaderRecord.Get("SN")
+		if length, err := samHeaderRecord.GetInt("LN"); err == nil {
+			samValidator.references[name] = length
+		}
+	}
+	return violations
+}
+
+// ValidateAlignment validates a single alignment line
+func (samValidator *SamValidator) ValidateAlignment(lineNumber int, line string) []SamViolation {
+	violations := []SamViolation{}
+	violation := func(field string, message string) {
+		violations = append(violations, SamViolation{lineNumber, field, message})
+	}
+
+	fields := strings.Split(line, "\t")
+	if len(fields) < samRecordMinColumns {
+		violation("LINE", "Expected at least "+strconv.Itoa(samRecordMinColumns)+" tab-delimited fields, found "+strconv.Itoa(len(fields)))
+		return violations
+	}
+	qname, rname, cigar, rnext, seq, qual := fields[0], fields[2], fields[5], fields[6], fields[9], fields[10]
+
+	if qname != "*" && !samQnamePattern.MatchString(qname) {
+		violation("QNAME", "Invalid QNAME '"+qname+"', expected 1-254 characters in [!-?A-~]")
+	}
+	samValidator.validateInt(fields[1], "FLAG", 0, math.MaxUint16, violation)
+	samValidator.validateReference(rname, "RNAME", fields[3], "POS", true, violation)
+	samValidator.validateInt(fields[4], "MAPQ", 0, MapqUnavailable, violation)
+
+	if rnext == "=" {
+		if rname == "*" {
+			violation("RNEXT", "RNEXT '=' refers to the same reference as RNAME, but RNAME is '*'")
+		} else {
+			// the reference name itself is already validated as RNAME
+			samValidator.validateReference(rname, "RNEXT", fields[7], "PNEXT", false, violation)
+		}
+	} else {
+		samValidator.validateReference(rnext, "RNEXT", fields[7], "PNEXT", true, violation)
+	}
+	samValidator.validateInt(fields[8], "TLEN", -math.MaxInt32, math.MaxInt32, violation)
+
+	if seq != "*" && !samSeqPattern.MatchString(seq) {
+		violation("SEQ", "Invalid SEQ, expected '*' or characters in [A-Za-z=.]")
+	}
+	if qual != "*" {
+		if !samQualPattern.MatchString(qual) {
+			violation("QUAL", "Invalid QUAL, expected '*' or characters in [!-~]")
+		} else if seq == "*" {
+			violation("QUAL", "QUAL is present but SEQ is '*'")
+		} else if len(qual) != len(seq) {
+			violation("QUAL", "QUAL length "+strconv.Itoa(len(qual))+" differs from SEQ length "+strconv.Itoa(len(seq)))
+		}
+	}
+
+	if message := validateCigar(cigar, seq); message != "" {
+		violation("CIGAR", message)
+	}
+
+	seen := make(map[string]bool)
+	for _, tag := range fields[11:] {
+		samTag, err := ParseSamTag(tag)
+		if err != nil {
+			violation("TAG", err.Error())
+			continue
+		}
+		if seen[samTag.Key] {
+			violation(samTag.Key, "Duplicate tag '"+samTag.Key+"'")
+		}
+		seen[samTag.Key] = true
+	}
+	return violations
+}
+
+// validateInt checks a field is an integer within an inclusive range
+func (samValidator *SamValidator) validateInt(value string, field string, min int64, max int64, violation func(string, string)) {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		violation(field, "Invalid "+field+" '"+value+"', expected an integer")
+	} else if i < min || i > max {
+		violation(field, field+" "+value+" out of range ["+strconv.FormatInt(min, 10)+", "+strconv.FormatInt(max, 10)+"]")
+	}
+}
+
+// validateReference checks a reference name, if checkName is set, and the
+// position on it. If the header has a reference dictionary, the name must be
+// present, and the position must not exceed the reference length
+func (samValidator *SamValidator) validateReference(name string, nameField string, pos string, posField string, checkName bool, violation func(string, string)) {
+	samValidator.validateInt(pos, posField, 0, math.MaxInt32, violation)
+	if name == "*" {
+		return
+	}
+	if !samReferenceNamePattern.MatchString(name) {
+		if checkName {
+			violation(nameField, "Invalid "+nameField+" '"+name+"'")
+		}
+		return
+	}
+	if len(samValidator.references) == 0 {
+		return
+	}
+	length, ok := samValidator.references[name]
+	if !ok {
+		if checkName {
+			violation(nameField, nameField+" '"+name+"' not found in @SQ header lines")
+		}
+		return
+	}
+	if i, err := strconv.Atoi(pos); err == nil && i > length {
+		violation(posField, posField+" "+pos+" exceeds length "+strconv.Itoa(length)+" of reference '"+name+"'")
+	}
+}
+
+// validateCigar checks the CIGAR grammar, the placement of clipping
+// operations, and that the query length matches the SEQ length. Returns an
+// empty message if valid
+func validateCigar(cigar string, seq string) string {
+	cigarOps, err := ParseCigar(cigar)
+	if err != nil {
+		return err.Error()
+	}
+	for i, cigarOp := range cigarOps {
+		// H may only be first or last, and S may only have H between it and
+		// the ends
+		switch cigarOp.Op {
+		case 'H':
+			if i != 0 && i != len(cigarOps)-1 {
+				return "CIGAR '" + cigar + "' has H not at either end"
+			}
+		case 'S':
+			before, after := cigarOps[:i], cigarOps[i+1:]
+			leading := len(before) == 0 || (len(before) == 1 && before[0].Op == 'H')
+			trailing := len(after) == 0 || (len(after) == 1 && after[0].Op == 'H')
+			if !leading && !trailing {
+				return "CIGAR '" + cigar + "' has S not at either end"
+			}
+		}
+	}
+	if len(cigarOps) > 0 && seq != "*" && cigarQueryLength(cigarOps) != len(seq) {
+		return "CIGAR query length " + strconv.Itoa(cigarQueryLength(cigarOps)) + " differs from SEQ length " + strconv.Itoa(len(seq))
+	}
+	return ""
+}
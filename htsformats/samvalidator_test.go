@@ -0,0 +1,153 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samvalidator_test tests samvalidator
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// samValidatorHeader header with a reference dictionary for alignment tests
+var samValidatorHeader = []string{
+	"@HD\tVN:1.6\tSO:coordinate",
+	"@SQ\tSN:chr1\tLN:100",
+	"@SQ\tSN:chr2\tLN:50",
+}
+
+// validateHeaderTC test cases for ValidateHeader
+var validateHeaderTC = []struct {
+	headerLines   []string
+	expViolations []SamViolation
+}{
+	{samValidatorHeader, []SamViolation{}},
+	{[]string{}, []SamViolation{}},
+	{
+		[]string{"@HD\tVN:1.6", "@SQ\tSN:chr1", "@XX\tAB:c"},
+		[]SamViolation{
+			{2, "HEADER", "@SQ line missing required tag 'LN': '@SQ\tSN:chr1'"},
+			{3, "HEADER", "Invalid header record type '@XX'"},
+		},
+	},
+	{
+		[]string{"@SQ\tSN:chr1\tLN:100", "@HD\tVN:1.6"},
+		[]SamViolation{{1, "HEADER", "@HD must be the first header line"}},
+	},
+}
+
+// TestSamValidatorValidateHeader tests ValidateHeader function
+func TestSamValidatorValidateHeader(t *testing.T) {
+	for _, tc := range validateHeaderTC {
+		samValidator := NewSamValidator()
+		assert.Equal(t, tc.expViolations, samValidator.ValidateHeader(1, tc.headerLines), tc.headerLines)
+	}
+}
+
+// validateAlignmentTC test cases for ValidateAlignment, against a header with
+// samValidatorHeader
+var validateAlignmentTC = []struct {
+	line          string
+	expViolations []SamViolation
+}{
+	// valid
+	{"r1\t0\tchr1\t1\t60\t2S3M\t=\t96\t0\tACGTA\tFFFFF\tNM:i:0\tMD:Z:3", []SamViolation{}},
+	{"r1\t4\t*\t0\t255\t*\t*\t0\t0\t*\t*", []SamViolation{}},
+	{"r1\t0\tchr1\t100\t60\t5H1M5H\tchr2\t50\t0\tA\t*", []SamViolation{}},
+	// line
+	{"r1\t0\tchr1", []SamViolation{{7, "LINE", "Expected at least 11 tab-delimited fields, found 3"}}},
+	// fields
+	{
+		"r 1\t65536\tchr1\t-1\t256\t*\t*\t0\t2147483648\t*\t*",
+		[]SamViolation{
+			{7, "QNAME", "Invalid QNAME 'r 1', expected 1-254 characters in [!-?A-~]"},
+			{7, "FLAG", "FLAG 65536 out of range [0, 65535]"},
+			{7, "POS", "POS -1 out of range [0, 2147483647]"},
+			{7, "MAPQ", "MAPQ 256 out of range [0, 255]"},
+			{7, "TLEN", "TLEN 2147483648 out of range [-2147483647, 2147483647]"},
+		},
+	},
+	{
+		"r1\tx\tchr1\t1\t60\t*\t*\t0\t0\t*\t*",
+		[]SamViolation{{7, "FLAG", "Invalid FLAG 'x', expected an integer"}},
+	},
+	// references
+	{
+		"r1\t0\tchr3\t1\t60\t*\tchr1\t101\t0\t*\t*",
+		[]SamViolation{
+			{7, "RNAME", "RNAME 'chr3' not found in @SQ header lines"},
+			{7, "PNEXT", "PNEXT 101 exceeds length 100 of reference 'chr1'"},
+		},
+	},
+	{
+		"r1\t0\tchr2\t51\t60\t*\t=\t51\t0\t*\t*",
+		[]SamViolation{
+			{7, "POS", "POS 51 exceeds length 50 of reference 'chr2'"},
+			{7, "PNEXT", "PNEXT 51 exceeds length 50 of reference 'chr2'"},
+		},
+	},
+	{
+		"r1\t0\t*\t0\t60\t*\t=\t0\t0\t*\t*",
+		[]SamViolation{{7, "RNEXT", "RNEXT '=' refers to the same reference as RNAME, but RNAME is '*'"}},
+	},
+	{
+		"r1\t0\t*chr1\t0\t60\t*\t*\t0\t0\t*\t*",
+		[]SamViolation{{7, "RNAME", "Invalid RNAME '*chr1'"}},
+	},
+	// SEQ and QUAL
+	{
+		"r1\t0\tchr1\t1\t60\t*\t*\t0\t0\tAC-T\tFF F",
+		[]SamViolation{
+			{7, "SEQ", "Invalid SEQ, expected '*' or characters in [A-Za-z=.]"},
+			{7, "QUAL", "Invalid QUAL, expected '*' or characters in [!-~]"},
+		},
+	},
+	{
+		"r1\t0\tchr1\t1\t60\t*\t*\t0\t0\t*\tF",
+		[]SamViolation{{7, "QUAL", "QUAL is present but SEQ is '*'"}},
+	},
+	{
+		"r1\t0\tchr1\t1\t60\t*\t*\t0\t0\tAC\tF",
+		[]SamViolation{{7, "QUAL", "QUAL length 1 differs from SEQ length 2"}},
+	},
+	// CIGAR
+	{
+		"r1\t0\tchr1\t1\t60\t2M\t*\t0\t0\tACG\t*",
+		[]SamViolation{{7, "CIGAR", "CIGAR query length 2 differs from SEQ length 3"}},
+	},
+	{
+		"r1\t0\tchr1\t1\t60\t1M1H1M\t*\t0\t0\tAC\t*",
+		[]SamViolation{{7, "CIGAR", "CIGAR '1M1H1M' has H not at either end"}},
+	},
+	{
+		"r1\t0\tchr1\t1\t60\t1M1S1M\t*\t0\t0\tACG\t*",
+		[]SamViolation{{7, "CIGAR", "CIGAR '1M1S1M' has S not at either end"}},
+	},
+	// tags
+	{
+		"r1\t0\tchr1\t1\t60\t*\t*\t0\t0\t*\t*\tNM:i:1\tNM:i:2\tXX\tXY:i:x",
+		[]SamViolation{
+			{7, "NM", "Duplicate tag 'NM'"},
+			{7, "TAG", "Invalid tag: 'XX'"},
+			{7, "TAG", "Invalid tag value: 'XY:i:x'"},
+		},
+	},
+}
+
+// TestSamValidatorValidateAlignment tests ValidateAlignment function
+func TestSamValidatorValidateAlignment(t *testing.T) {
+	samValidator := NewSamValidator()
+	samValidator.ValidateHeader(1, samValidatorHeader)
+	for _, tc := range validateAlignmentTC {
+		assert.Equal(t, tc.expViolations, samValidator.ValidateAlignment(7, tc.line), tc.line)
+	}
+}
+
+// TestSamValidatorNoReferences tests ValidateAlignment function without a
+// reference dictionary, in which case reference names are not checked
+func TestSamValidatorNoReferences(t *testing.T) {
+	samValidator := NewSamValidator()
+	samValidator.ValidateHeader(1, []string{"@HD\tVN:1.6"})
+	assert.Equal(t, []SamViolation{}, samValidator.ValidateAlignment(2, "r1\t0\tchr3\t1000\t60\t*\t=\t1\t0\t*\t*"))
+}
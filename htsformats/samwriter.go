@@ -0,0 +1,53 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samwriter serializes a SAM header and SamRecords as SAM text
+package htsformats
+
+import (
+	"io"
+)
+
+// SamWriter writes a SAM header and alignments as SAM text, one line each
+type SamWriter struct {
+	writer io.Writer
+	buf    []byte
+}
+
+// NewSamWriter constructs a SamWriter over an output stream
+func NewSamWriter(writer io.Writer) *SamWriter {
+	samWriter := new(SamWriter)
+	samWriter.writer = writer
+	return samWriter
+}
+
+// WriteHeader writes the header lines. Returns an error, without writing, if
+// any line is not a valid header line
+func (samWriter *SamWriter) WriteHeader(headerLines []string) error {
+	if _, err := NewSamHeader(headerLines); err != nil {
+		return err
+	}
+	samWriter.buf = samWriter.buf[:0]
+	for _, line := range headerLines {
+		samWriter.buf = append(samWriter.buf, line...)
+		samWriter.buf = append(samWriter.buf, '\n')
+	}
+	_, err := samWriter.writer.Write(samWriter.buf)
+	return err
+}
+
+// Write writes a single alignment, including any modifications made to it
+func (samWriter *SamWriter) Write(samRecord *SamRecord) error {
+	samWriter.buf = append(samWriter.buf[:0], samRecord.data...)
+	samWriter.buf = append(samWriter.buf, '\n')
+	_, err := samWriter.writer.Write(samWriter.buf)
+	return err
+}
+
+// Close closes the underlying stream if it implements io.Closer
+func (samWriter *SamWriter) Close() error {
+	if closer, ok := samWriter.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module samwriter_test tests samwriter
+package htsformats
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSamWriterWrite tests that SamWriter writes the header and modified
+// records as SAM text, which is valid by SamValidator
+func TestSamWriterWrite(t *testing.T) {
+	var output bytes.Buffer
+	samWriter := NewSamWriter(&output)
+	assert.Nil(t, samWriter.WriteHeader(bamWriterHeader))
+
+	samRecord := newTestSamRecord("r001\t99\tchr1\t7\t30\t8M\t=\t37\t39\tTTAGATAA\t*\tNM:i:1\tMD:Z:7A0")
+	assert.Nil(t, samRecord.SetRName("chr2"))
+	assert.Nil(t, samRecord.SetPos(100))
+	assert.Nil(t, samRecord.SetMapq(60))
+	assert.Nil(t, samRecord.SetTLen(-39))
+	nh, _ := NewIntTag("NH", 1)
+	assert.Nil(t, samRecord.AddTag(nh))
+	assert.Nil(t, samRecord.ReorderTags([]string{"MD"}))
+	assert.Nil(t, samWriter.Write(samRecord))
+	assert.Nil(t, samWriter.Write(newTestSamRecord("r002\t4\t*\t0\t0\t*\t*\t0\t0\tACGTN\t#####")))
+	assert.Nil(t, samWriter.Close())
+
+	expected := "@HD\tVN:1.6\tSO:unsorted\n@SQ\tSN:chr1\tLN:195471971\n@SQ\tSN:chr2\tLN:182113224\n" +
+		"r001\t99\tchr2\t100\t60\t8M\t=\t37\t-39\tTTAGATAA\t*\tMD:Z:7A0\tNM:i:1\tNH:i:1\n" +
+		"r002\t4\t*\t0\t0\t*\t*\t0\t0\tACGTN\t#####\n"
+	assert.Equal(t, expected, output.String())
+
+	samValidator := NewSamValidator()
+	assert.Equal(t, []SamViolation{}, samValidator.ValidateHeader(1, bamWriterHeader))
+	lines := bytes.Split(output.Bytes()[:output.Len()-1], []byte("\n"))
+	for i, line := range lines[len(bamWriterHeader):] {
+		assert.Equal(t, []SamViolation{}, samValidator.ValidateAlignment(i+4, string(line)))
+	}
+}
+
+// TestSamWriterHeaderError tests that SamWriter does not write an invalid
+// header
+func TestSamWriterHeaderError(t *testing.T) {
+	var output bytes.Buffer
+	err := NewSamWriter(&output).WriteHeader([]string{"@HD\tVN:1.6", "chr1"})
+	assert.Equal(t, "Invalid header line: 'chr1'", err.Error())
+	assert.Equal(t, 0, output.Len())
+}
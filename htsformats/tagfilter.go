@@ -0,0 +1,162 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module tagfilter defines SamRecordFilters selecting alignments by the
+// presence or typed value of their tags
+package htsformats
+
+import (
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tagComparisonPattern matches a tag comparison expression, eg. 'NM<=2'
+var tagComparisonPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9])(==|!=|<=|>=|=|<|>)(.*)$`)
+
+// TagExistsFilter matches alignments having a tag, regardless of its value
+type TagExistsFilter struct {
+	key string
+}
+
+// NewTagExistsFilter constructs a TagExistsFilter for a two-letter tag name
+func NewTagExistsFilter(key string) (*TagExistsFilter, error) {
+	if !samTagKeyPattern.MatchString(key) {
+		return nil, errors.New("Invalid tag key: '" + key + "'")
+	}
+	tagExistsFilter := new(TagExistsFilter)
+	tagExistsFilter.key = key
+	return tagExistsFilter, nil
+}
+
+// Matches indicates whether the alignment has the tag
+func (tagExistsFilter *TagExistsFilter) Matches(samRecord *SamRecord) (bool, error) {
+	return samRecord.HasTag(tagExistsFilter.key), nil
+}
+
+// TagValueFilter matches alignments having a tag of exactly the given type and
+// value, eg. 'NH:i:1'. Values are compared in canonical form, so 'NH:i:01'
+// matches 'NH:i:1'
+type TagValueFilter struct {
+	samTag *SamTag
+}
+
+// NewTagValueFilter constructs a TagValueFilter from a TAG:TYPE:VALUE string
+func NewTagValueFilter(text string) (*TagValueFilter, error) {
+	samTag, err := ParseSamTag(text)
+	if err != nil {
+		return nil, err
+	}
+	tagValueFilter := new(TagValueFilter)
+	tagValueFilter.samTag = samTag
+	return tagValueFilter, nil
+}
+
+// Matches indicates whether the alignment's tag has the required type and
+// value. Alignments without the tag do not match
+func (tagValueFilter *TagValueFilter) Matches(samRecord *SamRecord) (bool, error) {
+	if !samRecord.HasTag(tagValueFilter.samTag.Key) {
+		return false, nil
+	}
+	samTag, err := samRecord.Tag(tagValueFilter.samTag.Key)
+	if err != nil {
+		return false, err
+	}
+	return samTag.String() == tagValueFilter.samTag.String(), nil
+}
+
+// TagComparisonFilter matches alignments whose tag value compares to a given
+// value, eg. 'NM<=2'. Integer and float tags are compared numerically, and
+// character, string and hex tags lexically. A non-numeric value is only equal
+// to character, string and hex tags
+type TagComparisonFilter struct {
+	key      string
+	operator string
+	value    string
+	number   float64
+	numeric  bool
+}
+
+// NewTagComparisonFilter constructs a TagComparisonFilter from an expression
+// of the form TAG<op>VALUE, where op is one of ==, =, !=, <, <=, >, >=.
+// Ordering operators require a numeric value
+func NewTagComparisonFilter(expression string) (*TagComparisonFilter, error) {
+	match := tagComparisonPattern.FindStringSubmatch(expression)
+	if match == nil {
+		return nil, errors.New("Invalid tag filter: '" + expression + "'")
+	}
+	tagComparisonFilter := new(TagComparisonFilter)
+	tagComparisonFilter.key = match[1]
+	tagComparisonFilter.operator = match[2]
+	if tagComparisonFilter.operator == "=" {
+		tagComparisonFilter.operator = "=="
+	}
+	tagComparisonFilter.value = match[3]
+	number, err := strconv.ParseFloat(match[3], 64)
+	if err == nil {
+		tagComparisonFilter.number = number
+		tagComparisonFilter.numeric = true
+	} else if tagComparisonFilter.operator != "==" && tagComparisonFilter.operator != "!=" {
+		return nil, errors.New("Tag filter operator '" + tagComparisonFilter.operator + "' requires a numeric value: '" + expression + "'")
+	}
+	return tagComparisonFilter, nil
+}
+
+// Matches indicates whether the alignment's tag value satisfies the comparison.
+// Alignments without the tag do not match
+func (tagComparisonFilter *TagComparisonFilter) Matches(samRecord *SamRecord) (bool, error) {
+	key := tagComparisonFilter.key
+	if !samRecord.HasTag(key) {
+		return false, nil
+	}
+	samTag, err := samRecord.Tag(key)
+	if err != nil {
+		return false, err
+	}
+
+	var cmp int
+	switch value := samTag.Value.(type) {
+	case int64, float32:
+		if !tagComparisonFilter.numeric {
+			cmp = 1
+			break
+		}
+		number := tagComparisonFilter.number
+		var tagNumber float64
+		if i, ok := value.(int64); ok {
+			tagNumber = float64(i)
+		} else {
+			tagNumber = float64(value.(float32))
+		}
+		switch {
+		case tagNumber < number:
+			cmp = -1
+		case tagNumber > number:
+			cmp = 1
+		}
+	case byte:
+		cmp = strings.Compare(string(value), tagComparisonFilter.value)
+	case string:
+		cmp = strings.Compare(value, tagComparisonFilter.value)
+	case []byte:
+		cmp = strings.Compare(strings.ToUpper(hex.EncodeToString(value)), strings.ToUpper(tagComparisonFilter.value))
+	default:
+		return false, errors.New("Tag filter cannot compare array tag '" + key + "'")
+	}
+
+	switch tagComparisonFilter.operator {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
@@ -0,0 +1,109 @@
+// Package htsformats contains objects modeling entities in genomic file formats
+// and associated behaviors
+//
+// Module tagfilter_test tests tagfilter
+package htsformats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tagFilterRecordRaw record with tags of each type, for tag filter tests
+var tagFilterRecordRaw = "r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tNH:i:1\tNM:i:2\tXF:f:0.5\tXA:A:+\tRG:Z:grp1\tXH:H:1a\tXB:B:c,1"
+
+// tagFilterTC test cases for TagExistsFilter, TagValueFilter and
+// TagComparisonFilter, constructed by the given function
+var tagFilterTC = []struct {
+	newFilter  func(string) (SamRecordFilter, error)
+	text       string
+	expError   bool
+	expMatches bool
+}{
+	{newTagExistsFilterTC, "RG", false, true},
+	{newTagExistsFilterTC, "XS", false, false},
+	{newTagExistsFilterTC, "RGG", true, false},
+	{newTagValueFilterTC, "NH:i:1", false, true},
+	{newTagValueFilterTC, "NH:i:+01", false, true},
+	{newTagValueFilterTC, "NH:i:2", false, false},
+	{newTagValueFilterTC, "NH:Z:1", false, false},
+	{newTagValueFilterTC, "XF:f:.50", false, true},
+	{newTagValueFilterTC, "XH:H:1A", false, true},
+	{newTagValueFilterTC, "XS:A:+", false, false},
+	{newTagValueFilterTC, "NH:i", true, false},
+	{newTagComparisonFilterTC, "NM<=2", false, true},
+	{newTagComparisonFilterTC, "NM<2", false, false},
+	{newTagComparisonFilterTC, "NM>1", false, true},
+	{newTagComparisonFilterTC, "NM>=3", false, false},
+	{newTagComparisonFilterTC, "NM==2", false, true},
+	{newTagComparisonFilterTC, "NM=2", false, true},
+	{newTagComparisonFilterTC, "NM!=2", false, false},
+	{newTagComparisonFilterTC, "XF<0.75", false, true},
+	{newTagComparisonFilterTC, "XF>1e-1", false, true},
+	{newTagComparisonFilterTC, "RG==grp1", false, true},
+	{newTagComparisonFilterTC, "RG<grp2", true, false},
+	{newTagComparisonFilterTC, "RG!=grp2", false, true},
+	{newTagComparisonFilterTC, "XA!=-", false, true},
+	{newTagComparisonFilterTC, "XH==1A", false, true},
+	{newTagComparisonFilterTC, "XS>0", false, false},
+	{newTagComparisonFilterTC, "NM", true, false},
+	{newTagComparisonFilterTC, "N<=2", true, false},
+	{newTagComparisonFilterTC, "NM~2", true, false},
+	{newTagComparisonFilterTC, "NM<=abc", true, false},
+	{newTagComparisonFilterTC, "XS<abc", true, false},
+	{newTagComparisonFilterTC, "NM==abc", false, false},
+	{newTagComparisonFilterTC, "XF!=abc", false, true},
+	{newTagComparisonFilterTC, "NH==", false, false},
+	{newTagComparisonFilterTC, "NH<", true, false},
+}
+
+// newTagExistsFilterTC wraps NewTagExistsFilter for tagFilterTC
+func newTagExistsFilterTC(text string) (SamRecordFilter, error) {
+	return NewTagExistsFilter(text)
+}
+
+// newTagValueFilterTC wraps NewTagValueFilter for tagFilterTC
+func newTagValueFilterTC(text string) (SamRecordFilter, error) {
+	return NewTagValueFilter(text)
+}
+
+// newTagComparisonFilterTC wraps NewTagComparisonFilter for tagFilterTC
+func newTagComparisonFilterTC(text string) (SamRecordFilter, error) {
+	return NewTagComparisonFilter(text)
+}
+
+// TestTagFilterMatches tests the Matches function of all tag filters
+func TestTagFilterMatches(t *testing.T) {
+	samRecord := newTestSamRecord(tagFilterRecordRaw)
+	for _, tc := range tagFilterTC {
+		filter, err := tc.newFilter(tc.text)
+		if tc.expError {
+			assert.NotNil(t, err, tc.text)
+			continue
+		}
+		assert.Nil(t, err, tc.text)
+		matches, err := filter.Matches(samRecord)
+		assert.Nil(t, err, tc.text)
+		assert.Equal(t, tc.expMatches, matches, tc.text)
+	}
+}
+
+// TestTagComparisonFilterMatchesError tests TagComparisonFilter Matches
+// function for comparisons that cannot be evaluated. Non-numeric values for
+// ordering operators are rejected on construction
+func TestTagComparisonFilterMatchesError(t *testing.T) {
+	samRecord := newTestSamRecord(tagFilterRecordRaw)
+	filter, err := NewTagComparisonFilter("XB>0")
+	assert.Nil(t, err)
+	_, err = filter.Matches(samRecord)
+	assert.NotNil(t, err)
+
+	_, err = NewTagComparisonFilter("XS<abc")
+	assert.Equal(t, "Tag filter operator '<' requires a numeric value: 'XS<abc'", err.Error())
+
+	// malformed tag values in the record
+	filter, _ = NewTagComparisonFilter("NM<1")
+	_, err = filter.Matches(newTestSamRecord("r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\tNM:i:x"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,21 @@
+// Package htsrunners contains cli subcommands
+//
+// Module flags defines custom command line flag types
+package htsrunners
+
+import "strings"
+
+// repeatedFlag a string flag that may be specified multiple times, collecting
+// each value in order. Implements flag.Value
+type repeatedFlag []string
+
+// String gets the comma-delimited flag values
+func (values *repeatedFlag) String() string {
+	return strings.Join(*values, ",")
+}
+
+// Set appends a value each time the flag is specified
+func (values *repeatedFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
@@ -12,7 +12,10 @@ Usage:
 htsget-refserver-utils <COMMAND> <ARG1> <ARG2> ...
 
 Commands:
-modify-sam	include/exclude fields and tags from SAM stdin stream
+modify-sam	include/exclude fields and tags from SAM/BAM stdin stream
+validate-sam	check SAM/BAM stdin stream against the SAM specification, reporting violations as JSON
+ticket	construct an htsget ticket (JSON response) for a SAM/BAM file
+index	build a CSI index for a coordinate-sorted BAM file
 `
 
 // Help prints command help message
@@ -0,0 +1,61 @@
+// Package htsrunners contains cli subcommands
+//
+// Module index contains the index subcommand, in which a CSI index is built
+// for a coordinate-sorted BAM file
+package htsrunners
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
+)
+
+// Index runner for 'index' subcommand. Writes a CSI index of a BAM file, by
+// default alongside it with the .csi extension
+func Index(args []string) int {
+
+	// parses cli args
+	pathPtr := flag.String("path", "", "path of the coordinate-sorted BAM file")
+	outputPtr := flag.String("output", "", "path of the CSI index, defaults to the BAM path with .csi appended")
+	minShiftPtr := flag.Int("min-shift", htsformats.CsiDefaultMinShift, "log2 of the size of the smallest bins")
+	depthPtr := flag.Int("depth", 0, "number of levels of bins. Defaults to the smallest depth covering the longest reference")
+	flag.CommandLine.Parse(args)
+
+	if *pathPtr == "" {
+		fmt.Println("ERROR: 'path' is required")
+		return 1
+	}
+	output := *outputPtr
+	if output == "" {
+		output = *pathPtr + ".csi"
+	}
+
+	file, err := os.Open(*pathPtr)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	defer file.Close()
+	csiIndex, err := htsformats.BuildCsi(file, *minShiftPtr, *depthPtr)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+
+	outputFile, err := os.Create(output)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	err = htsformats.WriteCsi(outputFile, csiIndex)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	return 0
+}
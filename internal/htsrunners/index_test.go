@@ -0,0 +1,78 @@
+// Package htsrunners contains cli subcommands
+//
+// Module index_test tests index
+package htsrunners
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
+
+	"github.com/kami-zh/go-capturer"
+	"github.com/stretchr/testify/assert"
+)
+
+// indexErrorTC test cases for Index given invalid arguments
+var indexErrorTC = []struct {
+	args      []string
+	expStdout string
+}{
+	{[]string{}, "ERROR: 'path' is required\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.sam"}, "ERROR: Invalid BAM magic bytes\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.bam", "-min-shift", "14", "-depth", "11"}, "ERROR: Invalid CSI min_shift and depth: 14, 11\n"},
+	{[]string{"-path", "../../data/test/input/missing.bam"}, ""},
+}
+
+// readTestCsi reads a CSI file, failing the test if it is invalid
+func readTestCsi(t *testing.T, path string) *htsformats.CsiIndex {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	csiIndex, err := htsformats.ReadCsi(file)
+	assert.Nil(t, err)
+	return csiIndex
+}
+
+// TestIndex tests that Index writes the CSI index of the BAM test file, equal
+// to the test index file with the default binning scheme
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "modify-sam.bam.csi")
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout := capturer.CaptureStdout(func() {
+		assert.Equal(t, 0, Index([]string{"-path", "../../data/test/input/modify-sam.bam", "-output", output}))
+	})
+	assert.Equal(t, "", stdout)
+	assert.Equal(t, readTestCsi(t, "../../data/test/input/modify-sam.bam.csi"), readTestCsi(t, output))
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	assert.Equal(t, 0, Index([]string{"-path", "../../data/test/input/modify-sam.bam", "-output", output, "-min-shift", "12", "-depth", "7"}))
+	csiIndex := readTestCsi(t, output)
+	assert.Equal(t, 12, csiIndex.MinShift)
+	assert.Equal(t, 7, csiIndex.Depth)
+}
+
+// TestIndexError tests function Index given invalid arguments. An empty
+// expected output only checks that an error is reported
+func TestIndexError(t *testing.T) {
+	for _, tc := range indexErrorTC {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		var code int
+		stdout := capturer.CaptureStdout(func() {
+			code = Index(tc.args)
+		})
+		assert.Equal(t, 1, code, tc.args)
+		if tc.expStdout == "" {
+			assert.Contains(t, stdout, "ERROR: ")
+		} else {
+			assert.Equal(t, tc.expStdout, stdout)
+		}
+	}
+}
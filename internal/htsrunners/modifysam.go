@@ -1,64 +1,468 @@
 // Package htsrunners contains cli subcommands
 //
-// Module modifysam contains the modify-sam subcommand, in which a SAM file is
-// streamed from stdin, custom fields and tags are included/excluded and streamed
-// to stdout
+// Module modifysam contains the modify-sam subcommand, in which a SAM or BAM
+// file is streamed from stdin, custom fields and tags are included/excluded
+// and streamed to stdout as SAM or BAM
 package htsrunners
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"os"
+	"strconv"
 	"strings"
 
-	"github.com/ga4gh/htsget-refserver-utils/internal/htsformats"
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
 )
 
-// samRecordCustomEmit convenience method to emit a single SAM alignment/record
-// based on how the samRecordEmitter has been configured
-func samRecordCustomEmit(samRecordEmitter *htsformats.SamRecordEmitter, text string) {
-	samRecord := htsformats.NewSamRecord(text)
-	customEmit := samRecordEmitter.CustomEmit(samRecord)
-	fmt.Println(customEmit)
+// classHeader htsget class requesting only the header
+const classHeader = "header"
+
+// classBody class requesting only the alignments, without the header
+const classBody = "body"
+
+// modifySamBufferSize size of the stdout buffer. Output is flushed when full,
+// and once the stream has been processed
+const modifySamBufferSize = 1 << 20
+
+// modifySamText streams plain text SAM, writing header lines unmodified and
+// alignments according to the configured output. If only the header was
+// requested, reading stops at the first alignment. Alignments that cannot be
+// parsed or written are passed to the record error handler
+func modifySamText(output alignmentOutput, reader io.Reader, class string, handler *recordErrorHandler, threads int) error {
+	samReader, err := htsformats.NewSamReader(reader)
+	if err != nil {
+		return err
+	}
+	if err := output.writeHeader(samReader.HeaderLines()); err != nil {
+		return err
+	}
+	if class == classHeader {
+		return nil
+	}
+
+	if threads > 1 {
+		return modifySamTextParallel(output, samReader, handler, threads)
+	}
+	for {
+		text, err := samReader.NextLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := modifySamLine(output, text); err != nil {
+			if err := handler.handle("Line "+strconv.Itoa(samReader.LineNumber()), text, err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// modifySamLine parses and writes a single alignment line
+func modifySamLine(output alignmentOutput, text string) error {
+	samRecord, err := htsformats.NewSamRecord(text)
+	if err != nil {
+		return recordParseError{err}
+	}
+	return output.writeRecord(samRecord)
+}
+
+// modifySamTextParallel processes alignment lines on worker goroutines
+func modifySamTextParallel(output alignmentOutput, samReader *htsformats.SamReader, handler *recordErrorHandler, threads int) error {
+	process := func(output alignmentOutput, batch *recordBatch, i int) *recordFailure {
+		line := batch.lines[i]
+		if err := modifySamLine(output, line); err != nil {
+			return &recordFailure{location: "Line " + strconv.Itoa(batch.number+i), line: line, err: err}
+		}
+		return nil
+	}
+	read := func(batch *recordBatch) error {
+		batch.number = samReader.LineNumber() + 1
+		for len(batch.lines) < recordBatchSize {
+			line, err := samReader.NextLine()
+			if err != nil {
+				return err
+			}
+			batch.lines = append(batch.lines, line)
+		}
+		return nil
+	}
+	return newRecordPipeline(output, samReader.HeaderLines(), handler, process, threads).run(read)
+}
+
+// modifySamBam streams decompressed BAM, writing the header text unmodified
+// and each decoded alignment according to the configured output. If only the
+// header was requested, no alignments are read. Alignments that cannot be
+// written are passed to the record error handler
+func modifySamBam(output alignmentOutput, reader io.Reader, class string, handler *recordErrorHandler, threads int) error {
+	bamReader, err := htsformats.NewBamReader(reader)
+	if err != nil {
+		return err
+	}
+
+	headerLines := bamReader.HeaderLines()
+	if err := output.writeHeader(headerLines); err != nil {
+		return err
+	}
+	if class == classHeader {
+		return nil
+	}
+	if threads > 1 {
+		return modifySamBamParallel(output, headerLines, bamReader, handler, threads)
+	}
+
+	// unparsed lines are passed through as their unmodified SAM text
+	unmodifiedEmitter, _ := htsformats.NewSamRecordEmitter("", "", "")
+	for recordNumber := 1; ; recordNumber++ {
+		samRecord, err := bamReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := output.writeRecord(samRecord); err != nil {
+			location := "Record " + strconv.Itoa(recordNumber)
+			if err := handler.handle(location, unmodifiedEmitter.CustomEmit(samRecord), err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// modifySamBamParallel decodes and processes alignments on worker goroutines.
+// Alignments that cannot be decoded end the stream
+func modifySamBamParallel(output alignmentOutput, headerLines []string, bamReader *htsformats.BamReader, handler *recordErrorHandler, threads int) error {
+	unmodifiedEmitter, _ := htsformats.NewSamRecordEmitter("", "", "")
+	process := func(output alignmentOutput, batch *recordBatch, i int) *recordFailure {
+		samRecord, err := bamReader.DecodeRecord(batch.records[i])
+		if err != nil {
+			return &recordFailure{err: err, fatal: true}
+		}
+		if err := output.writeRecord(samRecord); err != nil {
+			location := "Record " + strconv.Itoa(batch.number+i)
+			return &recordFailure{location: location, line: unmodifiedEmitter.CustomEmit(samRecord), err: err}
+		}
+		return nil
+	}
+
+	recordNumber := 1
+	read := func(batch *recordBatch) error {
+		batch.number = recordNumber
+		for len(batch.records) < recordBatchSize {
+			data, err := bamReader.NextData()
+			if err != nil {
+				recordNumber += len(batch.records)
+				return err
+			}
+			batch.records = append(batch.records, data)
+		}
+		recordNumber += len(batch.records)
+		return nil
+	}
+	return newRecordPipeline(output, headerLines, handler, process, threads).run(read)
+}
+
+// programName name of this program, recorded in the @PG header line
+const programName = "htsget-refserver-utils"
+
+// modifySamCommandLine reconstructs the modify-sam command line for the @PG
+// header line, quoting arguments containing whitespace
+func modifySamCommandLine(args []string) string {
+	quoted := []string{programName, "modify-sam"}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t'") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		quoted = append(quoted, arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// modifySamHeaderRewrite constructs the header rewrite, appending a @PG line
+// for this program and/or removing @SQ lines for references not in the list
+// of reference names. A nil list of reference names keeps all @SQ lines
+func modifySamHeaderRewrite(addPG bool, commandLine string, referenceNames []string) func([]string) ([]string, error) {
+	return func(headerLines []string) ([]string, error) {
+		samHeader, err := htsformats.NewSamHeader(headerLines)
+		if err != nil {
+			return nil, err
+		}
+		if referenceNames != nil {
+			samHeader.FilterReferences(referenceNames)
+		}
+		if addPG {
+			samHeader.AddProgram(programName, commandLine)
+		}
+		return samHeader.Lines(), nil
+	}
+}
+
+// modifySamRegions collects all requested regions from the referenceName,
+// start, and end flags, repeated region flags, and a BED file
+func modifySamRegions(referenceName string, start int, end int, regionStrings []string, regionsBed string) ([]*htsformats.Region, error) {
+	regions := []*htsformats.Region{}
+
+	if referenceName != "" {
+		region, err := htsformats.NewRegion(referenceName, start, end)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, region)
+	} else if start != htsformats.RegionUnbounded || end != htsformats.RegionUnbounded {
+		return nil, errors.New("'start' and 'end' require 'referenceName'")
+	}
+
+	for _, regionString := range regionStrings {
+		region, err := htsformats.ParseRegion(regionString)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, region)
+	}
+
+	if regionsBed != "" {
+		bedFile, err := os.Open(regionsBed)
+		if err != nil {
+			return nil, err
+		}
+		defer bedFile.Close()
+		bedRegions, err := htsformats.ReadBedRegions(bedFile)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, bedRegions...)
+	}
+	return regions, nil
 }
 
-// ModifySam runner for 'modify-sam' subcommand. Streams a SAM file from stdin,
-// performs custom field/tag inclusion, and streams to stdout
+// modifySamTagFilters constructs filters for tags required to exist, required
+// tag values, and tag value comparisons
+func modifySamTagFilters(tagExists []string, requireTags []string, tagComparisons []string) (htsformats.SamRecordFilterChain, error) {
+	filters := htsformats.SamRecordFilterChain{}
+	for _, key := range tagExists {
+		filter, err := htsformats.NewTagExistsFilter(key)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	for _, text := range requireTags {
+		filter, err := htsformats.NewTagValueFilter(text)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	for _, expression := range tagComparisons {
+		filter, err := htsformats.NewTagComparisonFilter(expression)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// modifySamFlagFilter constructs a filter for required and excluded FLAG bits,
+// each given as a numeric or symbolic mask. Empty masks are ignored
+func modifySamFlagFilter(requireFlags string, excludeFlags string) (*htsformats.FlagFilter, error) {
+	var require, exclude htsformats.SamFlag
+	var err error
+	if requireFlags != "" {
+		if require, err = htsformats.ParseSamFlag(requireFlags); err != nil {
+			return nil, err
+		}
+	}
+	if excludeFlags != "" {
+		if exclude, err = htsformats.ParseSamFlag(excludeFlags); err != nil {
+			return nil, err
+		}
+	}
+	return htsformats.NewFlagFilter(require, exclude), nil
+}
+
+// ModifySam runner for 'modify-sam' subcommand. Streams a SAM or BAM file from
+// stdin, performs custom field/tag inclusion, and streams SAM or BAM to stdout
 func ModifySam(args []string, reader io.Reader) int {
 
 	// parses cli args
 	fieldsPtr := flag.String("fields", "", "comma-delimited list of fields to include in output SAM")
 	tagsPtr := flag.String("tags", "", "comma-delimited list of tags to include in output SAM")
 	notagsPtr := flag.String("notags", "", "comma-delimited list of tags to exclude from output SAM")
+	outputFormatPtr := flag.String("output-format", htsformats.FormatSam, "output format, SAM or BAM")
+	referenceNamePtr := flag.String("referenceName", "", "only emit alignments on this reference, '*' for unplaced unmapped reads")
+	startPtr := flag.Int("start", htsformats.RegionUnbounded, "only emit alignments overlapping this 0-based start position (inclusive)")
+	endPtr := flag.Int("end", htsformats.RegionUnbounded, "only emit alignments overlapping this 0-based end position (exclusive)")
+	var regionsFlag repeatedFlag
+	flag.Var(&regionsFlag, "region", "only emit alignments overlapping this region, as name:start-end (0-based, end exclusive). May be repeated")
+	regionsBedPtr := flag.String("regions-bed", "", "only emit alignments overlapping any region in this BED file")
+	classPtr := flag.String("class", "", "htsget class, 'header' to only emit the header, 'body' to only emit alignments")
+	noHeaderPtr := flag.Bool("no-header", false, "only emit alignments, equivalent to '-class body'")
+	var tagExistsFlag, requireTagFlag, tagFilterFlag repeatedFlag
+	flag.Var(&tagExistsFlag, "tag-exists", "only emit alignments having this tag, eg. 'RG'. May be repeated")
+	flag.Var(&requireTagFlag, "require-tag", "only emit alignments having this tag value, eg. 'NH:i:1'. May be repeated")
+	flag.Var(&tagFilterFlag, "tag-filter", "only emit alignments whose tag value satisfies this comparison, eg. 'NM<=2'. May be repeated")
+	requireFlagsPtr := flag.String("require-flags", "", "only emit alignments with all of these FLAG bits set, numeric or symbolic, eg. 'PAIRED,READ1'")
+	excludeFlagsPtr := flag.String("exclude-flags", "", "only emit alignments with none of these FLAG bits set, numeric or symbolic, eg. 'UNMAP,SECONDARY,DUP'")
+	minMapqPtr := flag.Int("min-mapq", -1, "only emit alignments with at least this MAPQ. Alignments with unavailable MAPQ (255) are excluded, unless this is 0")
+	keepUnavailableMapqPtr := flag.Bool("keep-unavailable-mapq", false, "with '-min-mapq', also emit alignments with unavailable MAPQ (255)")
+	minAlignedLengthPtr := flag.Int("min-aligned-length", -1, "only emit alignments with at least this many bases aligned (CIGAR M, =, X)")
+	maxMismatchesPtr := flag.Int("max-mismatches", -1, "only emit alignments with at most this edit distance (NM tag)")
+	var filterFlag repeatedFlag
+	flag.Var(&filterFlag, "filter", "only emit alignments for which this expression is true, eg. 'mapq >= 30 && !flag.dup && tag.NM <= 3'. May be repeated")
+	onErrorPtr := flag.String("on-error", onErrorFail, "policy for alignments that cannot be parsed or processed: 'fail', 'skip', or 'passthrough' (SAM output only)")
+	addPGPtr := flag.Bool("add-pg", false, "append a @PG line recording this command to the header")
+	filterSQPtr := flag.Bool("filter-sq", false, "only keep @SQ header lines for references in the requested regions, clearing RNEXT/PNEXT of mates on other references")
+	threadsPtr := flag.Int("threads", 1, "number of goroutines processing alignments and (de)compressing BGZF, output order is preserved")
 	flag.CommandLine.Parse(args)
 
-	// configure the SamRecordEmitter
+	if *threadsPtr < 1 {
+		fmt.Println("ERROR: 'threads' must be at least 1")
+		return 1
+	}
+
+	if *classPtr != "" && *classPtr != classHeader && *classPtr != classBody {
+		fmt.Println("ERROR: Invalid class: '" + *classPtr + "'")
+		return 1
+	}
+	if *noHeaderPtr {
+		if *classPtr == classHeader {
+			fmt.Println("ERROR: 'no-header' cannot be combined with '-class header'")
+			return 1
+		}
+		*classPtr = classBody
+	}
+
+	// configure the SamRecordEmitter and output
 	samRecordEmitter, err := htsformats.NewSamRecordEmitter(*fieldsPtr, *tagsPtr, *notagsPtr)
 	if err != nil {
 		fmt.Println("ERROR: " + err.Error())
 		return 1
 	}
+	stdout := bufio.NewWriterSize(os.Stdout, modifySamBufferSize)
+	output, err := newAlignmentOutput(*outputFormatPtr, samRecordEmitter, stdout, *threadsPtr)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
 
-	// iterates over each line in the SAM
-	header := true
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		text := scanner.Text()
-		if header {
-			// first, header lines are streamed from stdin to stdout without
-			// modification. the program looks for the first non-header line,
-			// at which point the SamRecord lines are emitted according to custom
-			// rules
-			if strings.HasPrefix(text, "@") {
-				fmt.Println(text)
-			} else {
-				header = false
-				samRecordCustomEmit(samRecordEmitter, text)
-			}
-		} else {
-			samRecordCustomEmit(samRecordEmitter, text)
+	// configure record filters, restricting output to the requested regions,
+	// tag values, flags, alignment quality and filter expressions
+	filters := htsformats.SamRecordFilterChain{}
+	regions, err := modifySamRegions(*referenceNamePtr, *startPtr, *endPtr, regionsFlag, *regionsBedPtr)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	if len(regions) > 0 {
+		filters = append(filters, htsformats.NewRegionSet(regions))
+	}
+
+	// restricting the reference dictionary to the requested regions also
+	// clears mates on removed references, after alignments are filtered
+	var referenceNames []string
+	if *filterSQPtr {
+		if len(regions) == 0 {
+			fmt.Println("ERROR: 'filter-sq' requires a region")
+			return 1
 		}
+		referenceNames = []string{}
+		for _, region := range regions {
+			referenceNames = append(referenceNames, region.ReferenceName)
+		}
+		output = newMateOutput(output, referenceNames)
+	}
+	tagFilters, err := modifySamTagFilters(tagExistsFlag, requireTagFlag, tagFilterFlag)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	filters = append(filters, tagFilters...)
+	if *requireFlagsPtr != "" || *excludeFlagsPtr != "" {
+		flagFilter, err := modifySamFlagFilter(*requireFlagsPtr, *excludeFlagsPtr)
+		if err != nil {
+			fmt.Println("ERROR: " + err.Error())
+			return 1
+		}
+		filters = append(filters, flagFilter)
+	}
+	if *keepUnavailableMapqPtr && *minMapqPtr < 0 {
+		fmt.Println("ERROR: 'keep-unavailable-mapq' requires 'min-mapq'")
+		return 1
+	}
+	if *minMapqPtr >= 0 {
+		filters = append(filters, htsformats.NewMapqFilter(*minMapqPtr, *keepUnavailableMapqPtr))
+	}
+	if *minAlignedLengthPtr >= 0 {
+		filters = append(filters, htsformats.NewAlignedLengthFilter(*minAlignedLengthPtr))
+	}
+	if *maxMismatchesPtr >= 0 {
+		filters = append(filters, htsformats.NewMismatchFilter(int64(*maxMismatchesPtr)))
+	}
+	for _, expression := range filterFlag {
+		filterExpression, err := htsformats.ParseFilterExpression(expression)
+		if err != nil {
+			fmt.Println("ERROR: " + err.Error())
+			return 1
+		}
+		filters = append(filters, filterExpression)
+	}
+	if len(filters) > 0 {
+		output = &filteredOutput{output, filters}
+	}
+
+	// configure header rewriting, recording provenance and/or restricting the
+	// reference dictionary to the requested regions
+	if *addPGPtr || *filterSQPtr {
+		output = &headerOutput{output, modifySamHeaderRewrite(*addPGPtr, modifySamCommandLine(args), referenceNames)}
+	}
+	if *classPtr == classBody {
+		output = &bodyOutput{output}
+	}
+
+	// configure the policy for malformed alignments
+	if *onErrorPtr == onErrorPassthrough && *outputFormatPtr != htsformats.FormatSam {
+		fmt.Println("ERROR: 'on-error passthrough' requires SAM output")
+		return 1
+	}
+	handler, err := newRecordErrorHandler(*onErrorPtr, output)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+
+	// detect whether the input is SAM or BAM from its magic bytes
+	input, format, err := htsformats.DetectFormatThreads(reader, *threadsPtr)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	if closer, ok := input.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if format == htsformats.FormatBam {
+		err = modifySamBam(output, input, *classPtr, handler, *threadsPtr)
+	} else {
+		err = modifySamText(output, input, *classPtr, handler, *threadsPtr)
+	}
+	if err == nil {
+		err = output.close()
+	}
+
+	// output written before any error is flushed ahead of the error message
+	if flushErr := stdout.Flush(); err == nil {
+		err = flushErr
+	}
+	handler.report()
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
 	}
 	return 0
 }
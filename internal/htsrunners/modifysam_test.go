@@ -4,11 +4,20 @@
 package htsrunners
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/md5"
+	"errors"
 	"flag"
+	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
 
 	"github.com/kami-zh/go-capturer"
 	"github.com/stretchr/testify/assert"
@@ -91,30 +100,304 @@ var modifySamTC = []struct {
 		false,
 		"modify-sam.11.sam",
 	},
+	// region filtering
+	{
+		[]string{"-referenceName", "chr1", "-start", "24613500", "-end", "24614000"},
+		false,
+		"modify-sam.12.sam",
+	},
+	{
+		[]string{"-referenceName", "chr1", "-start", "36692000", "-end", "36692100"},
+		false,
+		"modify-sam.13.sam",
+	},
+	{
+		[]string{"-referenceName", "*"},
+		false,
+		"modify-sam.14.sam",
+	},
+	{
+		[]string{"-referenceName", "chr1", "-start", "150000000"},
+		false,
+		"modify-sam.15.sam",
+	},
+	// multiple regions, overlapping regions emit each record once
+	{
+		[]string{"-region", "chr1:24613500-24614000", "-region", "chr1:24613900-24614600", "-region", "chr1:194699000-194699500"},
+		false,
+		"modify-sam.16.sam",
+	},
+	{
+		[]string{"-referenceName", "chr1", "-start", "24613900", "-end", "24614600", "-region", "chr1:194699000-194699500", "-region", "chr1:24613500-24614000"},
+		false,
+		"modify-sam.16.sam",
+	},
+	{
+		[]string{"-regions-bed", "../../data/test/input/modify-sam.regions.bed"},
+		false,
+		"modify-sam.16.sam",
+	},
+	// header only
+	{
+		[]string{"-class", "header"},
+		false,
+		"modify-sam.14.sam",
+	},
+	{
+		[]string{"-class", "header", "-fields", "QNAME", "-referenceName", "chr1"},
+		false,
+		"modify-sam.14.sam",
+	},
+	{
+		[]string{"-class", "data"},
+		true,
+		"modify-sam.00.sam",
+	},
+	// body only
+	{
+		[]string{"-class", "body"},
+		false,
+		"modify-sam.17.sam",
+	},
+	{
+		[]string{"-no-header", "-fields", "QNAME,FLAG,RNAME,POS", "-tags", "NH,HI", "-notags", "MD"},
+		false,
+		"modify-sam.18.sam",
+	},
+	{
+		[]string{"-no-header", "-class", "header"},
+		true,
+		"modify-sam.00.sam",
+	},
+	// header rewriting
+	{
+		[]string{"-add-pg", "-fields", "QNAME,RNAME,MAPQ,RNEXT,TLEN,QUAL"},
+		false,
+		"modify-sam.19.sam",
+	},
+	{
+		[]string{"-filter-sq", "-referenceName", "*"},
+		false,
+		"modify-sam.20.sam",
+	},
+	{
+		[]string{"-add-pg", "-filter-sq", "-region", "chr1:24613500-24614000"},
+		false,
+		"modify-sam.21.sam",
+	},
+	{
+		[]string{"-filter-sq"},
+		true,
+		"modify-sam.00.sam",
+	},
+	// tag filtering
+	{
+		[]string{"-require-tag", "NH:i:1"},
+		false,
+		"modify-sam.22.sam",
+	},
+	{
+		[]string{"-tag-filter", "NM<=1", "-tag-filter", "NM!=0"},
+		false,
+		"modify-sam.23.sam",
+	},
+	{
+		[]string{"-tag-filter", "NM=1"},
+		false,
+		"modify-sam.23.sam",
+	},
+	{
+		[]string{"-tag-exists", "XS", "-require-tag", "NH:i:1"},
+		false,
+		"modify-sam.24.sam",
+	},
+	{
+		[]string{"-tag-exists", "XS", "-tags", "NH"},
+		false,
+		"modify-sam.25.sam",
+	},
+	{
+		[]string{"-require-tag", "NH:i"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-tag-filter", "NM"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-tag-exists", "N"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-tag-filter", "NM<abc"},
+		true,
+		"modify-sam.00.sam",
+	},
+	// flag filtering
+	{
+		[]string{"-require-flags", "READ1"},
+		false,
+		"modify-sam.26.sam",
+	},
+	{
+		[]string{"-require-flags", "0x40"},
+		false,
+		"modify-sam.26.sam",
+	},
+	{
+		[]string{"-require-flags", "PAIRED,PROPER_PAIR", "-exclude-flags", "REVERSE"},
+		false,
+		"modify-sam.27.sam",
+	},
+	{
+		[]string{"-exclude-flags", "16"},
+		false,
+		"modify-sam.27.sam",
+	},
+	{
+		[]string{"-exclude-flags", "UNMAP,SECONDARY,SUPPLEMENTARY,DUP"},
+		false,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-require-flags", "PAIRED,BAD"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-exclude-flags", "4096"},
+		true,
+		"modify-sam.00.sam",
+	},
+	// alignment quality filtering
+	{
+		[]string{"-min-mapq", "3"},
+		false,
+		"modify-sam.28.sam",
+	},
+	{
+		[]string{"-min-mapq", "0"},
+		false,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-min-mapq", "3", "-keep-unavailable-mapq"},
+		false,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-min-mapq", "4", "-keep-unavailable-mapq"},
+		false,
+		"modify-sam.29.sam",
+	},
+	{
+		[]string{"-min-aligned-length", "95"},
+		false,
+		"modify-sam.30.sam",
+	},
+	{
+		[]string{"-max-mismatches", "1", "-min-aligned-length", "95"},
+		false,
+		"modify-sam.31.sam",
+	},
+	{
+		[]string{"-keep-unavailable-mapq"},
+		true,
+		"modify-sam.00.sam",
+	},
+	// filter expressions
+	{
+		[]string{"-filter", "tag.NH == 1"},
+		false,
+		"modify-sam.22.sam",
+	},
+	{
+		[]string{"-filter", "mapq != 255 && mapq >= 3"},
+		false,
+		"modify-sam.28.sam",
+	},
+	{
+		[]string{"-filter", "flag.read1", "-filter", "rname == \"chr1\""},
+		false,
+		"modify-sam.26.sam",
+	},
+	{
+		[]string{"-filter", "tag.XS && !(tag.NH > 1)", "-tags", "NH"},
+		false,
+		"modify-sam.25.sam",
+	},
+	{
+		[]string{"-filter", "mapqq >= 30"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-filter", "rname >= 30"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-filter", "tag.MD > 3"},
+		true,
+		"modify-sam.00.sam",
+	},
+	// region error cases
+	{
+		[]string{"-region", "chr1:200-100"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-regions-bed", "../../data/test/input/nonexistent.bed"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-start", "100"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-referenceName", "chr1", "-start", "200", "-end", "100"},
+		true,
+		"modify-sam.00.sam",
+	},
+	{
+		[]string{"-referenceName", "*", "-start", "100"},
+		true,
+		"modify-sam.00.sam",
+	},
 }
 
-// TestModifySam tests function ModifySam
-func TestModifySam(t *testing.T) {
+// runModifySamTC runs all ModifySam test cases against the given input file,
+// comparing stdout to the expected output files. Extra args are appended to
+// each case, and removed from the @PG command line of the output
+func runModifySamTC(t *testing.T, inputFilename string, extraArgs ...string) {
 
 	for _, tc := range modifySamTC {
+		args := append(append([]string{}, tc.args...), extraArgs...)
+
 		// unset flag values between cases
 		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
 		// declare input/output files
 		dataDir := "../../data/test"
-		inputFp := dataDir + "/input/modify-sam.sam"
+		inputFp := dataDir + "/input/" + inputFilename
 		outputFp := dataDir + "/output/" + tc.filename
 
 		// declare wrapper function to capture stdout
 		stdinReader, _ := os.Open(inputFp)
 
 		if tc.expError {
-			code := ModifySam(tc.args, stdinReader)
+			code := ModifySam(args, stdinReader)
 			assert.Equal(t, 1, code)
 		} else {
 
 			modifySamWrapper := func() {
-				ModifySam(tc.args, stdinReader)
+				ModifySam(args, stdinReader)
 			}
 
 			// load expected stdout
@@ -124,8 +407,553 @@ func TestModifySam(t *testing.T) {
 
 			// run function, capture stdout, and compare to expected
 			actualStdout := capturer.CaptureStdout(modifySamWrapper)
+			if len(extraArgs) > 0 {
+				actualStdout = strings.Replace(actualStdout, " "+strings.Join(extraArgs, " "), "", -1)
+			}
 			actualMD5 := md5.Sum([]byte(actualStdout))
 			assert.Equal(t, expectedMD5, actualMD5)
 		}
 	}
 }
+
+// TestModifySam tests function ModifySam
+func TestModifySam(t *testing.T) {
+	runModifySamTC(t, "modify-sam.sam")
+}
+
+// TestModifySamBam tests function ModifySam with BAM input, which is expected
+// to produce the same SAM output as the equivalent SAM input
+func TestModifySamBam(t *testing.T) {
+	runModifySamTC(t, "modify-sam.bam")
+}
+
+// TestModifySamThreads tests function ModifySam with SAM and BAM input
+// processed on multiple goroutines, in batches of a few alignments, which is
+// expected to produce the same output as sequential processing
+func TestModifySamThreads(t *testing.T) {
+	defer func(batchSize int) { recordBatchSize = batchSize }(recordBatchSize)
+	recordBatchSize = 4
+	runModifySamTC(t, "modify-sam.sam", "-threads", "4")
+	runModifySamTC(t, "modify-sam.bam", "-threads", "4")
+}
+
+// decodeBamStdout decodes BAM written to stdout back into SAM text lines
+func decodeBamStdout(t *testing.T, stdout string) []string {
+	bamReader, err := htsformats.NewBamReader(htsformats.NewBgzfReader(strings.NewReader(stdout)))
+	assert.Nil(t, err)
+	lines := bamReader.HeaderLines()
+	samRecordEmitter, _ := htsformats.NewSamRecordEmitter("", "", "")
+	for {
+		samRecord, err := bamReader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		lines = append(lines, samRecordEmitter.CustomEmit(samRecord))
+	}
+	return lines
+}
+
+// expectedBamLines loads the expected SAM output lines for BAM output
+// comparison. BAM cannot store QUAL without SEQ, so QUAL is expected to be
+// unavailable for records with no SEQ. Likewise, RNEXT '=' refers to an unset
+// reference if RNAME is unavailable, and is expected to be '*'
+func expectedBamLines(filename string) []string {
+	file, _ := os.Open("../../data/test/output/" + filename)
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		split := strings.Split(line, "\t")
+		if !strings.HasPrefix(line, "@") {
+			if split[9] == "*" {
+				split[10] = "*"
+			}
+			if split[2] == "*" && split[6] == "=" {
+				split[6] = "*"
+			}
+		}
+		lines = append(lines, strings.Join(split, "\t"))
+	}
+	return lines
+}
+
+// TestModifySamOutputBam tests function ModifySam with BAM output, decoding the
+// output and comparing to the expected SAM output
+func TestModifySamOutputBam(t *testing.T) {
+	for _, inputFilename := range []string{"modify-sam.sam", "modify-sam.bam"} {
+		for _, tc := range modifySamTC {
+			// body-only BAM output is tested by TestModifySamOutputBamConcatenated
+			if tc.expError || isBodyOnly(tc.args) {
+				continue
+			}
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			stdinReader, _ := os.Open("../../data/test/input/" + inputFilename)
+			args := append([]string{"-output-format", "BAM"}, tc.args...)
+
+			modifySamWrapper := func() {
+				assert.Equal(t, 0, ModifySam(args, stdinReader))
+			}
+			actualStdout := capturer.CaptureStdout(modifySamWrapper)
+
+			// the @PG command line additionally records the output format
+			actualLines := decodeBamStdout(t, actualStdout)
+			for i, line := range actualLines {
+				actualLines[i] = strings.Replace(line, "modify-sam -output-format BAM ", "modify-sam ", 1)
+			}
+			assert.Equal(t, expectedBamLines(tc.filename), actualLines)
+		}
+	}
+}
+
+// isBodyOnly indicates whether ModifySam args request alignments only
+func isBodyOnly(args []string) bool {
+	for i, arg := range args {
+		if arg == "-no-header" || (arg == "-class" && args[i+1] == "body") {
+			return true
+		}
+	}
+	return false
+}
+
+// TestModifySamOutputBamConcatenated tests that header-only and body-only BAM
+// outputs can be concatenated into a single valid BAM
+func TestModifySamOutputBamConcatenated(t *testing.T) {
+	for _, inputFilename := range []string{"modify-sam.sam", "modify-sam.bam"} {
+		concatenated := ""
+		for _, class := range []string{"header", "body"} {
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			stdinReader, _ := os.Open("../../data/test/input/" + inputFilename)
+			args := []string{"-output-format", "BAM", "-class", class, "-fields", "QNAME,FLAG,RNAME,POS"}
+			modifySamWrapper := func() {
+				assert.Equal(t, 0, ModifySam(args, stdinReader))
+			}
+			concatenated += capturer.CaptureStdout(modifySamWrapper)
+		}
+
+		// expected output is the equivalent SAM output
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		stdinReader, _ := os.Open("../../data/test/input/" + inputFilename)
+		modifySamWrapper := func() {
+			ModifySam([]string{"-fields", "QNAME,FLAG,RNAME,POS"}, stdinReader)
+		}
+		expected := strings.Split(strings.TrimSuffix(capturer.CaptureStdout(modifySamWrapper), "\n"), "\n")
+		assert.Equal(t, expected, decodeBamStdout(t, concatenated))
+	}
+}
+
+// modifySamFilterSQInput SAM with mates on a reference other than the one
+// requested, on the same reference, and unplaced
+var modifySamFilterSQInput = "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:1000\n@SQ\tSN:chr2\tLN:1000\n" +
+	"r1\t1\tchr1\t10\t60\t1M\tchr2\t500\t0\tA\tF\n" +
+	"r2\t1\tchr1\t20\t60\t1M\t=\t30\t11\tA\tF\n" +
+	"r3\t1\tchr1\t30\t60\t1M\t*\t0\t0\tA\tF\n" +
+	"r4\t1\tchr2\t40\t60\t1M\tchr1\t10\t0\tA\tF\n"
+
+// TestModifySamFilterSQMates tests that with '-filter-sq', mates on removed
+// references are cleared, for SAM and BAM output
+func TestModifySamFilterSQMates(t *testing.T) {
+	expected := []string{
+		"@HD\tVN:1.6",
+		"@SQ\tSN:chr1\tLN:1000",
+		"r1\t1\tchr1\t10\t60\t1M\t*\t0\t0\tA\tF",
+		"r2\t1\tchr1\t20\t60\t1M\t=\t30\t11\tA\tF",
+		"r3\t1\tchr1\t30\t60\t1M\t*\t0\t0\tA\tF",
+	}
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout := capturer.CaptureStdout(func() {
+		assert.Equal(t, 0, ModifySam([]string{"-filter-sq", "-referenceName", "chr1"}, strings.NewReader(modifySamFilterSQInput)))
+	})
+	assert.Equal(t, strings.Join(expected, "\n")+"\n", stdout)
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout = capturer.CaptureStdout(func() {
+		assert.Equal(t, 0, ModifySam([]string{"-output-format", "BAM", "-filter-sq", "-threads", "2", "-referenceName", "chr1"}, strings.NewReader(modifySamFilterSQInput)))
+	})
+	assert.Equal(t, expected, decodeBamStdout(t, stdout))
+}
+
+// TestModifySamOutputFormatError tests function ModifySam with an invalid
+// output format
+func TestModifySamOutputFormatError(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdinReader, _ := os.Open("../../data/test/input/modify-sam.sam")
+	assert.Equal(t, 1, ModifySam([]string{"-output-format", "CRAM"}, stdinReader))
+}
+
+// TestModifySamClassHeaderStopsReading tests that no alignments are read after
+// the header in header-only mode, such that malformed alignments following the
+// first are never parsed
+func TestModifySamClassHeaderStopsReading(t *testing.T) {
+	// SAM: header, one alignment, then a malformed line
+	samInput := "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\nr\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\nmalformed\n"
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	modifySamWrapper := func() {
+		assert.Equal(t, 0, ModifySam([]string{"-class", "header"}, strings.NewReader(samInput)))
+	}
+	assert.Equal(t, "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n", capturer.CaptureStdout(modifySamWrapper))
+
+	// BAM: header followed by a truncated alignment
+	var bamInput strings.Builder
+	bamWriter := htsformats.NewBamWriter(htsformats.NewBgzfWriter(&bamInput))
+	bamWriter.WriteHeader([]string{"@HD\tVN:1.6", "@SQ\tSN:chr1\tLN:100"})
+	samRecord, _ := htsformats.NewSamRecord("r\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF")
+	bamWriter.Write(samRecord)
+	bamWriter.Close()
+	var truncated strings.Builder
+	bgzfWriter := htsformats.NewBgzfWriter(&truncated)
+	decompressed, _ := ioutil.ReadAll(htsformats.NewBgzfReader(strings.NewReader(bamInput.String())))
+	bgzfWriter.Write(decompressed[:len(decompressed)-5])
+	bgzfWriter.Close()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	modifySamWrapper = func() {
+		assert.Equal(t, 0, ModifySam([]string{"-class", "header"}, strings.NewReader(truncated.String())))
+	}
+	assert.Equal(t, "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n", capturer.CaptureStdout(modifySamWrapper))
+}
+
+// modifySamCommandLineTC test cases for modifySamCommandLine
+var modifySamCommandLineTC = []struct {
+	args []string
+	exp  string
+}{
+	{[]string{}, "htsget-refserver-utils modify-sam"},
+	{[]string{"-add-pg", "-tags", "NM,MD"}, "htsget-refserver-utils modify-sam -add-pg -tags NM,MD"},
+	{[]string{"-region", "my chr:1-2", ""}, "htsget-refserver-utils modify-sam -region 'my chr:1-2' ''"},
+	{[]string{"-region", "it's"}, `htsget-refserver-utils modify-sam -region 'it'\''s'`},
+}
+
+// TestModifySamCommandLine tests function modifySamCommandLine
+func TestModifySamCommandLine(t *testing.T) {
+	for _, tc := range modifySamCommandLineTC {
+		assert.Equal(t, tc.exp, modifySamCommandLine(tc.args))
+	}
+}
+
+// modifySamOnErrorInput SAM with malformed alignments on lines 4 and 6, and an
+// alignment with an invalid POS on line 7
+var modifySamOnErrorInput = "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n" +
+	"r1\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\n" +
+	"r2\t0\tchr1\n" +
+	"r3\t0\tchr1\t3\t60\t1M\t*\t0\t0\tA\tF\n" +
+	"\n" +
+	"r4\t0\tchr1\tx\t60\t1M\t*\t0\t0\tA\tF\n"
+
+// modifySamOnErrorTC test cases for ModifySam on-error policies
+var modifySamOnErrorTC = []struct {
+	args      []string
+	expCode   int
+	expStdout string
+	expStderr string
+}{
+	{
+		[]string{},
+		1,
+		"@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\nr1\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\n" +
+			"ERROR: Line 4: Expected at least 11 tab-delimited fields, found 3\n",
+		"",
+	},
+	{
+		[]string{"-on-error", "skip"},
+		0,
+		"@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\nr1\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\n" +
+			"r3\t0\tchr1\t3\t60\t1M\t*\t0\t0\tA\tF\nr4\t0\tchr1\tx\t60\t1M\t*\t0\t0\tA\tF\n",
+		"WARNING: Skipped 2 malformed record(s)\n",
+	},
+	{
+		[]string{"-on-error", "skip", "-filter", "pos > 0"},
+		0,
+		"@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\nr1\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\n" +
+			"r3\t0\tchr1\t3\t60\t1M\t*\t0\t0\tA\tF\n",
+		"WARNING: Skipped 3 malformed record(s)\n",
+	},
+	{
+		[]string{"-on-error", "passthrough", "-fields", "QNAME,POS", "-filter", "pos > 0"},
+		0,
+		"@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\nr1\t0\t*\t1\t255\t*\t*\t0\t0\t*\t*\n" +
+			"r2\t0\tchr1\nr3\t0\t*\t3\t255\t*\t*\t0\t0\t*\t*\n\n",
+		"WARNING: Passed through 2 malformed record(s)\nWARNING: Skipped 1 malformed record(s)\n",
+	},
+	{
+		[]string{"-on-error", "passthrough", "-threads", "2", "-filter", "pos > 1"},
+		0,
+		"@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\nr2\t0\tchr1\nr3\t0\tchr1\t3\t60\t1M\t*\t0\t0\tA\tF\n\n",
+		"WARNING: Passed through 2 malformed record(s)\nWARNING: Skipped 1 malformed record(s)\n",
+	},
+	{
+		[]string{"-on-error", "fail", "-filter", "pos > 0", "-class", "body"},
+		1,
+		"r1\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\nERROR: Line 4: Expected at least 11 tab-delimited fields, found 3\n",
+		"",
+	},
+	{
+		[]string{"-on-error", "passthrough", "-output-format", "BAM"},
+		1,
+		"ERROR: 'on-error passthrough' requires SAM output\n",
+		"",
+	},
+	{
+		[]string{"-on-error", "skip", "-tag-filter", "XS<=abc"},
+		1,
+		"ERROR: Tag filter operator '<=' requires a numeric value: 'XS<=abc'\n",
+		"",
+	},
+	{
+		[]string{"-on-error", "ignore"},
+		1,
+		"ERROR: Invalid on-error policy: 'ignore'\n",
+		"",
+	},
+}
+
+// TestModifySamOnError tests function ModifySam with malformed alignments
+// under each on-error policy
+func TestModifySamOnError(t *testing.T) {
+	for _, tc := range modifySamOnErrorTC {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		var code int
+		var stdout string
+		stderr := capturer.CaptureStderr(func() {
+			stdout = capturer.CaptureStdout(func() {
+				code = ModifySam(tc.args, strings.NewReader(modifySamOnErrorInput))
+			})
+		})
+		assert.Equal(t, tc.expCode, code, tc.args)
+		assert.Equal(t, tc.expStdout, stdout, tc.args)
+		assert.Equal(t, tc.expStderr, stderr, tc.args)
+	}
+}
+
+// TestModifySamBamOnError tests function ModifySam with BAM input, skipping
+// alignments that cannot be filtered
+func TestModifySamBamOnError(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdinReader, _ := os.Open("../../data/test/input/modify-sam.bam")
+	var stdout string
+	stderr := capturer.CaptureStderr(func() {
+		stdout = capturer.CaptureStdout(func() {
+			assert.Equal(t, 0, ModifySam([]string{"-on-error", "skip", "-filter", "tag.MD > 3", "-class", "body"}, stdinReader))
+		})
+	})
+	assert.Equal(t, "", stdout)
+	assert.Equal(t, "WARNING: Skipped 30 malformed record(s)\n", stderr)
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdinReader, _ = os.Open("../../data/test/input/modify-sam.bam")
+	stdout = capturer.CaptureStdout(func() {
+		assert.Equal(t, 1, ModifySam([]string{"-filter", "tag.MD > 3", "-class", "body"}, stdinReader))
+	})
+	assert.True(t, strings.HasPrefix(stdout, "ERROR: Record 1: Filter expression"))
+}
+
+// captureLargeStdout captures stdout to a temporary file. Unlike capturer,
+// which buffers stdout in a pipe that is only drained once the function
+// returns, output is not limited to the pipe capacity
+func captureLargeStdout(t *testing.T, f func()) string {
+	file, err := ioutil.TempFile("", "modify-sam")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	stdout := os.Stdout
+	os.Stdout = file
+	f()
+	os.Stdout = stdout
+	file.Close()
+	output, err := ioutil.ReadFile(file.Name())
+	assert.Nil(t, err)
+	return string(output)
+}
+
+// longReadSam SAM with multi-megabase alignments, as produced by long-read
+// (eg. ONT, PacBio) sequencing, each on a line far longer than 64 KiB
+func longReadSam() (string, []string) {
+	header := []string{"@HD\tVN:1.6", "@SQ\tSN:chr1\tLN:10000000"}
+	alignments := []string{}
+	for i, length := range []int{2000000, 100, 3500000} {
+		seq := strings.Repeat("ACGT", length/4)
+		qual := strings.Repeat("F", length)
+		alignments = append(alignments, "read"+strconv.Itoa(i)+"\t0\tchr1\t1\t60\t"+strconv.Itoa(length)+"M\t*\t0\t0\t"+seq+"\t"+qual+"\tNM:i:"+strconv.Itoa(i))
+	}
+	return strings.Join(append(header, alignments...), "\n") + "\n", alignments
+}
+
+// TestModifySamLongReads tests function ModifySam with lines longer than
+// 64 KiB, for SAM and BAM input and output
+func TestModifySamLongReads(t *testing.T) {
+	input, alignments := longReadSam()
+	header := "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:10000000\n"
+
+	// SAM to SAM, unmodified and filtered
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout := captureLargeStdout(t, func() {
+		assert.Equal(t, 0, ModifySam([]string{}, strings.NewReader(input)))
+	})
+	assert.True(t, stdout == input, "unmodified long reads differ")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout = captureLargeStdout(t, func() {
+		assert.Equal(t, 0, ModifySam([]string{"-tag-filter", "NM>0"}, strings.NewReader(input)))
+	})
+	assert.True(t, stdout == header+alignments[1]+"\n"+alignments[2]+"\n", "filtered long reads differ")
+
+	// SAM to BAM, then BAM back to SAM
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	bamOutput := captureLargeStdout(t, func() {
+		assert.Equal(t, 0, ModifySam([]string{"-output-format", "BAM"}, strings.NewReader(input)))
+	})
+	assert.Equal(t, append([]string{"@HD\tVN:1.6", "@SQ\tSN:chr1\tLN:10000000"}, alignments...), decodeBamStdout(t, bamOutput))
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout = captureLargeStdout(t, func() {
+		assert.Equal(t, 0, ModifySam([]string{}, strings.NewReader(bamOutput)))
+	})
+	assert.True(t, stdout == input, "long reads from BAM differ")
+}
+
+// modifySamFailingReader returns data, then an error instead of io.EOF
+type modifySamFailingReader struct {
+	reader io.Reader
+}
+
+// Read reads from the wrapped reader, failing at its end
+func (failingReader *modifySamFailingReader) Read(p []byte) (int, error) {
+	n, err := failingReader.reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("read failed")
+	}
+	return n, err
+}
+
+// TestModifySamReadError tests that function ModifySam fails on input read
+// errors, rather than exiting successfully with truncated output
+func TestModifySamReadError(t *testing.T) {
+	input, _ := longReadSam()
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout := captureLargeStdout(t, func() {
+		assert.Equal(t, 1, ModifySam([]string{}, &modifySamFailingReader{strings.NewReader(input)}))
+	})
+	assert.True(t, strings.HasSuffix(stdout, "ERROR: read failed\n"))
+}
+
+// benchmarkModifySamRecords number of alignments in benchmark inputs
+const benchmarkModifySamRecords = 30000
+
+// benchmarkModifySamInput builds a benchmark input from the alignments of the
+// SAM test input, repeated to benchmarkModifySamRecords alignments, encoded as
+// SAM or BAM
+func benchmarkModifySamInput(b testing.TB, format string) []byte {
+	data, err := ioutil.ReadFile("../../data/test/input/modify-sam.sam")
+	if err != nil {
+		b.Fatal(err)
+	}
+	headerLines := []string{}
+	alignments := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if strings.HasPrefix(line, "@") {
+			headerLines = append(headerLines, line)
+		} else {
+			alignments = append(alignments, line)
+		}
+	}
+
+	var input bytes.Buffer
+	if format == htsformats.FormatSam {
+		input.WriteString(strings.Join(headerLines, "\n") + "\n")
+		for i := 0; i < benchmarkModifySamRecords; i++ {
+			input.WriteString(alignments[i%len(alignments)] + "\n")
+		}
+		return input.Bytes()
+	}
+	bamWriter := htsformats.NewBamWriter(htsformats.NewBgzfWriter(&input))
+	bamWriter.WriteHeader(headerLines)
+	for i := 0; i < benchmarkModifySamRecords; i++ {
+		samRecord, _ := htsformats.NewSamRecord(alignments[i%len(alignments)])
+		bamWriter.Write(samRecord)
+	}
+	bamWriter.Close()
+	return input.Bytes()
+}
+
+// benchmarkModifySam benchmarks function ModifySam over SAM or BAM input, with
+// stdout discarded, reporting alignments processed per second
+func benchmarkModifySam(b *testing.B, format string, args []string) {
+	data := benchmarkModifySamInput(b, format)
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	// each run processes every alignment of the input, so b.N is rounded up
+	// to a whole number of runs
+	runs := (b.N + benchmarkModifySamRecords - 1) / benchmarkModifySamRecords
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)) / benchmarkModifySamRecords)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < runs; i++ {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		if ModifySam(args, bytes.NewReader(data)) != 0 {
+			b.Fatal("modify-sam failed")
+		}
+	}
+	b.ReportMetric(float64(runs*benchmarkModifySamRecords)/time.Since(start).Seconds(), "records/s")
+}
+
+// BenchmarkModifySamSam benchmarks SAM input to SAM output
+func BenchmarkModifySamSam(b *testing.B) {
+	benchmarkModifySam(b, htsformats.FormatSam, []string{})
+}
+
+// BenchmarkModifySamSamCustom benchmarks SAM input to SAM output with custom
+// fields and tags
+func BenchmarkModifySamSamCustom(b *testing.B) {
+	benchmarkModifySam(b, htsformats.FormatSam, []string{"-fields", "QNAME,FLAG,RNAME,POS,CIGAR", "-notags", "MD,HI"})
+}
+
+// BenchmarkModifySamBam benchmarks BAM input to SAM output
+func BenchmarkModifySamBam(b *testing.B) {
+	benchmarkModifySam(b, htsformats.FormatBam, []string{})
+}
+
+// BenchmarkModifySamOutputBam benchmarks SAM input to BAM output
+func BenchmarkModifySamOutputBam(b *testing.B) {
+	benchmarkModifySam(b, htsformats.FormatSam, []string{"-output-format", "BAM"})
+}
+
+// TestModifySamThreadsLargeInput tests function ModifySam on multiple
+// goroutines with input spanning many batches and BGZF blocks, which is
+// expected to produce the same output as sequential processing
+func TestModifySamThreadsLargeInput(t *testing.T) {
+	for _, format := range []string{htsformats.FormatSam, htsformats.FormatBam} {
+		input := benchmarkModifySamInput(t, format)
+		for _, args := range [][]string{
+			{},
+			{"-fields", "QNAME,FLAG,RNAME,POS", "-filter", "mapq > 3"},
+			{"-output-format", "BAM"},
+		} {
+			outputs := []string{}
+			for _, threads := range []string{"1", "4"} {
+				flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+				outputs = append(outputs, captureLargeStdout(t, func() {
+					assert.Equal(t, 0, ModifySam(append([]string{"-threads", threads}, args...), bytes.NewReader(input)))
+				}))
+			}
+			assert.True(t, len(outputs[0]) > 0)
+			assert.True(t, outputs[0] == outputs[1], "output with threads differs", format, args)
+		}
+	}
+}
+
+// TestModifySamThreadsError tests function ModifySam with an invalid number of
+// threads
+func TestModifySamThreadsError(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout := capturer.CaptureStdout(func() {
+		assert.Equal(t, 1, ModifySam([]string{"-threads", "0"}, strings.NewReader("")))
+	})
+	assert.Equal(t, "ERROR: 'threads' must be at least 1\n", stdout)
+}
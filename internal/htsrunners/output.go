@@ -0,0 +1,255 @@
+// Package htsrunners contains cli subcommands
+//
+// Module output writes the header and modified alignments of a stream to
+// stdout in the requested output format
+package htsrunners
+
+import (
+	"errors"
+	"io"
+
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
+)
+
+// alignmentOutput writes header lines and alignments, modified according to a
+// SamRecordEmitter, in a specific output format. setHeader provides the header
+// to the output without writing it, and writeLine writes an alignment line
+// that could not be parsed unmodified. fork constructs an equivalent output
+// writing alignments uncompressed to another writer, so that alignments can be
+// written concurrently and later copied to this output with writeRaw
+type alignmentOutput interface {
+	writeHeader(headerLines []string) error
+	setHeader(headerLines []string) error
+	writeRecord(samRecord *htsformats.SamRecord) error
+	writeLine(line string) error
+	writeRaw(data []byte) error
+	fork(writer io.Writer) alignmentOutput
+	close() error
+}
+
+// newAlignmentOutput constructs the alignmentOutput for the requested output
+// format (SAM or BAM), writing to a writer. The writer is expected to be
+// buffered, as SAM is written line by line. BAM is compressed on the given
+// number of goroutines
+func newAlignmentOutput(format string, samRecordEmitter *htsformats.SamRecordEmitter, writer io.Writer, threads int) (alignmentOutput, error) {
+	switch format {
+	case htsformats.FormatSam:
+		return &samOutput{samRecordEmitter, writer, nil}, nil
+	case htsformats.FormatBam:
+		bgzfWriter := htsformats.NewBgzfWriterThreads(writer, threads)
+		return &bamOutput{samRecordEmitter, htsformats.NewBamWriter(bgzfWriter), bgzfWriter}, nil
+	}
+	return nil, errors.New("Invalid output format: '" + format + "'")
+}
+
+// samOutput writes plain text SAM. Each line is built in a byte buffer that is
+// reused across lines
+type samOutput struct {
+	samRecordEmitter *htsformats.SamRecordEmitter
+	writer           io.Writer
+	buf              []byte
+}
+
+// writeHeader writes each header line without modification
+func (output *samOutput) writeHeader(headerLines []string) error {
+	for _, line := range headerLines {
+		if err := output.writeLine(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setHeader is a no-op, as SAM alignments do not depend on the header
+func (output *samOutput) setHeader(headerLines []string) error {
+	return nil
+}
+
+// writeRecord writes the modified alignment
+func (output *samOutput) writeRecord(samRecord *htsformats.SamRecord) error {
+	output.buf = output.samRecordEmitter.AppendCustomEmit(output.buf[:0], samRecord)
+	output.buf = append(output.buf, '\n')
+	_, err := output.writer.Write(output.buf)
+	return err
+}
+
+// writeLine writes the line without modification
+func (output *samOutput) writeLine(line string) error {
+	output.buf = append(append(output.buf[:0], line...), '\n')
+	_, err := output.writer.Write(output.buf)
+	return err
+}
+
+// writeRaw writes SAM lines written by a fork
+func (output *samOutput) writeRaw(data []byte) error {
+	_, err := output.writer.Write(data)
+	return err
+}
+
+// fork constructs a samOutput writing to another writer
+func (output *samOutput) fork(writer io.Writer) alignmentOutput {
+	return &samOutput{output.samRecordEmitter, writer, nil}
+}
+
+// close is a no-op, as the writer is flushed by its owner
+func (output *samOutput) close() error {
+	return nil
+}
+
+// bamOutput writes BAM, BGZF-compressed unless forked
+type bamOutput struct {
+	samRecordEmitter *htsformats.SamRecordEmitter
+	bamWriter        *htsformats.BamWriter
+	writer           io.Writer
+}
+
+// writeHeader writes the BAM header and reference dictionary
+func (output *bamOutput) writeHeader(headerLines []string) error {
+	return output.bamWriter.WriteHeader(headerLines)
+}
+
+// setHeader registers the reference dictionary used to encode alignments,
+// without writing the header
+func (output *bamOutput) setHeader(headerLines []string) error {
+	return output.bamWriter.SetReferences(headerLines)
+}
+
+// writeRecord encodes and writes the modified alignment
+func (output *bamOutput) writeRecord(samRecord *htsformats.SamRecord) error {
+	emitted, err := output.samRecordEmitter.CustomEmitRecord(samRecord)
+	if err != nil {
+		return err
+	}
+	return output.bamWriter.Write(emitted)
+}
+
+// writeLine fails, as lines that could not be parsed cannot be encoded
+func (output *bamOutput) writeLine(line string) error {
+	return errors.New("Cannot write unparsed line as BAM")
+}
+
+// writeRaw writes uncompressed alignments written by a fork
+func (output *bamOutput) writeRaw(data []byte) error {
+	_, err := output.writer.Write(data)
+	return err
+}
+
+// fork constructs a bamOutput writing uncompressed alignments to another
+// writer. The header must be set on the fork to encode reference ids
+func (output *bamOutput) fork(writer io.Writer) alignmentOutput {
+	return &bamOutput{output.samRecordEmitter, htsformats.NewBamWriter(writer), writer}
+}
+
+// close flushes remaining BGZF blocks and writes the EOF marker
+func (output *bamOutput) close() error {
+	return output.bamWriter.Close()
+}
+
+// filteredOutput wraps an alignmentOutput, only writing alignments that match
+// the filter
+type filteredOutput struct {
+	alignmentOutput
+	filter htsformats.SamRecordFilter
+}
+
+// writeRecord writes the alignment to the wrapped output if it matches
+func (output *filteredOutput) writeRecord(samRecord *htsformats.SamRecord) error {
+	matches, err := output.filter.Matches(samRecord)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return nil
+	}
+	return output.alignmentOutput.writeRecord(samRecord)
+}
+
+// fork constructs a filteredOutput wrapping a fork of the wrapped output
+func (output *filteredOutput) fork(writer io.Writer) alignmentOutput {
+	return &filteredOutput{output.alignmentOutput.fork(writer), output.filter}
+}
+
+// mateOutput wraps an alignmentOutput, clearing the mate reference (RNEXT)
+// and position (PNEXT) of alignments whose mate is on a reference removed
+// from the header, which would otherwise name a missing @SQ line
+type mateOutput struct {
+	alignmentOutput
+	referenceNames map[string]bool
+}
+
+// newMateOutput constructs a mateOutput keeping mates on the named references
+func newMateOutput(output alignmentOutput, referenceNames []string) *mateOutput {
+	mateOutput := new(mateOutput)
+	mateOutput.alignmentOutput = output
+	mateOutput.referenceNames = make(map[string]bool)
+	for _, referenceName := range referenceNames {
+		mateOutput.referenceNames[referenceName] = true
+	}
+	return mateOutput
+}
+
+// writeRecord writes the alignment to the wrapped output, with RNEXT set to
+// '*' and PNEXT to 0 if the mate's reference was removed
+func (output *mateOutput) writeRecord(samRecord *htsformats.SamRecord) error {
+	rnext := samRecord.RNext()
+	if rnext != "*" && rnext != "=" && !output.referenceNames[rnext] {
+		if err := samRecord.SetRNext("*"); err != nil {
+			return err
+		}
+		if err := samRecord.SetPNext(0); err != nil {
+			return err
+		}
+	}
+	return output.alignmentOutput.writeRecord(samRecord)
+}
+
+// fork constructs a mateOutput wrapping a fork of the wrapped output
+func (output *mateOutput) fork(writer io.Writer) alignmentOutput {
+	return &mateOutput{output.alignmentOutput.fork(writer), output.referenceNames}
+}
+
+// bodyOutput wraps an alignmentOutput, suppressing the header so that only
+// alignments are written
+type bodyOutput struct {
+	alignmentOutput
+}
+
+// writeHeader registers the header with the wrapped output without writing it
+func (output *bodyOutput) writeHeader(headerLines []string) error {
+	return output.alignmentOutput.setHeader(headerLines)
+}
+
+// fork constructs a bodyOutput wrapping a fork of the wrapped output
+func (output *bodyOutput) fork(writer io.Writer) alignmentOutput {
+	return &bodyOutput{output.alignmentOutput.fork(writer)}
+}
+
+// headerOutput wraps an alignmentOutput, rewriting the header before it is
+// written to, or registered with, the wrapped output
+type headerOutput struct {
+	alignmentOutput
+	rewrite func(headerLines []string) ([]string, error)
+}
+
+// writeHeader writes the rewritten header to the wrapped output
+func (output *headerOutput) writeHeader(headerLines []string) error {
+	headerLines, err := output.rewrite(headerLines)
+	if err != nil {
+		return err
+	}
+	return output.alignmentOutput.writeHeader(headerLines)
+}
+
+// setHeader registers the rewritten header with the wrapped output
+func (output *headerOutput) setHeader(headerLines []string) error {
+	headerLines, err := output.rewrite(headerLines)
+	if err != nil {
+		return err
+	}
+	return output.alignmentOutput.setHeader(headerLines)
+}
+
+// fork constructs a headerOutput wrapping a fork of the wrapped output
+func (output *headerOutput) fork(writer io.Writer) alignmentOutput {
+	return &headerOutput{output.alignmentOutput.fork(writer), output.rewrite}
+}
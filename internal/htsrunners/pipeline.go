@@ -0,0 +1,174 @@
+// Package htsrunners contains cli subcommands
+//
+// Module pipeline processes alignments in batches on worker goroutines,
+// writing the output of each batch in input order
+package htsrunners
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// recordBatchSize maximum number of alignments in a batch
+var recordBatchSize = 1000
+
+// recordBatch a batch of consecutive alignments, as SAM lines or binary BAM
+// records, along with the output and failures of processing them. number is
+// the line or record number of the first alignment, and done is closed once
+// the batch has been processed
+type recordBatch struct {
+	number   int
+	lines    []string
+	records  [][]byte
+	data     []byte
+	failures []recordFailure
+	done     chan struct{}
+}
+
+// size gets the number of alignments in the batch
+func (batch *recordBatch) size() int {
+	return len(batch.lines) + len(batch.records)
+}
+
+// recordFailure an alignment that could not be processed, at an offset into
+// the output of its batch. Fatal failures end the stream, regardless of the
+// record error policy
+type recordFailure struct {
+	offset   int
+	location string
+	line     string
+	err      error
+	fatal    bool
+}
+
+// recordProcessor processes the i-th alignment of a batch, writing it to an
+// output. Returns a failure if the alignment could not be processed
+type recordProcessor func(output alignmentOutput, batch *recordBatch, i int) *recordFailure
+
+// recordPipeline processes batches of alignments on worker goroutines, each
+// writing to a fork of the output. Batch output is then written to the output
+// in input order, with failures passed to the record error handler
+type recordPipeline struct {
+	output      alignmentOutput
+	headerLines []string
+	handler     *recordErrorHandler
+	process     recordProcessor
+	threads     int
+}
+
+// newRecordPipeline constructs a recordPipeline over an output, on which the
+// header has already been written
+func newRecordPipeline(output alignmentOutput, headerLines []string, handler *recordErrorHandler, process recordProcessor, threads int) *recordPipeline {
+	pipeline := new(recordPipeline)
+	pipeline.output = output
+	pipeline.headerLines = headerLines
+	pipeline.handler = handler
+	pipeline.process = process
+	pipeline.threads = threads
+	return pipeline
+}
+
+// run processes batches filled by read until it returns an error, io.EOF at
+// the end of input. Returns the first error writing the output, or otherwise
+// the error from read, once all alignments read have been written
+func (pipeline *recordPipeline) run(read func(batch *recordBatch) error) error {
+
+	// each worker writes to a fork of the output over its own buffer
+	work := make(chan *recordBatch, pipeline.threads)
+	var workers sync.WaitGroup
+	for i := 0; i < pipeline.threads; i++ {
+		buffer := new(bytes.Buffer)
+		output := pipeline.output.fork(buffer)
+		if err := output.setHeader(pipeline.headerLines); err != nil {
+			close(work)
+			workers.Wait()
+			return err
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for batch := range work {
+				pipeline.processBatch(output, buffer, batch)
+			}
+		}()
+	}
+
+	// batches are queued for writing in the order they are read, bounding the
+	// number of batches in progress
+	ordered := make(chan *recordBatch, 2*pipeline.threads)
+	stopped := make(chan struct{})
+	writeErr := make(chan error, 1)
+	go func() {
+		defer close(stopped)
+		writeErr <- pipeline.write(ordered)
+	}()
+
+	var readErr error
+	for readErr == nil {
+		batch := &recordBatch{done: make(chan struct{})}
+		readErr = read(batch)
+		if batch.size() == 0 {
+			break
+		}
+		select {
+		case ordered <- batch:
+			work <- batch
+		case <-stopped:
+			readErr = io.EOF
+		}
+	}
+	close(work)
+	close(ordered)
+	err := <-writeErr
+	workers.Wait()
+	if err == nil && readErr != io.EOF {
+		err = readErr
+	}
+	return err
+}
+
+// processBatch processes each alignment of a batch, writing to a fork of the
+// output over a buffer. Processing stops at the first fatal failure
+func (pipeline *recordPipeline) processBatch(output alignmentOutput, buffer *bytes.Buffer, batch *recordBatch) {
+	buffer.Reset()
+	for i := 0; i < batch.size(); i++ {
+		failure := pipeline.process(output, batch, i)
+		if failure == nil {
+			continue
+		}
+		failure.offset = buffer.Len()
+		batch.failures = append(batch.failures, *failure)
+		if failure.fatal {
+			break
+		}
+	}
+	batch.data = append([]byte{}, buffer.Bytes()...)
+	close(batch.done)
+}
+
+// write writes the output of each batch once processed, in order. Output
+// preceding each failure is written before the failure is handled, so that
+// passed through lines keep their position
+func (pipeline *recordPipeline) write(ordered <-chan *recordBatch) error {
+	for batch := range ordered {
+		<-batch.done
+		offset := 0
+		for _, failure := range batch.failures {
+			if err := pipeline.output.writeRaw(batch.data[offset:failure.offset]); err != nil {
+				return err
+			}
+			offset = failure.offset
+			if failure.fatal {
+				return failure.err
+			}
+			if err := pipeline.handler.handle(failure.location, failure.line, failure.err); err != nil {
+				return err
+			}
+		}
+		if err := pipeline.output.writeRaw(batch.data[offset:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Package htsrunners contains cli subcommands
+//
+// Module recorderror applies the policy for alignments that cannot be parsed
+// or processed: failing the stream, skipping them, or passing them through
+package htsrunners
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// record error policies
+const (
+	onErrorFail        = "fail"
+	onErrorSkip        = "skip"
+	onErrorPassthrough = "passthrough"
+)
+
+// recordErrorHandler applies a record error policy to an output, counting the
+// records skipped or passed through
+type recordErrorHandler struct {
+	policy  string
+	output  alignmentOutput
+	skipped int
+	passed  int
+}
+
+// recordParseError an alignment line that could not be parsed, the only kind
+// of record passed through, as parsed records may have failed a filter
+type recordParseError struct {
+	error
+}
+
+// newRecordErrorHandler constructs a recordErrorHandler for a policy
+func newRecordErrorHandler(policy string, output alignmentOutput) (*recordErrorHandler, error) {
+	switch policy {
+	case onErrorFail, onErrorSkip, onErrorPassthrough:
+	default:
+		return nil, errors.New("Invalid on-error policy: '" + policy + "'")
+	}
+	handler := new(recordErrorHandler)
+	handler.policy = policy
+	handler.output = output
+	return handler, nil
+}
+
+// handle applies the policy to an error for the record at a location (eg.
+// 'Line 12'). In fail mode the error is returned with its location, otherwise
+// the record is counted and skipped, or its line written unmodified if it
+// could not be parsed. Records that were parsed but could not be filtered or
+// written are skipped in passthrough mode, so that filters are never bypassed
+func (handler *recordErrorHandler) handle(location string, line string, err error) error {
+	switch handler.policy {
+	case onErrorSkip:
+		handler.skipped++
+		return nil
+	case onErrorPassthrough:
+		if _, ok := err.(recordParseError); !ok {
+			handler.skipped++
+			return nil
+		}
+		handler.passed++
+		return handler.output.writeLine(line)
+	}
+	return errors.New(location + ": " + err.Error())
+}
+
+// report prints the number of records passed through and skipped to stderr
+func (handler *recordErrorHandler) report() {
+	if handler.passed > 0 {
+		fmt.Fprintln(os.Stderr, "WARNING: Passed through "+strconv.Itoa(handler.passed)+" malformed record(s)")
+	}
+	if handler.skipped > 0 {
+		fmt.Fprintln(os.Stderr, "WARNING: Skipped "+strconv.Itoa(handler.skipped)+" malformed record(s)")
+	}
+}
@@ -0,0 +1,316 @@
+// Package htsrunners contains cli subcommands
+//
+// Module ticket contains the ticket subcommand, in which an htsget ticket
+// (JSON response) is constructed for a SAM or BAM file, with data URLs built
+// from a configurable template
+package htsrunners
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
+)
+
+// ticketDefaultURLTemplate template of data URLs, unless specified by flag,
+// pointing directly to the file
+const ticketDefaultURLTemplate = "file://{path}"
+
+// ticketURL a single data URL of an htsget ticket, with the headers the client
+// must send and the class of data returned
+type ticketURL struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Class   string            `json:"class,omitempty"`
+}
+
+// ticketResponse body of an htsget ticket
+type ticketResponse struct {
+	Format string      `json:"format"`
+	URLs   []ticketURL `json:"urls"`
+}
+
+// ticket JSON htsget ticket, as returned by an htsget server
+type ticket struct {
+	Htsget ticketResponse `json:"htsget"`
+}
+
+// ticketParams request parameters substituted into the URL template
+type ticketParams struct {
+	path    string
+	format  string
+	fields  string
+	tags    string
+	notags  string
+	regions []*htsformats.Region
+}
+
+// ticketDataSegments locates the header and alignments within the file, also
+// getting its size. Plain text SAM and BAM are supported, as htsget data must
+// be concatenable
+func ticketDataSegments(file *os.File, format string) ([]htsformats.DataSegment, []htsformats.DataSegment, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if format == htsformats.FormatBam {
+		header, body, err := htsformats.BamDataSegments(file, info.Size())
+		return header, body, info.Size(), err
+	}
+	magic := make([]byte, 2)
+	if n, _ := file.ReadAt(magic, 0); n == 2 && magic[0] == 31 && magic[1] == 139 {
+		return nil, nil, 0, errors.New("BGZF-compressed SAM is not supported")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, 0, err
+	}
+	header, body, err := htsformats.SamDataRanges(file, info.Size())
+	return []htsformats.DataSegment{{Range: header}}, []htsformats.DataSegment{{Range: body}}, info.Size(), err
+}
+
+// ticketURLFor builds a data URL from the template, substituting {path},
+// {format}, {class}, {referenceName}, {start}, {end}, {fields}, {tags} and
+// {notags}. Unset values are substituted as empty strings. Data recompressed
+// rather than located within the file is embedded as a data URL instead
+func ticketURLFor(template string, params *ticketParams, class string, region *htsformats.Region, dataSegment htsformats.DataSegment) ticketURL {
+	if dataSegment.Data != nil {
+		return ticketURL{URL: ticketDataURLPrefix + base64.StdEncoding.EncodeToString(dataSegment.Data), Class: class}
+	}
+	referenceName, start, end := "", "", ""
+	if region != nil {
+		referenceName = region.ReferenceName
+		if region.Start != htsformats.RegionUnbounded {
+			start = strconv.Itoa(region.Start)
+		}
+		if region.End != htsformats.RegionUnbounded {
+			end = strconv.Itoa(region.End)
+		}
+	}
+	replacer := strings.NewReplacer(
+		"{path}", (&url.URL{Path: params.path}).EscapedPath(),
+		"{format}", url.QueryEscape(params.format),
+		"{class}", url.QueryEscape(class),
+		"{referenceName}", url.QueryEscape(referenceName),
+		"{start}", start,
+		"{end}", end,
+		"{fields}", url.QueryEscape(params.fields),
+		"{tags}", url.QueryEscape(params.tags),
+		"{notags}", url.QueryEscape(params.notags),
+	)
+	return ticketURL{
+		URL:     replacer.Replace(template),
+		Headers: map[string]string{"Range": dataSegment.Range.HTTPRange()},
+		Class:   class,
+	}
+}
+
+// ticketDataURLPrefix prefix of data URLs embedding binary data in a ticket
+const ticketDataURLPrefix = "data:application/octet-stream;base64,"
+
+// ticketEOFURL data URL of the BGZF EOF marker block, terminating BAM data
+// assembled from index chunks, which do not include it
+const ticketEOFURL = ticketDataURLPrefix + "H4sIBAAAAAAA/wYAQkMCABsAAwAAAAAAAAAAAA=="
+
+// ticketPart the data segments holding the alignments of a region, or of all
+// alignments if the region is nil
+type ticketPart struct {
+	region       *htsformats.Region
+	dataSegments []htsformats.DataSegment
+}
+
+// ticketIndexParts locates the alignments of each region within a BAM file by
+// querying its BAI or CSI index. Unplaced unmapped alignments follow those of every
+// reference, or are all of the body if the index locates no alignments
+func ticketIndexParts(file *os.File, indexPath string, regions []*htsformats.Region, body []htsformats.DataSegment, size int64) ([]ticketPart, error) {
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
+	alignmentIndex, err := htsformats.ReadAlignmentIndex(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	bamReader, err := htsformats.NewBamReader(htsformats.NewBgzfReader(file))
+	if err != nil {
+		return nil, err
+	}
+	referenceIDs := make(map[string]int)
+	for i, reference := range bamReader.References() {
+		referenceIDs[reference.Name] = i
+	}
+
+	parts := []ticketPart{}
+	for _, region := range regions {
+		var chunks []htsformats.IndexChunk
+		if region.ReferenceName == htsformats.UnplacedReferenceName {
+			unplacedOffset, ok := alignmentIndex.UnplacedOffset()
+			if !ok {
+				parts = append(parts, ticketPart{region, body})
+				continue
+			}
+			chunks = []htsformats.IndexChunk{{Begin: unplacedOffset, End: htsformats.NewVirtualOffset(size, 0)}}
+		} else {
+			refID, ok := referenceIDs[region.ReferenceName]
+			if !ok {
+				return nil, errors.New("Reference '" + region.ReferenceName + "' not found in header")
+			}
+			if chunks, err = alignmentIndex.Query(refID, region.Start, region.End); err != nil {
+				return nil, err
+			}
+		}
+		dataSegments, err := htsformats.BamChunkSegments(file, chunks)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, ticketPart{region, dataSegments})
+	}
+	return parts, nil
+}
+
+// ticketURLs builds header URLs, unless only the body was requested, and body
+// URLs for the data segments of each part, unless only the header was
+// requested. BAM data not ending with the end of the file is terminated by
+// the EOF marker block, in the header class if only the header was requested
+func ticketURLs(template string, params *ticketParams, class string, header []htsformats.DataSegment, parts []ticketPart, size int64) []ticketURL {
+	urls := []ticketURL{}
+	last, eofClass := htsformats.DataSegment{}, classHeader
+	if class != classBody {
+		for _, dataSegment := range header {
+			if !dataSegment.Empty() {
+				urls = append(urls, ticketURLFor(template, params, classHeader, nil, dataSegment))
+				last = dataSegment
+			}
+		}
+	}
+	if class != classHeader {
+		eofClass = classBody
+		for _, part := range parts {
+			for _, dataSegment := range part.dataSegments {
+				if !dataSegment.Empty() {
+					urls = append(urls, ticketURLFor(template, params, classBody, part.region, dataSegment))
+					last = dataSegment
+				}
+			}
+		}
+	}
+	if params.format == htsformats.FormatBam && (last.Data != nil || last.Range.End != size) {
+		urls = append(urls, ticketURL{URL: ticketEOFURL, Class: eofClass})
+	}
+	return urls
+}
+
+// Ticket runner for 'ticket' subcommand. Writes an htsget ticket for a SAM or
+// BAM file to stdout, with data URLs for the header and for the alignments,
+// located for each requested region given an index, and Range headers locating
+// them within the file
+func Ticket(args []string) int {
+
+	// parses cli args
+	pathPtr := flag.String("path", "", "path of the SAM or BAM file")
+	formatPtr := flag.String("format", "", "file format, SAM or BAM. Detected from the file if not specified")
+	classPtr := flag.String("class", "", "htsget class, 'header' for only the header URL, 'body' for only alignment URLs")
+	fieldsPtr := flag.String("fields", "", "comma-delimited list of fields to include, substituted for {fields}")
+	tagsPtr := flag.String("tags", "", "comma-delimited list of tags to include, substituted for {tags}")
+	notagsPtr := flag.String("notags", "", "comma-delimited list of tags to exclude, substituted for {notags}")
+	referenceNamePtr := flag.String("referenceName", "", "request alignments on this reference, '*' for unplaced unmapped reads")
+	startPtr := flag.Int("start", htsformats.RegionUnbounded, "request alignments overlapping this 0-based start position (inclusive)")
+	endPtr := flag.Int("end", htsformats.RegionUnbounded, "request alignments overlapping this 0-based end position (exclusive)")
+	var regionsFlag repeatedFlag
+	flag.Var(&regionsFlag, "region", "request alignments overlapping this region, as name:start-end (0-based, end exclusive). May be repeated given an index")
+	regionsBedPtr := flag.String("regions-bed", "", "request alignments overlapping any region in this BED file")
+	indexPtr := flag.String("index", "", "path of the BAI or CSI index of a BAM file, to locate the alignments of each region")
+	urlTemplatePtr := flag.String("url-template", ticketDefaultURLTemplate, "template of data URLs, substituting {path}, {format}, {class}, {referenceName}, {start}, {end}, {fields}, {tags} and {notags}")
+	flag.CommandLine.Parse(args)
+
+	if *pathPtr == "" {
+		fmt.Println("ERROR: 'path' is required")
+		return 1
+	}
+	if *classPtr != "" && *classPtr != classHeader && *classPtr != classBody {
+		fmt.Println("ERROR: Invalid class: '" + *classPtr + "'")
+		return 1
+	}
+	if _, err := htsformats.NewSamRecordEmitter(*fieldsPtr, *tagsPtr, *notagsPtr); err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	regions, err := modifySamRegions(*referenceNamePtr, *startPtr, *endPtr, regionsFlag, *regionsBedPtr)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	if len(regions) > 0 {
+		regions = htsformats.NewRegionSet(regions).Regions()
+	}
+
+	path, err := filepath.Abs(*pathPtr)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	defer file.Close()
+	_, format, err := htsformats.DetectFormat(file)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	if *formatPtr != "" && *formatPtr != format {
+		fmt.Println("ERROR: File format is " + format + ", not '" + *formatPtr + "'")
+		return 1
+	}
+	if *indexPtr != "" && format != htsformats.FormatBam {
+		fmt.Println("ERROR: 'index' requires a BAM file")
+		return 1
+	}
+	header, body, size, err := ticketDataSegments(file, format)
+	if err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+
+	// without an index, all alignments are located for a single region, which
+	// is substituted into the URL template for the server to filter them
+	if *indexPtr == "" && len(regions) > 1 {
+		fmt.Println("ERROR: Multiple regions require 'index'")
+		return 1
+	}
+	parts := []ticketPart{{nil, body}}
+	if len(regions) == 1 {
+		parts[0].region = regions[0]
+	}
+	if *indexPtr != "" && len(regions) > 0 {
+		if parts, err = ticketIndexParts(file, *indexPtr, regions, body, size); err != nil {
+			fmt.Println("ERROR: " + err.Error())
+			return 1
+		}
+	}
+
+	params := &ticketParams{path, format, *fieldsPtr, *tagsPtr, *notagsPtr, regions}
+	response := ticket{ticketResponse{format, ticketURLs(*urlTemplatePtr, params, *classPtr, header, parts, size)}}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(response); err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	return 0
+}
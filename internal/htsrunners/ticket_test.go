@@ -0,0 +1,279 @@
+// Package htsrunners contains cli subcommands
+//
+// Module ticket_test tests ticket
+package htsrunners
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
+	"github.com/kami-zh/go-capturer"
+	"github.com/stretchr/testify/assert"
+)
+
+// ticketTestTemplate URL template used by Ticket test cases
+var ticketTestTemplate = "https://htsget.example.org/reads/data/sample?format={format}&class={class}&referenceName={referenceName}&start={start}&end={end}&fields={fields}&tags={tags}&notags={notags}"
+
+// ticketTC test cases for Ticket, by input file. Test files have the header
+// and the first alignments in the first BGZF block, so the BAM header and
+// alignments are split into data URLs, expected by their prefix only. The
+// data assembled from tickets with a header is expected to hold a number of
+// alignments, unchecked if negative
+var ticketTC = []struct {
+	args       []string
+	expFormat  string
+	expURLs    []ticketURL
+	expRecords int
+}{
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.sam"},
+		"SAM",
+		[]ticketURL{
+			{"https://htsget.example.org/reads/data/sample?format=SAM&class=header&referenceName=&start=&end=&fields=&tags=&notags=", map[string]string{"Range": "bytes=0-1728"}, "header"},
+			{"https://htsget.example.org/reads/data/sample?format=SAM&class=body&referenceName=&start=&end=&fields=&tags=&notags=", map[string]string{"Range": "bytes=1729-11148"}, "body"},
+		},
+		30,
+	},
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.bam", "-class", "header"},
+		"BAM",
+		[]ticketURL{
+			{ticketDataURLPrefix, nil, "header"},
+			{ticketEOFURL, nil, "header"},
+		},
+		0,
+	},
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.bam", "-fields", "QNAME,FLAG", "-notags", "MD", "-region", "chr1:50-150", "-region", "chr1:100-200"},
+		"BAM",
+		[]ticketURL{
+			{ticketDataURLPrefix, nil, "header"},
+			{ticketDataURLPrefix, nil, "body"},
+			{"https://htsget.example.org/reads/data/sample?format=BAM&class=body&referenceName=chr1&start=50&end=200&fields=QNAME%2CFLAG&tags=&notags=MD", map[string]string{"Range": "bytes=1423-3312"}, "body"},
+		},
+		30,
+	},
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.sam", "-format", "SAM", "-class", "body", "-referenceName", "*"},
+		"SAM",
+		[]ticketURL{
+			{"https://htsget.example.org/reads/data/sample?format=SAM&class=body&referenceName=%2A&start=&end=&fields=&tags=&notags=", map[string]string{"Range": "bytes=1729-11148"}, "body"},
+		},
+		-1,
+	},
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.sam", "-region", "chr1:100-200"},
+		"SAM",
+		[]ticketURL{
+			{"https://htsget.example.org/reads/data/sample?format=SAM&class=header&referenceName=&start=&end=&fields=&tags=&notags=", map[string]string{"Range": "bytes=0-1728"}, "header"},
+			{"https://htsget.example.org/reads/data/sample?format=SAM&class=body&referenceName=chr1&start=100&end=200&fields=&tags=&notags=", map[string]string{"Range": "bytes=1729-11148"}, "body"},
+		},
+		30,
+	},
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.bam", "-index", "../../data/test/input/modify-sam.bam.bai", "-region", "chr1:4861000-4862000", "-region", "chr1:160203300-160203301"},
+		"BAM",
+		[]ticketURL{
+			{ticketDataURLPrefix, nil, "header"},
+			{ticketDataURLPrefix, nil, "body"},
+			{ticketDataURLPrefix, nil, "body"},
+			{ticketEOFURL, nil, "body"},
+		},
+		4,
+	},
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.bam", "-index", "../../data/test/input/modify-sam.bam.bai", "-class", "body", "-region", "chr1:0-100"},
+		"BAM",
+		[]ticketURL{
+			{ticketEOFURL, nil, "body"},
+		},
+		-1,
+	},
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.bam", "-index", "../../data/test/input/modify-sam.bam.csi", "-region", "chr1:24613600-24613700"},
+		"BAM",
+		[]ticketURL{
+			{ticketDataURLPrefix, nil, "header"},
+			{ticketDataURLPrefix, nil, "body"},
+			{ticketDataURLPrefix, nil, "body"},
+			{ticketEOFURL, nil, "body"},
+		},
+		20,
+	},
+	{
+		[]string{"-path", "../../data/test/input/modify-sam.bam", "-index", "../../data/test/input/modify-sam.bam.bai", "-class", "body", "-referenceName", "*"},
+		"BAM",
+		[]ticketURL{
+			{"https://htsget.example.org/reads/data/sample?format=BAM&class=body&referenceName=%2A&start=&end=&fields=&tags=&notags=", map[string]string{"Range": "bytes=3285-3312"}, "body"},
+		},
+		-1,
+	},
+}
+
+// ticketErrorTC test cases for Ticket given invalid arguments
+var ticketErrorTC = []struct {
+	args      []string
+	expStdout string
+}{
+	{[]string{}, "ERROR: 'path' is required\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.sam", "-class", "all"}, "ERROR: Invalid class: 'all'\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.sam", "-format", "BAM"}, "ERROR: File format is SAM, not 'BAM'\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.sam", "-start", "5"}, "ERROR: 'start' and 'end' require 'referenceName'\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.sam", "-fields", "NOTAFIELD"}, ""},
+	{[]string{"-path", "../../data/test/input/missing.bam"}, ""},
+	{[]string{"-path", "../../data/test/input/modify-sam.sam", "-index", "../../data/test/input/modify-sam.bam.bai"}, "ERROR: 'index' requires a BAM file\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.bam", "-region", "chr1:0-100", "-region", "chr2:0-100"}, "ERROR: Multiple regions require 'index'\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.bam", "-index", "../../data/test/input/modify-sam.bam.bai", "-region", "chrUn:0-100"}, "ERROR: Reference 'chrUn' not found in header\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.bam", "-index", "../../data/test/input/modify-sam.bam.bai", "-region", "chr1:600000000-600000100"}, "ERROR: Start position 600000000 is beyond the 536870912 (2^29) positions indexed by BAI, use a CSI index\n"},
+	{[]string{"-path", "../../data/test/input/modify-sam.bam", "-index", "../../data/test/input/modify-sam.sam", "-region", "chr1:0-100"}, "ERROR: Unrecognized index format, expected BAI or CSI\n"},
+}
+
+// ticketData assembles the data of a ticket for a test file, reading the
+// Range of each URL from the file and decoding data URLs
+func ticketData(t *testing.T, path string, urls []ticketURL) []byte {
+	file, _ := ioutil.ReadFile(path)
+	data := []byte{}
+	for _, ticketURL := range urls {
+		if strings.HasPrefix(ticketURL.URL, ticketDataURLPrefix) {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ticketURL.URL, ticketDataURLPrefix))
+			assert.Nil(t, err)
+			data = append(data, decoded...)
+			continue
+		}
+		var start, end int
+		fmt.Sscanf(ticketURL.Headers["Range"], "bytes=%d-%d", &start, &end)
+		data = append(data, file[start:end+1]...)
+	}
+	return data
+}
+
+// countTicketRecords counts the alignments of SAM or BAM data assembled from
+// a ticket, checking BAM data is a valid BGZF stream
+func countTicketRecords(t *testing.T, format string, data []byte) int {
+	count := 0
+	if format == htsformats.FormatSam {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" && !strings.HasPrefix(line, "@") {
+				count++
+			}
+		}
+		return count
+	}
+	bamReader, err := htsformats.NewBamReader(htsformats.NewBgzfReader(bytes.NewReader(data)))
+	assert.Nil(t, err)
+	for {
+		if _, err := bamReader.NextData(); err != nil {
+			assert.Equal(t, io.EOF, err)
+			return count
+		}
+		count++
+	}
+}
+
+// TestTicket tests function Ticket, and that the data of tickets concatenates
+// into a valid file holding each alignment once
+func TestTicket(t *testing.T) {
+	for _, tc := range ticketTC {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		var code int
+		stdout := capturer.CaptureStdout(func() {
+			code = Ticket(append(tc.args, "-url-template", ticketTestTemplate))
+		})
+		assert.Equal(t, 0, code, tc.args)
+		var actual ticket
+		assert.Nil(t, json.Unmarshal([]byte(stdout), &actual))
+		assert.Equal(t, tc.expFormat, actual.Htsget.Format, tc.args)
+		assert.Equal(t, len(tc.expURLs), len(actual.Htsget.URLs), tc.args)
+		for i := 0; i < len(tc.expURLs) && i < len(actual.Htsget.URLs); i++ {
+			expected, url := tc.expURLs[i], actual.Htsget.URLs[i]
+			if expected.URL == ticketDataURLPrefix && strings.HasPrefix(url.URL, ticketDataURLPrefix) {
+				expected.URL = url.URL
+			}
+			assert.Equal(t, expected, url, tc.args)
+		}
+		if tc.expRecords >= 0 {
+			data := ticketData(t, tc.args[1], actual.Htsget.URLs)
+			assert.Equal(t, tc.expRecords, countTicketRecords(t, tc.expFormat, data), tc.args)
+			if tc.expFormat == htsformats.FormatBam {
+				eof, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(ticketEOFURL, ticketDataURLPrefix))
+				assert.True(t, bytes.HasSuffix(data, eof), tc.args)
+			}
+		}
+	}
+}
+
+// TestTicketUnplacedIndex tests that unplaced unmapped alignments are all of
+// the alignments of a BAM file whose index locates none on any reference
+func TestTicketUnplacedIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ticket")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var compressed bytes.Buffer
+	bamWriter := htsformats.NewBamWriter(htsformats.NewBgzfWriter(&compressed))
+	assert.Nil(t, bamWriter.WriteHeader([]string{"@HD\tVN:1.6\tSO:coordinate", "@SQ\tSN:chr1\tLN:1000"}))
+	for _, qname := range []string{"r1", "r2"} {
+		record, _ := htsformats.NewSamRecord(qname + "\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\tFFFF")
+		assert.Nil(t, bamWriter.Write(record))
+	}
+	assert.Nil(t, bamWriter.Close())
+	path := filepath.Join(dir, "unplaced.bam")
+	assert.Nil(t, ioutil.WriteFile(path, compressed.Bytes(), 0644))
+	csiIndex, err := htsformats.BuildCsi(bytes.NewReader(compressed.Bytes()), htsformats.CsiDefaultMinShift, 0)
+	assert.Nil(t, err)
+	var index bytes.Buffer
+	assert.Nil(t, htsformats.WriteCsi(&index, csiIndex))
+	assert.Nil(t, ioutil.WriteFile(path+".csi", index.Bytes(), 0644))
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout := capturer.CaptureStdout(func() {
+		assert.Equal(t, 0, Ticket([]string{"-path", path, "-index", path + ".csi", "-referenceName", "*"}))
+	})
+	var actual ticket
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &actual))
+	for _, url := range actual.Htsget.URLs[1:] {
+		assert.Equal(t, "body", url.Class)
+	}
+	assert.Equal(t, 2, countTicketRecords(t, htsformats.FormatBam, ticketData(t, path, actual.Htsget.URLs)))
+}
+
+// TestTicketDefaultTemplate tests that data URLs default to the file itself
+func TestTicketDefaultTemplate(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	stdout := capturer.CaptureStdout(func() {
+		assert.Equal(t, 0, Ticket([]string{"-path", "../../data/test/input/modify-sam.sam", "-class", "header"}))
+	})
+	var actual ticket
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &actual))
+	path, _ := filepath.Abs("../../data/test/input/modify-sam.sam")
+	assert.Equal(t, "SAM", actual.Htsget.Format)
+	assert.Equal(t, "file://"+path, actual.Htsget.URLs[0].URL)
+}
+
+// TestTicketError tests function Ticket given invalid arguments. An empty
+// expected output only checks that an error is reported
+func TestTicketError(t *testing.T) {
+	for _, tc := range ticketErrorTC {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		var code int
+		stdout := capturer.CaptureStdout(func() {
+			code = Ticket(tc.args)
+		})
+		assert.Equal(t, 1, code, tc.args)
+		if tc.expStdout == "" {
+			assert.Contains(t, stdout, "ERROR: ")
+		} else {
+			assert.Equal(t, tc.expStdout, stdout)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package htsrunners contains cli subcommands
+//
+// Module validatesam contains the validate-sam subcommand, in which a SAM or
+// BAM file is streamed from stdin, checked against the SAM specification, and
+// a JSON report of violations is written to stdout
+package htsrunners
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
+)
+
+// validateSamReport JSON report of validate-sam. Violations are listed up to
+// a maximum, with Truncated set if more were found
+type validateSamReport struct {
+	Valid          bool                      `json:"valid"`
+	Records        int                       `json:"records"`
+	ViolationCount int                       `json:"violationCount"`
+	Truncated      bool                      `json:"truncated"`
+	Violations     []htsformats.SamViolation `json:"violations"`
+	Error          string                    `json:"error,omitempty"`
+}
+
+// add adds violations to the report, up to a maximum number listed
+func (report *validateSamReport) add(violations []htsformats.SamViolation, maxViolations int) {
+	report.ViolationCount += len(violations)
+	for _, violation := range violations {
+		if len(report.Violations) >= maxViolations {
+			report.Truncated = true
+			return
+		}
+		report.Violations = append(report.Violations, violation)
+	}
+}
+
+// validateSamText validates plain text SAM line by line
+func validateSamText(report *validateSamReport, reader io.Reader, maxViolations int) error {
+	samReader, err := htsformats.NewSamReader(reader)
+	if err != nil {
+		return err
+	}
+	samValidator := htsformats.NewSamValidator()
+	report.add(samValidator.ValidateHeader(1, samReader.HeaderLines()), maxViolations)
+	for {
+		text, err := samReader.NextLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.Records++
+		report.add(samValidator.ValidateAlignment(samReader.LineNumber(), text), maxViolations)
+	}
+}
+
+// validateSamBam validates decompressed BAM. Line numbers are those of the
+// equivalent SAM, ie. header lines followed by one line per alignment
+func validateSamBam(report *validateSamReport, reader io.Reader, maxViolations int) error {
+	bamReader, err := htsformats.NewBamReader(reader)
+	if err != nil {
+		return err
+	}
+	samValidator := htsformats.NewSamValidator()
+	headerLines := bamReader.HeaderLines()
+	report.add(samValidator.ValidateHeader(1, headerLines), maxViolations)
+
+	samRecordEmitter, _ := htsformats.NewSamRecordEmitter("", "", "")
+	lineNumber := len(headerLines)
+	for {
+		samRecord, err := bamReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		lineNumber++
+		report.Records++
+		report.add(samValidator.ValidateAlignment(lineNumber, samRecordEmitter.CustomEmit(samRecord)), maxViolations)
+	}
+}
+
+// ValidateSam runner for 'validate-sam' subcommand. Streams a SAM or BAM file
+// from stdin and writes a JSON report of violations of the SAM specification
+// to stdout. Exits non-zero if any violations were found
+func ValidateSam(args []string, reader io.Reader) int {
+
+	// parses cli args
+	maxViolationsPtr := flag.Int("max-violations", 1000, "maximum number of violations listed in the report")
+	flag.CommandLine.Parse(args)
+	if *maxViolationsPtr < 0 {
+		fmt.Println("ERROR: 'max-violations' must not be negative")
+		return 1
+	}
+
+	report := &validateSamReport{Violations: []htsformats.SamViolation{}}
+	input, format, err := htsformats.DetectFormat(reader)
+	if err == nil {
+		if format == htsformats.FormatBam {
+			err = validateSamBam(report, input, *maxViolationsPtr)
+		} else {
+			err = validateSamText(report, input, *maxViolationsPtr)
+		}
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	report.Valid = report.ViolationCount == 0 && report.Error == ""
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		return 1
+	}
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+// Package htsrunners contains cli subcommands
+//
+// Module validatesam_test tests validatesam
+package htsrunners
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ga4gh/htsget-refserver-utils/htsformats"
+
+	"github.com/kami-zh/go-capturer"
+	"github.com/stretchr/testify/assert"
+)
+
+// validateSamInvalidInput SAM with an unknown reference on line 3, and an
+// invalid CIGAR and duplicate tag on line 4
+var validateSamInvalidInput = "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n" +
+	"r1\t0\tchr2\t1\t60\t1M\t*\t0\t0\tA\tF\n" +
+	"r2\t0\tchr1\t1\t60\t2M\t*\t0\t0\tA\tF\tNM:i:0\tNM:i:0\n" +
+	"r3\t0\tchr1\t1\t60\t1M\t*\t0\t0\tA\tF\n"
+
+// validateSamTC test cases for ValidateSam
+var validateSamTC = []struct {
+	args      []string
+	input     func() io.Reader
+	expCode   int
+	expReport validateSamReport
+}{
+	{
+		[]string{},
+		func() io.Reader { return openTestFile("../../data/test/input/modify-sam.sam") },
+		0,
+		validateSamReport{true, 30, 0, false, []htsformats.SamViolation{}, ""},
+	},
+	{
+		[]string{},
+		func() io.Reader { return openTestFile("../../data/test/input/modify-sam.bam") },
+		0,
+		validateSamReport{true, 30, 0, false, []htsformats.SamViolation{}, ""},
+	},
+	{
+		[]string{},
+		func() io.Reader { return strings.NewReader(validateSamInvalidInput) },
+		1,
+		validateSamReport{false, 3, 3, false, []htsformats.SamViolation{
+			{Line: 3, Field: "RNAME", Message: "RNAME 'chr2' not found in @SQ header lines"},
+			{Line: 4, Field: "CIGAR", Message: "CIGAR query length 2 differs from SEQ length 1"},
+			{Line: 4, Field: "NM", Message: "Duplicate tag 'NM'"},
+		}, ""},
+	},
+	{
+		[]string{"-max-violations", "1"},
+		func() io.Reader { return strings.NewReader(validateSamInvalidInput) },
+		1,
+		validateSamReport{false, 3, 3, true, []htsformats.SamViolation{
+			{Line: 3, Field: "RNAME", Message: "RNAME 'chr2' not found in @SQ header lines"},
+		}, ""},
+	},
+	{
+		[]string{},
+		func() io.Reader { input, _ := longReadSam(); return strings.NewReader(input) },
+		0,
+		validateSamReport{true, 3, 0, false, []htsformats.SamViolation{}, ""},
+	},
+	{
+		[]string{},
+		func() io.Reader { return strings.NewReader("") },
+		0,
+		validateSamReport{true, 0, 0, false, []htsformats.SamViolation{}, ""},
+	},
+}
+
+// openTestFile opens a test input file, failing on error
+func openTestFile(filename string) io.Reader {
+	file, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	return file
+}
+
+// TestValidateSam tests function ValidateSam
+func TestValidateSam(t *testing.T) {
+	for _, tc := range validateSamTC {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+		var code int
+		stdout := capturer.CaptureStdout(func() {
+			code = ValidateSam(tc.args, tc.input())
+		})
+		assert.Equal(t, tc.expCode, code, tc.args)
+		var report validateSamReport
+		assert.Nil(t, json.Unmarshal([]byte(stdout), &report))
+		assert.Equal(t, tc.expReport, report, tc.args)
+	}
+}
+
+// TestValidateSamMaxViolationsError tests function ValidateSam with a negative
+// maximum number of violations
+func TestValidateSamMaxViolationsError(t *testing.T) {
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	var code int
+	stdout := capturer.CaptureStdout(func() {
+		code = ValidateSam([]string{"-max-violations", "-1"}, strings.NewReader(""))
+	})
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "ERROR: 'max-violations' must not be negative\n", stdout)
+}
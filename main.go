@@ -31,6 +31,12 @@ func run(args []string) int {
 	switch subcommand {
 	case "modify-sam":
 		return htsrunners.ModifySam(passedArgs, os.Stdin)
+	case "validate-sam":
+		return htsrunners.ValidateSam(passedArgs, os.Stdin)
+	case "ticket":
+		return htsrunners.Ticket(passedArgs)
+	case "index":
+		return htsrunners.Index(passedArgs)
 	case "help":
 		return htsrunners.Help()
 	default:
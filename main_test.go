@@ -4,6 +4,8 @@
 package main
 
 import (
+	"flag"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,6 +24,14 @@ var runTC = []struct {
 		[]string{"modify-sam"},
 		0,
 	},
+	{
+		[]string{"validate-sam"},
+		0,
+	},
+	{
+		[]string{"ticket", "-path", "data/test/input/modify-sam.sam"},
+		0,
+	},
 	{
 		[]string{"help"},
 		0,
@@ -35,6 +45,7 @@ var runTC = []struct {
 // TestRun tests run function
 func TestRun(t *testing.T) {
 	for _, tc := range runTC {
+		flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 		actualExitCode := run(tc.args)
 		assert.Equal(t, tc.expExitCode, actualExitCode)
 	}